@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
@@ -32,6 +33,12 @@ func main() {
 		os.Exit(1)
 	}
 
+	// 初始化日志
+	if err := log.Setup(cfg.Log.Options()); err != nil {
+		fmt.Printf("初始化日志失败: %v\n", err)
+		os.Exit(1)
+	}
+
 	log.Info("========================================")
 	log.Info("  Go-Tunnel-Lite Server 启动中...")
 	log.Info("========================================")
@@ -49,6 +56,23 @@ func main() {
 	log.Info("控制端口", "addr", cfg.Server.ControlAddr)
 	log.Info("等待客户端连接...")
 
+	// 监听配置文件变化，重新加载日志配置
+	watcher := config.NewWatcher(*configFile, config.DefaultWatchInterval, func() {
+		newCfg, err := config.LoadServerConfig(*configFile)
+		if err != nil {
+			log.Error("重新加载配置失败，继续使用旧配置", "error", err)
+			return
+		}
+		if err := log.Setup(newCfg.Log.Options()); err != nil {
+			log.Error("重新加载日志配置失败", "error", err)
+			return
+		}
+		log.Info("日志配置已重新加载")
+	})
+	if err := watcher.Start(); err != nil {
+		log.Warn("配置文件监听启动失败，热加载不可用", "error", err)
+	}
+
 	// 等待退出信号
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
@@ -56,8 +80,18 @@ func main() {
 
 	log.Info("收到信号，正在关闭服务...", "signal", sig)
 
-	// 优雅关闭
-	srv.Stop()
+	// 优雅关闭：排空已建立的连接，再次收到信号时立即退出
+	watcher.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.DrainTimeout)
+	defer cancel()
+	go func() {
+		sig := <-sigCh
+		log.Warn("再次收到信号，强制关闭", "signal", sig)
+		cancel()
+	}()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Warn("排空超时，已强制关闭剩余连接", "error", err)
+	}
 
 	log.Info("服务端已关闭")
 }
@@ -84,6 +118,7 @@ Go-Tunnel-Lite Server - 内网穿透服务端
     heartbeat_timeout: 90           # 心跳超时(秒)
   
   log:
-    level: "info"                   # 日志级别
+    level: "info"                   # 日志级别: debug, info, warn, error
+    format: "text"                  # 日志格式: text, json
     file: ""                        # 日志文件(空则输出到控制台)`)
 }
@@ -2,4 +2,7 @@ module github.com/chiredeqiudong-dev/go-tunnel-lite
 
 go 1.25.4
 
-require gopkg.in/yaml.v3 v3.0.1
+require (
+	github.com/klauspost/compress v1.18.0
+	gopkg.in/yaml.v3 v3.0.1
+)
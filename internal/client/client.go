@@ -1,34 +1,72 @@
 package client
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net"
+	"reflect"
 	"sync"
 	"time"
 
 	"github.com/chiredeqiudong-dev/go-tunnel-lite/internal/pkg/config"
 	"github.com/chiredeqiudong-dev/go-tunnel-lite/internal/pkg/connect"
 	"github.com/chiredeqiudong-dev/go-tunnel-lite/internal/pkg/log"
+	"github.com/chiredeqiudong-dev/go-tunnel-lite/internal/pkg/plugin"
 	"github.com/chiredeqiudong-dev/go-tunnel-lite/internal/pkg/proto"
+	"github.com/chiredeqiudong-dev/go-tunnel-lite/internal/pkg/proxy"
 )
 
 // Client 客户端
 type Client struct {
-	cfg         *config.ClientConfig
-	conn        *connect.Connect                // 控制连接
-	stopCh      chan struct{}                   // 停止信号
-	wg          sync.WaitGroup                  // 等待所有协程退出
-	running     bool                            // 运行状态
-	mu          sync.Mutex                      // 保护 running 状态
-	tunnelCache map[string]*config.TunnelConfig // 隧道配置缓存
-	processor   *BatchProcessor                 // 消息批量处理器
+	cfg           *config.ClientConfig
+	conn          *connect.Connect                         // 控制连接，重连时替换
+	connMu        sync.RWMutex                             // 保护 conn
+	clientID      string                                   // 客户端 ID，认证时生成
+	ctx           context.Context                          // 日志上下文（clientID）
+	stopCh        chan struct{}                            // 停止信号
+	wg            sync.WaitGroup                           // 等待所有协程退出
+	running       bool                                     // 运行状态
+	mu            sync.Mutex                               // 保护 running 状态
+	tunnelCache   map[string]*config.TunnelConfig          // 隧道配置缓存
+	registered    map[string]*proto.RegisterTunnelResponse // 服务端确认的隧道注册结果（压缩算法等）
+	health        map[string]*healthChecker                // 本地服务健康检查
+	targets       map[string]*targetSelector               // 本地服务地址选择和熔断状态
+	plugins       map[string]*tunnelPlugin                 // 隧道插件
+	targetsMu     sync.Mutex                               // 保护 targets 和 plugins
+	tunnelMu      sync.RWMutex                             // 保护 tunnelCache、registered 和 health
+	registerMu    sync.Mutex                               // 串行化重连注册与热加载，二者都在控制连接上收发注册消息
+	processor     *BatchProcessor                          // 消息批量处理器
+	streams       map[net.Conn]struct{}                    // 正在转发的数据连接，停止时强制关闭
+	capsMu        sync.RWMutex                             // 保护 serverVersion 和 capabilities
+	serverVersion string                                   // 服务端协议版本
+	capabilities  []string                                 // 协商后启用的能力
+	streamsMu     sync.Mutex                               // 保护 streams
+}
+
+const (
+	// 重连退避时间
+	minReconnectDelay = 1 * time.Second
+	maxReconnectDelay = 30 * time.Second
+
+	// fallbackDelay 主机名同时解析到 IPv6 和 IPv4 时，先连接的地址族在此时间内未成功则并行尝试另一族
+	fallbackDelay = 300 * time.Millisecond
+)
+
+// dialTCP 建立 TCP 连接，支持 IPv6 地址（[::1]:7000）
+// 双栈主机名按 RFC 6555（happy eyeballs）交替尝试两个地址族，IPv6 不通时不必等待超时
+func dialTCP(addr string, timeout time.Duration) (net.Conn, error) {
+	dialer := net.Dialer{Timeout: timeout, FallbackDelay: fallbackDelay}
+	return dialer.Dial("tcp", addr)
 }
 
 // NewClient 创建客户端
 func NewClient(cfg *config.ClientConfig) *Client {
 	client := &Client{
-		cfg:    cfg,
-		stopCh: make(chan struct{}),
+		cfg:     cfg,
+		ctx:     context.Background(),
+		stopCh:  make(chan struct{}),
+		streams: make(map[net.Conn]struct{}),
 	}
 
 	// 初始化批量处理器
@@ -48,27 +86,27 @@ func (c *Client) Start() error {
 	c.mu.Unlock()
 
 	// 初始化隧道配置缓存
+	c.tunnelMu.Lock()
 	c.tunnelCache = make(map[string]*config.TunnelConfig)
+	c.registered = make(map[string]*proto.RegisterTunnelResponse)
 	for i := range c.cfg.Client.Tunnels {
 		c.tunnelCache[c.cfg.Client.Tunnels[i].Name] = &c.cfg.Client.Tunnels[i]
 	}
+	c.tunnelMu.Unlock()
 
-	// 连接服务端
-	if err := c.connect(); err != nil {
+	// 连接、认证并注册隧道，启动时任一隧道注册失败都视为启动失败
+	if err := c.establish(); err != nil {
+		if errors.Is(err, errTunnelRejected) {
+			c.control().Close()
+		}
 		return err
 	}
 
-	// 认证
-	if err := c.authenticate(); err != nil {
-		c.conn.Close()
-		return err
-	}
-
-	// 注册隧道
-	if err := c.registerTunnels(); err != nil {
-		c.conn.Close()
-		return err
-	}
+	// 启动本地服务健康检查
+	c.tunnelMu.Lock()
+	reports := c.syncHealthChecks()
+	c.tunnelMu.Unlock()
+	c.sendHealthReports(reports)
 
 	// 启动批量处理器
 	c.processor.Start()
@@ -99,29 +137,173 @@ func (c *Client) Stop() {
 	close(c.stopCh)
 
 	// 关闭控制连接
-	if c.conn != nil {
-		c.conn.Close()
+	if conn := c.control(); conn != nil {
+		conn.Close()
 	}
 
 	// 停止批量处理器
 	c.processor.Stop()
 
+	// 停止插件，插件处理中的连接随下面的数据流一起关闭
+	c.closePlugins()
+
+	// 强制关闭仍在转发的数据连接，两端转发和插件处理随之结束
+	c.streamsMu.Lock()
+	for conn := range c.streams {
+		conn.Close()
+	}
+	c.streamsMu.Unlock()
+
 	// 等待所有协程退出
 	c.wg.Wait()
 	log.Info("客户端已停止")
 }
 
+// Shutdown 优雅停止客户端
+// 通知服务端停止接受该客户端隧道的新连接，等待已建立的数据流结束，超过 ctx 期限后强制关闭
+func (c *Client) Shutdown(ctx context.Context) error {
+	c.mu.Lock()
+	running := c.running
+	c.mu.Unlock()
+	if !running {
+		return nil
+	}
+
+	log.InfoContext(c.ctx, "客户端进入排空模式，等待已建立的连接结束...")
+
+	timeout := 0
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = int(time.Until(deadline).Seconds())
+	}
+	data, err := proto.EncodeAs(c.control().Encoding(), &proto.DrainNotice{Message: "客户端即将关闭", Timeout: timeout})
+	if err == nil {
+		err = c.control().WriteMessage(&proto.Message{Type: proto.TypeDrain, Data: data})
+	}
+	if err != nil {
+		log.WarnContext(c.ctx, "发送排空通知失败", "error", err)
+	}
+
+	err = c.waitStreams(ctx)
+	if err != nil {
+		log.WarnContext(c.ctx, "等待连接结束超时，强制关闭", "active", c.activeStreams())
+	}
+	c.Stop()
+	return err
+}
+
+// waitStreams 等待所有数据流结束
+func (c *Client) waitStreams(ctx context.Context) error {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for c.activeStreams() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// activeStreams 返回正在转发的数据流数量
+func (c *Client) activeStreams() int {
+	c.streamsMu.Lock()
+	defer c.streamsMu.Unlock()
+	return len(c.streams)
+}
+
+// addStream 记录正在转发的数据连接，停止时强制关闭
+func (c *Client) addStream(conn net.Conn) {
+	c.streamsMu.Lock()
+	c.streams[conn] = struct{}{}
+	c.streamsMu.Unlock()
+}
+
+// removeStream 数据流结束后移除数据连接
+func (c *Client) removeStream(conn net.Conn) {
+	c.streamsMu.Lock()
+	delete(c.streams, conn)
+	c.streamsMu.Unlock()
+}
+
+// HasCapability 判断与服务端协商后是否启用了指定能力
+func (c *Client) HasCapability(name string) bool {
+	c.capsMu.RLock()
+	defer c.capsMu.RUnlock()
+	return proto.HasCapability(c.capabilities, name)
+}
+
+// control 返回当前的控制连接
+func (c *Client) control() *connect.Connect {
+	c.connMu.RLock()
+	defer c.connMu.RUnlock()
+	return c.conn
+}
+
+// establish 连接服务端、认证并注册所有隧道
+// 个别隧道被服务端拒绝时其余隧道照常注册，返回的错误包含 errTunnelRejected，控制连接保持可用
+func (c *Client) establish() error {
+	// 注册期间同步读取响应，热加载不能同时在控制连接上收发注册消息
+	c.registerMu.Lock()
+	defer c.registerMu.Unlock()
+
+	// 连接服务端
+	if err := c.connect(); err != nil {
+		return err
+	}
+
+	// 认证
+	if err := c.authenticate(); err != nil {
+		c.control().Close()
+		return err
+	}
+
+	// 注册隧道
+	if err := c.registerTunnels(); err != nil {
+		if !errors.Is(err, errTunnelRejected) {
+			c.control().Close()
+		}
+		return err
+	}
+	return nil
+}
+
+// reconnect 按指数退避重新建立控制连接，客户端停止时返回 false
+func (c *Client) reconnect() bool {
+	delay := minReconnectDelay
+	for {
+		select {
+		case <-c.stopCh:
+			return false
+		case <-time.After(delay):
+		}
+
+		err := c.establish()
+		if err != nil && !errors.Is(err, errTunnelRejected) {
+			log.WarnContext(c.ctx, "重新连接服务端失败", "error", err, "retryIn", delay)
+			delay = min(delay*2, maxReconnectDelay)
+			continue
+		}
+		// 被拒绝的隧道已逐个记录，其余隧道照常工作，不因此重连
+		log.InfoContext(c.ctx, "已重新连接服务端")
+		return true
+	}
+}
+
 // connect 连接服务端
 func (c *Client) connect() error {
 	addr := c.cfg.Client.ServerAddr
 	log.Info("正在连接服务端", "addr", addr)
 
-	conn, err := net.DialTimeout("tcp", addr, 10*time.Second)
+	conn, err := dialTCP(addr, 10*time.Second)
 	if err != nil {
 		return fmt.Errorf("连接服务端失败: %w", err)
 	}
 
+	c.connMu.Lock()
 	c.conn = connect.WrapConnect(conn)
+	c.connMu.Unlock()
 	log.Info("已连接到服务端", "addr", addr)
 	return nil
 }
@@ -130,11 +312,22 @@ func (c *Client) connect() error {
 func (c *Client) authenticate() error {
 	log.Info("正在进行认证...")
 
+	// 重连时沿用同一个 clientID，服务端据此立即清理旧会话
+	// 配置了 client_id 时使用固定标识，服务端据此匹配保留端口
+	if c.clientID == "" {
+		c.clientID = c.cfg.Client.ClientID
+		if c.clientID == "" {
+			c.clientID = fmt.Sprintf("client-%d", time.Now().UnixNano())
+		}
+		c.ctx = log.WithAttrs(context.Background(), "clientID", c.clientID)
+	}
+
 	// 构造认证请求
 	authReq := &proto.AuthRequest{
-		Token:    c.cfg.Client.Token,
-		ClientID: fmt.Sprintf("client-%d", time.Now().UnixNano()),
-		Version:  "1.0.0", // 用处？
+		Token:        c.cfg.Client.Token,
+		ClientID:     c.clientID,
+		Version:      proto.ProtocolVersion,
+		Capabilities: proto.Capabilities(),
 	}
 
 	// 编码并发送
@@ -146,12 +339,12 @@ func (c *Client) authenticate() error {
 		Type: proto.TypeAuth,
 		Data: data,
 	}
-	if err := c.conn.WriteMessage(msg); err != nil {
+	if err := c.control().WriteMessage(msg); err != nil {
 		return fmt.Errorf("发送认证请求失败: %w", err)
 	}
 
 	// 读取认证响应
-	respMsg, err := c.conn.ReadMessage()
+	respMsg, err := c.control().ReadMessage()
 	if err != nil {
 		return fmt.Errorf("读取认证响应失败: %w", err)
 	}
@@ -167,37 +360,161 @@ func (c *Client) authenticate() error {
 	if !authResp.Success {
 		return fmt.Errorf("认证失败: %s", authResp.Message)
 	}
+	if err := proto.CheckVersion(authResp.Version); err != nil {
+		return err
+	}
 
-	log.Info("认证成功")
+	// 旧版本服务端不返回能力列表，仅支持二进制编码
+	capabilities := authResp.Capabilities
+	if authResp.Version == "" {
+		capabilities = []string{proto.CapBinary}
+	}
+	// 之后的消息使用协商的编码
+	c.control().SetEncoding(proto.NegotiateEncoding(capabilities))
+	if proto.HasCapability(capabilities, proto.CapFrameV2) {
+		c.control().SetFrameFlags(proto.FlagChecksum)
+	}
+
+	c.capsMu.Lock()
+	c.serverVersion = authResp.Version
+	c.capabilities = capabilities
+	c.capsMu.Unlock()
+
+	log.InfoContext(c.ctx, "认证成功", "serverVersion", authResp.Version, "capabilities", capabilities)
 	return nil
 }
 
-// registerTunnels 注册所有隧道
+// errTunnelRejected 隧道注册被服务端拒绝或服务端不支持隧道要求的功能，控制连接本身正常
+var errTunnelRejected = errors.New("隧道注册被拒绝")
+
+// registerTunnels 逐个注册所有隧道
+// 被拒绝的隧道记录日志后继续注册其余隧道，最后返回合并的 errTunnelRejected 错误；控制连接出错时立即返回
 func (c *Client) registerTunnels() error {
-	for _, tunnel := range c.cfg.Client.Tunnels {
-		if err := c.registerTunnel(tunnel); err != nil {
+	c.tunnelMu.RLock()
+	tunnels := append([]config.TunnelConfig(nil), c.cfg.Client.Tunnels...)
+	preferred := make([]int, len(tunnels))
+	for i, tunnel := range tunnels {
+		preferred[i] = c.assignedPort(tunnel)
+	}
+	c.tunnelMu.RUnlock()
+
+	var rejected []error
+	for i, tunnel := range tunnels {
+		err := c.registerTunnel(tunnel, preferred[i])
+		if errors.Is(err, errTunnelRejected) {
+			// 清除上一个会话的注册结果，服务端不会再为该隧道转发连接
+			c.tunnelMu.Lock()
+			delete(c.registered, tunnel.Name)
+			c.tunnelMu.Unlock()
+			log.ErrorContext(c.ctx, "注册隧道失败", "name", tunnel.Name, "error", err)
+			rejected = append(rejected, err)
+			continue
+		}
+		if err != nil {
 			return err
 		}
 	}
-	return nil
+	return errors.Join(rejected...)
+}
+
+// assignedPort 返回服务端上次为隧道分配的端口，重连后请求同一端口
+// 只对 remote_port 为 0 的隧道有效，调用方需持有 tunnelMu
+func (c *Client) assignedPort(tunnel config.TunnelConfig) int {
+	if resp := c.registered[tunnel.Name]; tunnel.RemotePort == 0 && resp != nil {
+		return resp.RemotePort
+	}
+	return 0
 }
 
 // registerTunnel 注册单个隧道
-func (c *Client) registerTunnel(tunnel config.TunnelConfig) error {
-	log.Info("正在注册隧道", "name", tunnel.Name, "localAddr", tunnel.LocalAddr, "remotePort", tunnel.RemotePort)
+func (c *Client) registerTunnel(tunnel config.TunnelConfig, preferredPort int) error {
+	if err := c.sendRegisterTunnel(tunnel, preferredPort); err != nil {
+		return err
+	}
+
+	// 读取响应
+	respMsg, err := c.control().ReadMessage()
+	if err != nil {
+		return fmt.Errorf("读取隧道注册响应失败: %w", err)
+	}
+
+	if respMsg.Type != proto.TypeRegisterTunnelResp {
+		return fmt.Errorf("期望隧道注册响应，收到: %s", proto.GetTypeName(respMsg.Type))
+	}
+
+	// 解码响应
+	resp, err := proto.DecodeAs[proto.RegisterTunnelResponse](c.control().Encoding(), respMsg.Data)
+	if err != nil {
+		return fmt.Errorf("解码隧道注册响应失败: %w", err)
+	}
+
+	if !resp.Success {
+		return fmt.Errorf("%w: %s: %s", errTunnelRejected, tunnel.Name, resp.Message)
+	}
+	if tunnel.Encryption && !resp.Encryption {
+		// 要求加密但服务端未确认，不能以明文转发，注销后视为被拒绝
+		if err := c.sendUnregisterTunnel(tunnel.Name); err != nil {
+			return err
+		}
+		return fmt.Errorf("%w: %s: 服务端不支持数据连接加密", errTunnelRejected, tunnel.Name)
+	}
+
+	c.tunnelMu.Lock()
+	c.registered[tunnel.Name] = resp
+	reports := c.unhealthyReports(tunnel.Name)
+	c.tunnelMu.Unlock()
+	c.sendHealthReports(reports)
+
+	log.Info("隧道注册成功", "name", tunnel.Name, "remotePort", resp.RemotePort, "compression", resp.Compression)
+	return nil
+}
+
+// checkCapabilities 检查服务端是否支持隧道要求的功能，不支持时返回 errTunnelRejected
+func (c *Client) checkCapabilities(tunnel config.TunnelConfig) error {
+	if tunnel.RemotePort == 0 && !c.HasCapability(proto.CapAutoPort) {
+		return fmt.Errorf("%w: %s: 服务端不支持自动分配端口，请配置 remote_port", errTunnelRejected, tunnel.Name)
+	}
+	if tunnel.BindAddr != "" && !c.HasCapability(proto.CapBindAddr) {
+		return fmt.Errorf("%w: %s: 服务端不支持指定监听地址，请删除 bind_addr", errTunnelRejected, tunnel.Name)
+	}
+	if tunnel.Group != "" && !c.HasCapability(proto.CapGroup) {
+		return fmt.Errorf("%w: %s: 服务端不支持负载均衡组，请删除 group", errTunnelRejected, tunnel.Name)
+	}
+	return nil
+}
+
+// sendRegisterTunnel 发送隧道注册请求，不等待响应
+// remote_port 为 0 时由服务端分配端口，preferredPort 非 0 时请求服务端优先分配该端口
+func (c *Client) sendRegisterTunnel(tunnel config.TunnelConfig, preferredPort int) error {
+	log.Info("正在注册隧道", "name", tunnel.Name, "localAddr", tunnel.Targets(), "remotePort", tunnel.RemotePort,
+		"preferredPort", preferredPort, "bindAddr", tunnel.BindAddr, "group", tunnel.Group)
+	if err := c.checkCapabilities(tunnel); err != nil {
+		return err
+	}
 
 	// 构造注册请求
+	tunnelType := tunnel.Type
+	if tunnelType == "" {
+		tunnelType = proto.TunnelTCP
+	}
 	req := &proto.RegisterTunnelRequest{
 		Tunnel: proto.TunnelConfig{
-			Name:       tunnel.Name,
-			Type:       "tcp", // 默认 tcp 类型
-			LocalAddr:  tunnel.LocalAddr,
-			RemotePort: tunnel.RemotePort,
+			Name:          tunnel.Name,
+			Type:          tunnelType,
+			LocalAddr:     tunnel.Targets()[0],
+			RemotePort:    tunnel.RemotePort,
+			Compression:   tunnel.Compression,
+			Encryption:    tunnel.Encryption,
+			PreferredPort: preferredPort,
+			BindAddr:      tunnel.BindAddr,
+			Group:         tunnel.Group,
+			Strategy:      tunnel.Strategy,
+			Weight:        tunnel.Weight,
 		},
 	}
 
 	// 编码并发送
-	data, err := proto.Encode(req)
+	data, err := proto.EncodeAs(c.control().Encoding(), req)
 	if err != nil {
 		return fmt.Errorf("编码隧道注册请求失败: %w", err)
 	}
@@ -207,32 +524,132 @@ func (c *Client) registerTunnel(tunnel config.TunnelConfig) error {
 		Data: data,
 	}
 
-	if err := c.conn.WriteMessage(msg); err != nil {
+	if err := c.control().WriteMessage(msg); err != nil {
 		return fmt.Errorf("发送隧道注册请求失败: %w", err)
 	}
+	return nil
+}
 
-	// 读取响应
-	respMsg, err := c.conn.ReadMessage()
+// sendUnregisterTunnel 发送隧道注销请求
+func (c *Client) sendUnregisterTunnel(name string) error {
+	log.Info("正在注销隧道", "name", name)
+
+	data, err := proto.EncodeAs(c.control().Encoding(), &proto.UnregisterTunnelRequest{TunnelName: name})
 	if err != nil {
-		return fmt.Errorf("读取隧道注册响应失败: %w", err)
+		return fmt.Errorf("编码隧道注销请求失败: %w", err)
 	}
 
-	if respMsg.Type != proto.TypeRegisterTunnelResp {
-		return fmt.Errorf("期望隧道注册响应，收到: %s", proto.GetTypeName(respMsg.Type))
+	msg := &proto.Message{
+		Type: proto.TypeUnregisterTunnel,
+		Data: data,
 	}
 
-	// 解码响应
-	resp, err := proto.Decode[proto.RegisterTunnelResponse](respMsg.Data)
-	if err != nil {
-		return fmt.Errorf("解码隧道注册响应失败: %w", err)
+	if err := c.control().WriteMessage(msg); err != nil {
+		return fmt.Errorf("发送隧道注销请求失败: %w", err)
 	}
+	return nil
+}
 
-	if !resp.Success {
-		return fmt.Errorf("注册隧道失败: %s", resp.Message)
+// Reload 热加载隧道配置
+// 在现有控制连接上注册新增隧道、注销移除的隧道、重新注册远程端口或监听地址等变化的隧道，
+// 仅本地地址或健康检查变化的隧道只更新缓存，不影响已建立的连接。
+// 服务端不支持隧道要求的功能时该隧道保持原配置（新增的隧道不注册），其余隧道照常生效，返回合并的 errTunnelRejected 错误；
+// 只有控制连接写入失败时中途返回。
+// server_addr、token 等连接参数的变化需要重启客户端才能生效。
+func (c *Client) Reload(newCfg *config.ClientConfig) error {
+	c.mu.Lock()
+	running := c.running
+	c.mu.Unlock()
+	if !running {
+		return fmt.Errorf("客户端未运行")
 	}
 
-	log.Info("隧道注册成功", "name", tunnel.Name, "remotePort", resp.RemotePort)
-	return nil
+	if newCfg.Client.ServerAddr != c.cfg.Client.ServerAddr || newCfg.Client.Token != c.cfg.Client.Token {
+		log.Warn("server_addr 或 token 已变化，需重启客户端后生效")
+	}
+
+	newTunnels := make(map[string]config.TunnelConfig, len(newCfg.Client.Tunnels))
+	for _, t := range newCfg.Client.Tunnels {
+		newTunnels[t.Name] = t
+	}
+
+	// 重连期间 establish 同步读取注册响应，等待其完成后再在控制连接上发送注册消息
+	c.registerMu.Lock()
+	defer c.registerMu.Unlock()
+
+	// 健康状态在释放 tunnelMu 之后上报（defer 按相反顺序执行）
+	var reports []healthReport
+	defer func() { c.sendHealthReports(reports) }()
+
+	c.tunnelMu.Lock()
+	defer c.tunnelMu.Unlock()
+
+	// 注销已移除的隧道
+	for name := range c.tunnelCache {
+		if _, ok := newTunnels[name]; ok {
+			continue
+		}
+		if err := c.sendUnregisterTunnel(name); err != nil {
+			return err
+		}
+		delete(c.tunnelCache, name)
+		delete(c.registered, name)
+		c.removeLocal(name)
+	}
+
+	// 注册新增和修改的隧道
+	var rejected []error
+	kept := make(map[string]bool)
+	for name, t := range newTunnels {
+		tunnel := t
+		old, exists := c.tunnelCache[name]
+		reregister := exists && (old.Type != tunnel.Type || old.RemotePort != tunnel.RemotePort || old.BindAddr != tunnel.BindAddr ||
+			old.Compression != tunnel.Compression || old.Encryption != tunnel.Encryption ||
+			old.Group != tunnel.Group || old.Strategy != tunnel.Strategy || old.Weight != tunnel.Weight)
+
+		// 先检查服务端能力再注销，避免服务端已删除隧道而新配置无法注册
+		if !exists || reregister {
+			if err := c.checkCapabilities(tunnel); err != nil {
+				log.Error("注册隧道失败", "name", name, "error", err)
+				rejected = append(rejected, err)
+				kept[name] = true
+				continue
+			}
+		}
+
+		switch {
+		case !exists:
+			if err := c.sendRegisterTunnel(tunnel, 0); err != nil {
+				return err
+			}
+		case reregister:
+			if err := c.sendUnregisterTunnel(name); err != nil {
+				return err
+			}
+			if err := c.sendRegisterTunnel(tunnel, c.assignedPort(tunnel)); err != nil {
+				return err
+			}
+		case reflect.DeepEqual(*old, tunnel):
+			continue
+		default:
+			log.Info("隧道本地配置已更新", "name", name, "localAddr", tunnel.Targets())
+		}
+		c.tunnelCache[name] = &tunnel
+	}
+	reports = c.syncHealthChecks()
+
+	// 未生效的隧道在配置中保留原配置，重连时与缓存一致
+	tunnels := make([]config.TunnelConfig, 0, len(newCfg.Client.Tunnels))
+	for _, t := range newCfg.Client.Tunnels {
+		if !kept[t.Name] {
+			tunnels = append(tunnels, t)
+		} else if old := c.tunnelCache[t.Name]; old != nil {
+			tunnels = append(tunnels, *old)
+		}
+	}
+	c.cfg.Client.Tunnels = tunnels
+	log.Info("隧道配置已重新加载", "count", len(c.tunnelCache))
+	return errors.Join(rejected...)
 }
 
 // messageLoop 消息处理循环
@@ -249,9 +666,9 @@ func (c *Client) messageLoop() {
 		}
 
 		// 设置读取超时
-		c.conn.SetReadDeadLine(time.Now().Add(60 * time.Second))
+		c.control().SetReadDeadLine(time.Now().Add(60 * time.Second))
 
-		msg, err := c.conn.ReadMessage()
+		msg, err := c.control().ReadFrame()
 		if err != nil {
 			select {
 			case <-c.stopCh:
@@ -260,8 +677,12 @@ func (c *Client) messageLoop() {
 				if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
 					continue // 超时，继续循环
 				}
-				log.Error("读取消息失败", "error", err)
-				return
+				log.ErrorContext(c.ctx, "读取消息失败，准备重新连接", "error", err)
+				c.control().Close()
+				if !c.reconnect() {
+					return
+				}
+				continue
 			}
 		}
 
@@ -274,6 +695,7 @@ func (c *Client) messageLoop() {
 func (c *Client) handleBatchMessages(messages []*proto.Message) {
 	for _, msg := range messages {
 		c.handleSingleMessage(msg)
+		connect.Release(msg)
 	}
 }
 
@@ -287,21 +709,64 @@ func (c *Client) handleSingleMessage(msg *proto.Message) {
 			Type: proto.TypePong,
 			Data: nil,
 		}
-		if err := c.conn.WriteMessage(pongMsg); err != nil {
+		if err := c.control().WriteMessage(pongMsg); err != nil {
 			log.Error("回复Pong失败", "error", err)
 		}
 
 	case proto.TypePong:
 		log.Debug("收到心跳响应")
 
+	case proto.TypeDrain:
+		// 服务端即将关闭：断开控制连接，由消息循环重新连接（可能连到其他实例）
+		// 已建立的数据流使用独立连接，继续转发直到服务端强制关闭
+		notice, err := proto.DecodeAs[proto.DrainNotice](c.control().Encoding(), msg.Data)
+		if err != nil {
+			log.Error("解码排空通知失败", "error", err)
+			return
+		}
+		log.WarnContext(c.ctx, "服务端即将关闭，重新连接", "message", notice.Message, "timeout", notice.Timeout)
+		c.control().Close()
+
+	case proto.TypeRegisterTunnelResp:
+		// 热加载时发出的注册请求，响应在消息循环中异步到达
+		resp, err := proto.DecodeAs[proto.RegisterTunnelResponse](c.control().Encoding(), msg.Data)
+		if err != nil {
+			log.Error("解码隧道注册响应失败", "error", err)
+			return
+		}
+		if !resp.Success {
+			log.Error("注册隧道失败", "name", resp.TunnelName, "message", resp.Message)
+			c.tunnelMu.Lock()
+			delete(c.tunnelCache, resp.TunnelName)
+			delete(c.registered, resp.TunnelName)
+			c.tunnelMu.Unlock()
+			return
+		}
+		c.tunnelMu.Lock()
+		if tunnel := c.tunnelCache[resp.TunnelName]; tunnel != nil && tunnel.Encryption && !resp.Encryption {
+			// 要求加密但服务端未确认，不能以明文转发
+			log.Error("服务端不支持数据连接加密，注销隧道", "name", resp.TunnelName)
+			delete(c.tunnelCache, resp.TunnelName)
+			c.tunnelMu.Unlock()
+			if err := c.sendUnregisterTunnel(resp.TunnelName); err != nil {
+				log.Error("注销隧道失败", "name", resp.TunnelName, "error", err)
+			}
+			return
+		}
+		c.registered[resp.TunnelName] = resp
+		reports := c.unhealthyReports(resp.TunnelName)
+		c.tunnelMu.Unlock()
+		c.sendHealthReports(reports)
+		log.Info("隧道注册成功", "name", resp.TunnelName, "remotePort", resp.RemotePort, "compression", resp.Compression)
+
 	case proto.TypeNewProxy:
 		// 解码新连接请求
-		req, err := proto.Decode[proto.NewProxyRequest](msg.Data)
+		req, err := proto.DecodeAs[proto.NewProxyRequest](c.control().Encoding(), msg.Data)
 		if err != nil {
 			log.Error("解码新连接请求失败", "error", err)
 			return
 		}
-		log.Info("收到新连接请求", "tunnel", req.TunnelName, "proxyID", req.ProxyID)
+		log.InfoContext(c.ctx, "收到新连接请求", "tunnelName", req.TunnelName, "proxyID", req.ProxyID)
 
 		// 异步处理新连接
 		go c.handleNewProxy(req)
@@ -313,25 +778,58 @@ func (c *Client) handleSingleMessage(msg *proto.Message) {
 
 // handleNewProxy 处理新代理连接请求
 func (c *Client) handleNewProxy(req *proto.NewProxyRequest) {
+	// 服务端生成的 proxyID 贯穿两端日志，用于关联同一条用户连接
+	ctx := log.WithAttrs(c.ctx, "tunnelName", req.TunnelName, "proxyID", req.ProxyID)
+
 	// 1. 从缓存中查找对应的隧道配置
+	c.tunnelMu.RLock()
 	tunnelCfg, exists := c.tunnelCache[req.TunnelName]
+	var compression string
+	var encryption bool
+	if resp := c.registered[req.TunnelName]; resp != nil {
+		compression, encryption = resp.Compression, resp.Encryption
+	}
+	c.tunnelMu.RUnlock()
 	if !exists {
-		log.Error("找不到隧道配置", "tunnelName", req.TunnelName)
+		log.ErrorContext(ctx, "找不到隧道配置")
+		c.sendProxyFailed(ctx, req.ProxyID, "找不到隧道配置")
+		return
+	}
+	if tunnelCfg.Encryption && !encryption {
+		log.ErrorContext(ctx, "隧道要求加密但服务端未确认，拒绝转发")
+		c.sendProxyFailed(ctx, req.ProxyID, "隧道要求加密但服务端未确认")
 		return
 	}
 
-	// 2. 连接本地服务
-	localConn, err := net.DialTimeout("tcp", tunnelCfg.LocalAddr, 5*time.Second)
-	if err != nil {
-		log.Error("连接本地服务失败", "localAddr", tunnelCfg.LocalAddr, "error", err)
+	// 2. 配置了插件时由插件直接处理数据连接，否则连接本地服务（多个地址时按策略选择，失败时尝试下一个）
+	var (
+		localConn net.Conn
+		handler   plugin.Plugin
+		err       error
+	)
+	if tunnelCfg.Plugin.Enabled() {
+		if handler, err = c.plugin(tunnelCfg); err != nil {
+			log.ErrorContext(ctx, "创建插件失败", "plugin", tunnelCfg.Plugin.Type, "error", err)
+			c.sendProxyFailed(ctx, req.ProxyID, fmt.Sprintf("创建插件失败: %v", err))
+			return
+		}
+	} else if localConn, err = c.dialLocal(ctx, tunnelCfg); err != nil {
+		log.ErrorContext(ctx, "连接本地服务失败", "localAddr", tunnelCfg.Targets(), "error", err)
+		c.sendProxyFailed(ctx, req.ProxyID, fmt.Sprintf("连接本地服务失败: %v", err))
 		return
 	}
+	closeLocal := func() {
+		if localConn != nil {
+			localConn.Close()
+		}
+	}
 
 	// 3. 建立到服务端的数据连接
-	serverConn, err := net.DialTimeout("tcp", c.cfg.Client.ServerAddr, 5*time.Second)
+	serverConn, err := dialTCP(c.cfg.Client.ServerAddr, 5*time.Second)
 	if err != nil {
-		localConn.Close()
-		log.Error("建立数据连接失败", "error", err)
+		closeLocal()
+		log.ErrorContext(ctx, "建立数据连接失败", "error", err)
+		c.sendProxyFailed(ctx, req.ProxyID, fmt.Sprintf("建立数据连接失败: %v", err))
 		return
 	}
 
@@ -343,9 +841,10 @@ func (c *Client) handleNewProxy(req *proto.NewProxyRequest) {
 	}
 	data, err := proto.Encode(readyReq)
 	if err != nil {
-		log.Error("编码 ProxyReady 请求失败", "error", err)
-		localConn.Close()
+		log.ErrorContext(ctx, "编码 ProxyReady 请求失败", "error", err)
+		closeLocal()
 		dataConn.Close()
+		c.sendProxyFailed(ctx, req.ProxyID, "编码 ProxyReady 请求失败")
 		return
 	}
 	readyMsg := &proto.Message{
@@ -354,22 +853,58 @@ func (c *Client) handleNewProxy(req *proto.NewProxyRequest) {
 	}
 
 	if err := dataConn.WriteMessage(readyMsg); err != nil {
-		localConn.Close()
+		closeLocal()
+		dataConn.Close()
+		log.ErrorContext(ctx, "发送 ProxyReady 失败", "error", err)
+		c.sendProxyFailed(ctx, req.ProxyID, fmt.Sprintf("发送 ProxyReady 失败: %v", err))
+		return
+	}
+
+	// 5. 按注册时协商的结果加密和压缩数据连接（与服务端顺序一致）
+	remoteConn := dataConn.RawConn()
+	if encryption {
+		remoteConn = proxy.WrapEncryption(remoteConn, c.cfg.Client.Token, req.ProxyID, proxy.CryptClient)
+	}
+	remoteConn, err = proxy.WrapCompression(remoteConn, compression)
+	if err != nil {
+		closeLocal()
 		dataConn.Close()
-		log.Error("发送 ProxyReady 失败", "error", err)
+		log.ErrorContext(ctx, "包装数据连接失败", "error", err)
 		return
 	}
 
-	log.Info("数据通道建立成功", "proxyID", req.ProxyID)
+	log.InfoContext(ctx, "数据通道建立成功")
 
-	// 5. 开始双向转发数据
-	go c.proxyData(localConn, dataConn.RawConn(), req.ProxyID)
+	// 6. 开始双向转发数据，插件直接在数据连接上处理用户请求
+	if handler != nil {
+		go c.servePlugin(ctx, handler, remoteConn)
+		return
+	}
+	go c.proxyData(ctx, localConn, remoteConn)
+}
+
+// sendProxyFailed 通知服务端无法建立代理连接，服务端立即关闭等待中的用户连接而不必等待超时
+func (c *Client) sendProxyFailed(ctx context.Context, proxyID, reason string) {
+	if !c.HasCapability(proto.CapProxyFailed) {
+		return
+	}
+	data, err := proto.EncodeAs(c.control().Encoding(), &proto.ProxyFailed{ProxyID: proxyID, Reason: reason})
+	if err == nil {
+		err = c.control().WriteMessage(&proto.Message{Type: proto.TypeProxyFailed, Data: data})
+	}
+	if err != nil {
+		log.WarnContext(ctx, "发送代理失败通知失败", "error", err)
+	}
 }
 
 // proxyData 双向转发数据（优化版本，使用内存池）
-func (c *Client) proxyData(local net.Conn, remote net.Conn, proxyID string) {
+func (c *Client) proxyData(ctx context.Context, local net.Conn, remote net.Conn) {
+	c.addStream(remote)
+	defer c.removeStream(remote)
+
 	// 使用内存池管理连接和缓冲区
-	proxyConn := NewProxyConnection(local, remote, proxyID)
+	proxyConn := proxy.NewProxyConnection(ctx, local, remote)
+	proxyConn.SetIdleTimeout(c.cfg.Client.IdleTimeout)
 	defer proxyConn.Close()
 
 	// 使用共享缓冲区进行双向转发
@@ -396,9 +931,9 @@ func (c *Client) heartbeatLoop() {
 			log.Debug("心跳循环收到停止信号")
 			return
 		case <-ticker.C:
+			// 发送失败时由消息循环负责重连
 			if err := c.sendHeartbeat(); err != nil {
 				log.Error("发送心跳失败", "error", err)
-				return
 			}
 		}
 	}
@@ -410,5 +945,5 @@ func (c *Client) sendHeartbeat() error {
 		Type: proto.TypePing,
 		Data: nil,
 	}
-	return c.conn.WriteMessage(msg)
+	return c.control().WriteMessage(msg)
 }
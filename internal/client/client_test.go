@@ -1,15 +1,24 @@
 package client
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/chiredeqiudong-dev/go-tunnel-lite/internal/pkg/config"
 	"github.com/chiredeqiudong-dev/go-tunnel-lite/internal/pkg/connect"
 	"github.com/chiredeqiudong-dev/go-tunnel-lite/internal/pkg/proto"
+	"github.com/chiredeqiudong-dev/go-tunnel-lite/internal/server"
 )
 
 // mockServer 模拟服务端，用于测试客户端
@@ -186,6 +195,11 @@ func TestClientAuthSuccess(t *testing.T) {
 		t.Fatalf("客户端启动失败: %v", err)
 	}
 
+	// mock 服务端不返回版本，按旧版本服务端处理，仅启用二进制编码
+	if !client.HasCapability(proto.CapBinary) {
+		t.Error("旧版本服务端应启用二进制编码")
+	}
+
 	// 等待一小段时间确保启动完成
 	time.Sleep(100 * time.Millisecond)
 
@@ -453,3 +467,1089 @@ func TestClientStopIdempotent(t *testing.T) {
 
 	t.Log("多次 Stop 调用成功，无 panic")
 }
+
+// TestClientReload 测试热加载隧道配置
+func TestClientReload(t *testing.T) {
+	server := newMockServer(t, "valid-token")
+	defer server.Close()
+
+	type tunnelOp struct {
+		msgType uint8
+		name    string
+	}
+	ops := make(chan tunnelOp, 16)
+
+	server.wg.Add(1)
+	go func() {
+		defer server.wg.Done()
+		conn, err := server.listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		c := connect.WrapConnect(conn)
+		c.ReadMessage()
+		respData, _ := proto.Encode(&proto.AuthResponse{Success: true})
+		c.WriteMessage(&proto.Message{Type: proto.TypeAuthResp, Data: respData})
+
+		for {
+			msg, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			switch msg.Type {
+			case proto.TypeRegisterTunnel:
+				req, _ := proto.Decode[proto.RegisterTunnelRequest](msg.Data)
+				ops <- tunnelOp{msg.Type, req.Tunnel.Name}
+				respData, _ := proto.Encode(&proto.RegisterTunnelResponse{
+					Success:    true,
+					TunnelName: req.Tunnel.Name,
+					RemotePort: req.Tunnel.RemotePort,
+				})
+				c.WriteMessage(&proto.Message{Type: proto.TypeRegisterTunnelResp, Data: respData})
+			case proto.TypeUnregisterTunnel:
+				req, _ := proto.Decode[proto.UnregisterTunnelRequest](msg.Data)
+				ops <- tunnelOp{msg.Type, req.TunnelName}
+			}
+		}
+	}()
+
+	newCfg := func(tunnels ...config.TunnelConfig) *config.ClientConfig {
+		return &config.ClientConfig{
+			Client: config.ClientSettings{
+				ServerAddr:        server.Addr(),
+				Token:             "valid-token",
+				HeartbeatInterval: 30,
+				Tunnels:           tunnels,
+			},
+		}
+	}
+	web := config.TunnelConfig{Name: "web", LocalAddr: "127.0.0.1:8080", RemotePort: 9080}
+	ssh := config.TunnelConfig{Name: "ssh", LocalAddr: "127.0.0.1:22", RemotePort: 9022}
+	db := config.TunnelConfig{Name: "db", LocalAddr: "127.0.0.1:5432", RemotePort: 9432}
+
+	client := NewClient(newCfg(web, ssh))
+	if err := client.Start(); err != nil {
+		t.Fatalf("客户端启动失败: %v", err)
+	}
+	defer client.Stop()
+
+	// 启动时注册的两个隧道
+	for i := 0; i < 2; i++ {
+		<-ops
+	}
+
+	// 移除 ssh，新增 db，修改 web 的远程端口
+	web.RemotePort = 9081
+	if err := client.Reload(newCfg(web, db)); err != nil {
+		t.Fatalf("热加载失败: %v", err)
+	}
+
+	got := make(map[tunnelOp]int)
+	for i := 0; i < 4; i++ {
+		select {
+		case op := <-ops:
+			got[op]++
+		case <-time.After(2 * time.Second):
+			t.Fatalf("超时等待隧道操作，已收到: %v", got)
+		}
+	}
+
+	want := []tunnelOp{
+		{proto.TypeUnregisterTunnel, "ssh"},
+		{proto.TypeRegisterTunnel, "db"},
+		{proto.TypeUnregisterTunnel, "web"},
+		{proto.TypeRegisterTunnel, "web"},
+	}
+	for _, op := range want {
+		if got[op] != 1 {
+			t.Errorf("期望收到 %s %s", proto.GetTypeName(op.msgType), op.name)
+		}
+	}
+
+	// 仅修改本地地址不应触发重新注册
+	web.LocalAddr = "127.0.0.1:8081"
+	if err := client.Reload(newCfg(web, db)); err != nil {
+		t.Fatalf("热加载失败: %v", err)
+	}
+	select {
+	case op := <-ops:
+		t.Fatalf("不应发送隧道操作: %s %s", proto.GetTypeName(op.msgType), op.name)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	client.tunnelMu.RLock()
+	localAddr := client.tunnelCache["web"].LocalAddr
+	_, sshExists := client.tunnelCache["ssh"]
+	client.tunnelMu.RUnlock()
+	if localAddr != "127.0.0.1:8081" {
+		t.Errorf("本地地址未更新: %s", localAddr)
+	}
+	if sshExists {
+		t.Error("已移除的隧道仍在缓存中")
+	}
+}
+
+// TestClientReloadUnsupported 测试热加载时服务端不支持的隧道保持原配置，其余隧道照常生效
+func TestClientReloadUnsupported(t *testing.T) {
+	server := newMockServer(t, "valid-token")
+	defer server.Close()
+
+	type tunnelOp struct {
+		msgType uint8
+		name    string
+	}
+	ops := make(chan tunnelOp, 16)
+
+	server.wg.Add(1)
+	go func() {
+		defer server.wg.Done()
+		conn, err := server.listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		// 服务端不声明 bind_addr 和 group 能力
+		c := connect.WrapConnect(conn)
+		c.ReadMessage()
+		respData, _ := proto.Encode(&proto.AuthResponse{Success: true})
+		c.WriteMessage(&proto.Message{Type: proto.TypeAuthResp, Data: respData})
+
+		for {
+			msg, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			switch msg.Type {
+			case proto.TypeRegisterTunnel:
+				req, _ := proto.Decode[proto.RegisterTunnelRequest](msg.Data)
+				ops <- tunnelOp{msg.Type, req.Tunnel.Name}
+				respData, _ := proto.Encode(&proto.RegisterTunnelResponse{
+					Success:    true,
+					TunnelName: req.Tunnel.Name,
+					RemotePort: req.Tunnel.RemotePort,
+				})
+				c.WriteMessage(&proto.Message{Type: proto.TypeRegisterTunnelResp, Data: respData})
+			case proto.TypeUnregisterTunnel:
+				req, _ := proto.Decode[proto.UnregisterTunnelRequest](msg.Data)
+				ops <- tunnelOp{msg.Type, req.TunnelName}
+			}
+		}
+	}()
+
+	newCfg := func(tunnels ...config.TunnelConfig) *config.ClientConfig {
+		return &config.ClientConfig{
+			Client: config.ClientSettings{
+				ServerAddr:        server.Addr(),
+				Token:             "valid-token",
+				HeartbeatInterval: 30,
+				Tunnels:           tunnels,
+			},
+		}
+	}
+	web := config.TunnelConfig{Name: "web", LocalAddr: "127.0.0.1:8080", RemotePort: 9080}
+	api := config.TunnelConfig{Name: "api", LocalAddr: "127.0.0.1:8081", RemotePort: 9081}
+	db := config.TunnelConfig{Name: "db", LocalAddr: "127.0.0.1:5432", RemotePort: 9432, Group: "db"}
+
+	client := NewClient(newCfg(web, api))
+	if err := client.Start(); err != nil {
+		t.Fatalf("客户端启动失败: %v", err)
+	}
+	defer client.Stop()
+	for i := 0; i < 2; i++ {
+		<-ops
+	}
+
+	// web 新增 bind_addr、新增带 group 的 db，均不被服务端支持；api 修改远程端口照常生效
+	changed := web
+	changed.BindAddr = "127.0.0.1"
+	api.RemotePort = 9082
+	err := client.Reload(newCfg(changed, api, db))
+	if !errors.Is(err, errTunnelRejected) {
+		t.Fatalf("热加载应返回隧道被拒绝的错误: %v", err)
+	}
+
+	got := make(map[tunnelOp]int)
+	for i := 0; i < 2; i++ {
+		select {
+		case op := <-ops:
+			got[op]++
+		case <-time.After(2 * time.Second):
+			t.Fatalf("超时等待隧道操作，已收到: %v", got)
+		}
+	}
+	select {
+	case op := <-ops:
+		t.Fatalf("不应发送隧道操作: %s %s", proto.GetTypeName(op.msgType), op.name)
+	case <-time.After(200 * time.Millisecond):
+	}
+	if got[tunnelOp{proto.TypeUnregisterTunnel, "api"}] != 1 || got[tunnelOp{proto.TypeRegisterTunnel, "api"}] != 1 {
+		t.Errorf("api 应重新注册，已收到: %v", got)
+	}
+
+	client.tunnelMu.RLock()
+	cached := client.tunnelCache["web"]
+	_, dbExists := client.tunnelCache["db"]
+	tunnels := client.cfg.Client.Tunnels
+	client.tunnelMu.RUnlock()
+	if cached == nil || cached.BindAddr != "" {
+		t.Errorf("web 应保持原配置: %+v", cached)
+	}
+	if dbExists {
+		t.Error("未注册的隧道不应进入缓存")
+	}
+	if len(tunnels) != 2 || tunnels[0].BindAddr != "" || tunnels[1].RemotePort != 9082 {
+		t.Errorf("配置应与已生效的隧道一致: %+v", tunnels)
+	}
+}
+
+// TestClientReconnectOnDrain 测试收到排空通知后客户端重新连接并注册隧道
+func TestClientReconnectOnDrain(t *testing.T) {
+	server := newMockServer(t, "valid-token")
+	defer server.Close()
+
+	registered := make(chan string, 4)
+	server.wg.Add(1)
+	go func() {
+		defer server.wg.Done()
+		for i := 0; i < 2; i++ {
+			conn, err := server.listener.Accept()
+			if err != nil {
+				return
+			}
+			c := connect.WrapConnect(conn)
+
+			msg, err := c.ReadMessage()
+			if err != nil || msg.Type != proto.TypeAuth {
+				conn.Close()
+				return
+			}
+			authReq, _ := proto.Decode[proto.AuthRequest](msg.Data)
+			respData, _ := proto.Encode(&proto.AuthResponse{Success: true})
+			c.WriteMessage(&proto.Message{Type: proto.TypeAuthResp, Data: respData})
+
+			msg, err = c.ReadMessage()
+			if err != nil || msg.Type != proto.TypeRegisterTunnel {
+				conn.Close()
+				return
+			}
+			req, _ := proto.Decode[proto.RegisterTunnelRequest](msg.Data)
+			respData, _ = proto.Encode(&proto.RegisterTunnelResponse{
+				Success:    true,
+				TunnelName: req.Tunnel.Name,
+				RemotePort: req.Tunnel.RemotePort,
+			})
+			c.WriteMessage(&proto.Message{Type: proto.TypeRegisterTunnelResp, Data: respData})
+			registered <- authReq.ClientID
+
+			if i == 0 {
+				// 第一次连接：通知客户端服务端即将关闭
+				data, _ := proto.Encode(&proto.DrainNotice{Message: "服务端即将关闭", Timeout: 5})
+				c.WriteMessage(&proto.Message{Type: proto.TypeDrain, Data: data})
+				continue
+			}
+			<-server.stopCh
+			conn.Close()
+		}
+	}()
+
+	cfg := &config.ClientConfig{
+		Client: config.ClientSettings{
+			ServerAddr:        server.Addr(),
+			Token:             "valid-token",
+			HeartbeatInterval: 30,
+			Tunnels: []config.TunnelConfig{
+				{Name: "web", LocalAddr: "127.0.0.1:8080", RemotePort: 9080},
+			},
+		},
+	}
+	client := NewClient(cfg)
+	if err := client.Start(); err != nil {
+		t.Fatalf("客户端启动失败: %v", err)
+	}
+	defer client.Stop()
+
+	var ids []string
+	for i := 0; i < 2; i++ {
+		select {
+		case id := <-registered:
+			ids = append(ids, id)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("超时等待第 %d 次注册", i+1)
+		}
+	}
+	if ids[0] != ids[1] {
+		t.Errorf("重连后 clientID 变化: %s -> %s", ids[0], ids[1])
+	}
+}
+
+// TestClientReconnectPartialRegister 测试重连后个别隧道注册失败时其余隧道照常注册，控制连接保持可用
+func TestClientReconnectPartialRegister(t *testing.T) {
+	server := newMockServer(t, "valid-token")
+	defer server.Close()
+
+	kept := make(chan error, 1)
+	server.wg.Add(1)
+	go func() {
+		defer server.wg.Done()
+		for i := 0; i < 2; i++ {
+			conn, err := server.listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			c := connect.WrapConnect(conn)
+
+			msg, err := c.ReadMessage()
+			if err != nil || msg.Type != proto.TypeAuth {
+				return
+			}
+			respData, _ := proto.Encode(&proto.AuthResponse{Success: true})
+			c.WriteMessage(&proto.Message{Type: proto.TypeAuthResp, Data: respData})
+
+			for j := 0; j < 2; j++ {
+				msg, err = c.ReadMessage()
+				if err != nil || msg.Type != proto.TypeRegisterTunnel {
+					return
+				}
+				req, _ := proto.Decode[proto.RegisterTunnelRequest](msg.Data)
+				// 重连后 web 的远程端口已被占用
+				resp := &proto.RegisterTunnelResponse{Success: true, TunnelName: req.Tunnel.Name, RemotePort: req.Tunnel.RemotePort}
+				if i == 1 && req.Tunnel.Name == "web" {
+					resp = &proto.RegisterTunnelResponse{Success: false, TunnelName: req.Tunnel.Name, Message: "端口已被占用"}
+				}
+				respData, _ = proto.Encode(resp)
+				c.WriteMessage(&proto.Message{Type: proto.TypeRegisterTunnelResp, Data: respData})
+			}
+
+			if i == 0 {
+				data, _ := proto.Encode(&proto.DrainNotice{Message: "服务端即将关闭", Timeout: 5})
+				c.WriteMessage(&proto.Message{Type: proto.TypeDrain, Data: data})
+				continue
+			}
+			// 客户端不应关闭控制连接重新连接
+			conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+			_, err = c.ReadMessage()
+			kept <- err
+		}
+	}()
+
+	cfg := &config.ClientConfig{
+		Client: config.ClientSettings{
+			ServerAddr:        server.Addr(),
+			Token:             "valid-token",
+			HeartbeatInterval: 30,
+			Tunnels: []config.TunnelConfig{
+				{Name: "web", LocalAddr: "127.0.0.1:8080", RemotePort: 9080},
+				{Name: "api", LocalAddr: "127.0.0.1:8081", RemotePort: 9081},
+			},
+		},
+	}
+	client := NewClient(cfg)
+	if err := client.Start(); err != nil {
+		t.Fatalf("客户端启动失败: %v", err)
+	}
+	defer client.Stop()
+
+	select {
+	case err := <-kept:
+		if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+			t.Fatalf("隧道注册失败后控制连接被关闭: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("超时等待重连")
+	}
+
+	client.tunnelMu.RLock()
+	_, web := client.registered["web"]
+	_, api := client.registered["api"]
+	client.tunnelMu.RUnlock()
+	if web || !api {
+		t.Errorf("重连后注册结果错误: web=%v api=%v", web, api)
+	}
+}
+
+// TestClientAutoPort 测试 remote_port 为 0 时重连后请求服务端分配同一端口
+func TestClientAutoPort(t *testing.T) {
+	server := newMockServer(t, "valid-token")
+	defer server.Close()
+
+	preferred := make(chan int, 2)
+	server.wg.Add(1)
+	go func() {
+		defer server.wg.Done()
+		for i := 0; i < 2; i++ {
+			conn, err := server.listener.Accept()
+			if err != nil {
+				return
+			}
+			c := connect.WrapConnect(conn)
+
+			if msg, err := c.ReadMessage(); err != nil || msg.Type != proto.TypeAuth {
+				conn.Close()
+				return
+			}
+			respData, _ := proto.Encode(&proto.AuthResponse{
+				Success:      true,
+				Version:      proto.ProtocolVersion,
+				Capabilities: []string{proto.CapBinary, proto.CapAutoPort},
+			})
+			c.WriteMessage(&proto.Message{Type: proto.TypeAuthResp, Data: respData})
+
+			msg, err := c.ReadMessage()
+			if err != nil || msg.Type != proto.TypeRegisterTunnel {
+				conn.Close()
+				return
+			}
+			req, _ := proto.Decode[proto.RegisterTunnelRequest](msg.Data)
+			preferred <- req.Tunnel.PreferredPort
+
+			// 第一次分配 20001，之后按客户端请求的端口分配
+			port := 20001
+			if req.Tunnel.PreferredPort != 0 {
+				port = req.Tunnel.PreferredPort
+			}
+			respData, _ = proto.Encode(&proto.RegisterTunnelResponse{
+				Success:    true,
+				TunnelName: req.Tunnel.Name,
+				RemotePort: port,
+			})
+			c.WriteMessage(&proto.Message{Type: proto.TypeRegisterTunnelResp, Data: respData})
+
+			if i == 0 {
+				conn.Close() // 断开连接，触发客户端重连
+				continue
+			}
+			<-server.stopCh
+			conn.Close()
+		}
+	}()
+
+	cfg := &config.ClientConfig{
+		Client: config.ClientSettings{
+			ServerAddr:        server.Addr(),
+			Token:             "valid-token",
+			HeartbeatInterval: 30,
+			Tunnels: []config.TunnelConfig{
+				{Name: "web", LocalAddr: "127.0.0.1:8080", RemotePort: 0},
+			},
+		},
+	}
+	client := NewClient(cfg)
+	if err := client.Start(); err != nil {
+		t.Fatalf("客户端启动失败: %v", err)
+	}
+	defer client.Stop()
+
+	want := []int{0, 20001}
+	for i := range want {
+		select {
+		case got := <-preferred:
+			if got != want[i] {
+				t.Errorf("第 %d 次注册 PreferredPort = %d, want %d", i+1, got, want[i])
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("超时等待第 %d 次注册", i+1)
+		}
+	}
+}
+
+// TestClientAutoPortUnsupported 测试服务端不支持自动分配端口时注册失败
+func TestClientAutoPortUnsupported(t *testing.T) {
+	server := newMockServer(t, "valid-token")
+	defer server.Close()
+
+	server.wg.Add(1)
+	go func() {
+		defer server.wg.Done()
+		conn, err := server.listener.Accept()
+		if err != nil {
+			return
+		}
+		server.handleConnection(conn, true, true)
+	}()
+
+	cfg := &config.ClientConfig{
+		Client: config.ClientSettings{
+			ServerAddr:        server.Addr(),
+			Token:             "valid-token",
+			HeartbeatInterval: 30,
+			Tunnels: []config.TunnelConfig{
+				{Name: "web", LocalAddr: "127.0.0.1:8080", RemotePort: 0},
+			},
+		},
+	}
+	client := NewClient(cfg)
+	if err := client.Start(); err == nil {
+		client.Stop()
+		t.Fatal("旧版本服务端不支持自动分配端口，启动应失败")
+	}
+}
+
+// TestClientConfiguredID 测试配置了 client_id 时使用固定的客户端标识
+func TestClientConfiguredID(t *testing.T) {
+	server := newMockServer(t, "valid-token")
+	defer server.Close()
+
+	server.wg.Add(1)
+	go func() {
+		defer server.wg.Done()
+		conn, err := server.listener.Accept()
+		if err != nil {
+			return
+		}
+		server.handleConnection(conn, true, true)
+	}()
+
+	cfg := &config.ClientConfig{
+		Client: config.ClientSettings{
+			ServerAddr:        server.Addr(),
+			Token:             "valid-token",
+			ClientID:          "office",
+			HeartbeatInterval: 30,
+			Tunnels: []config.TunnelConfig{
+				{Name: "web", LocalAddr: "127.0.0.1:8080", RemotePort: 9080},
+			},
+		},
+	}
+	client := NewClient(cfg)
+	if err := client.Start(); err != nil {
+		t.Fatalf("客户端启动失败: %v", err)
+	}
+	defer client.Stop()
+
+	if client.clientID != "office" {
+		t.Errorf("clientID = %q, want %q", client.clientID, "office")
+	}
+}
+
+// TestIPv6EndToEnd 测试控制连接、公网监听和本地服务均使用 IPv6 回环地址
+func TestIPv6EndToEnd(t *testing.T) {
+	// 本地回显服务
+	local, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		t.Skipf("不支持 IPv6 回环地址: %v", err)
+	}
+	defer local.Close()
+	go func() {
+		for {
+			conn, err := local.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	serverCfg := &config.ServerConfig{
+		Server: config.ServerSettings{
+			ControlAddr:   "[::1]:17020",
+			Token:         "valid-token",
+			ProxyBindAddr: "::1",
+		},
+	}
+	if err := serverCfg.Validate(); err != nil {
+		t.Fatalf("服务端配置无效: %v", err)
+	}
+	s := server.NewServer(serverCfg)
+	if err := s.Start(); err != nil {
+		t.Fatalf("启动服务端失败: %v", err)
+	}
+	defer s.Stop()
+
+	clientCfg := &config.ClientConfig{
+		Client: config.ClientSettings{
+			ServerAddr: "[::1]:17020",
+			Token:      "valid-token",
+			Tunnels: []config.TunnelConfig{
+				{Name: "echo6", LocalAddr: local.Addr().String(), RemotePort: 18025},
+			},
+		},
+	}
+	if err := clientCfg.Validate(); err != nil {
+		t.Fatalf("客户端配置无效: %v", err)
+	}
+	client := NewClient(clientCfg)
+	if err := client.Start(); err != nil {
+		t.Fatalf("客户端启动失败: %v", err)
+	}
+	defer client.Stop()
+
+	userConn, err := net.DialTimeout("tcp", "[::1]:18025", time.Second)
+	if err != nil {
+		t.Fatalf("连接 IPv6 公网端口失败: %v", err)
+	}
+	defer userConn.Close()
+	userConn.SetDeadline(time.Now().Add(3 * time.Second))
+
+	if _, err := userConn.Write([]byte("hello6")); err != nil {
+		t.Fatalf("写入失败: %v", err)
+	}
+	buf := make([]byte, 6)
+	if _, err := io.ReadFull(userConn, buf); err != nil {
+		t.Fatalf("读取回显失败: %v", err)
+	}
+	if string(buf) != "hello6" {
+		t.Errorf("回显内容 = %q, want %q", buf, "hello6")
+	}
+
+	// 只监听了 IPv6 地址
+	if c, err := net.DialTimeout("tcp", "127.0.0.1:18025", time.Second); err == nil {
+		c.Close()
+		t.Error("公网端口不应监听 IPv4 地址")
+	}
+}
+
+// TestHealthCheckEndToEnd 测试本地服务不健康时服务端直接关闭用户连接，恢复后重新转发
+func TestHealthCheckEndToEnd(t *testing.T) {
+	var status atomic.Int32
+	status.Store(http.StatusOK)
+	local := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(int(status.Load()))
+	}))
+	defer local.Close()
+
+	serverCfg := &config.ServerConfig{
+		Server: config.ServerSettings{ControlAddr: "127.0.0.1:17021", Token: "valid-token"},
+	}
+	if err := serverCfg.Validate(); err != nil {
+		t.Fatalf("服务端配置无效: %v", err)
+	}
+	s := server.NewServer(serverCfg)
+	if err := s.Start(); err != nil {
+		t.Fatalf("启动服务端失败: %v", err)
+	}
+	defer s.Stop()
+
+	clientCfg := &config.ClientConfig{
+		Client: config.ClientSettings{
+			ServerAddr: "127.0.0.1:17021",
+			Token:      "valid-token",
+			Tunnels: []config.TunnelConfig{{
+				Name:       "web",
+				LocalAddr:  local.Listener.Addr().String(),
+				RemotePort: 18027,
+				HealthCheck: config.HealthCheckConfig{
+					Type:      config.HealthCheckHTTP,
+					Interval:  50 * time.Millisecond,
+					MaxFailed: 2,
+					Path:      "/healthz",
+				},
+			}},
+		},
+	}
+	if err := clientCfg.Validate(); err != nil {
+		t.Fatalf("客户端配置无效: %v", err)
+	}
+	client := NewClient(clientCfg)
+	if err := client.Start(); err != nil {
+		t.Fatalf("客户端启动失败: %v", err)
+	}
+	defer client.Stop()
+
+	// get 通过公网端口发送一次 HTTP 请求，连接被直接关闭时返回空字符串
+	get := func() string {
+		conn, err := net.DialTimeout("tcp", "127.0.0.1:18027", time.Second)
+		if err != nil {
+			t.Fatalf("连接公网端口失败: %v", err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(3 * time.Second))
+		conn.Write([]byte("GET / HTTP/1.0\r\n\r\n"))
+		resp, _ := io.ReadAll(conn)
+		return string(resp)
+	}
+	waitFor := func(desc string, ok func(resp string) bool) {
+		t.Helper()
+		deadline := time.Now().Add(3 * time.Second)
+		for {
+			resp := get()
+			if ok(resp) {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%s: 最后一次响应 %q", desc, resp)
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+
+	if resp := get(); !strings.Contains(resp, " 200 ") {
+		t.Fatalf("健康时应正常转发: %q", resp)
+	}
+
+	status.Store(http.StatusServiceUnavailable)
+	waitFor("本地服务不健康后应直接关闭用户连接", func(resp string) bool { return resp == "" })
+
+	status.Store(http.StatusOK)
+	waitFor("本地服务恢复后应重新转发", func(resp string) bool { return strings.Contains(resp, " 200 ") })
+}
+
+// TestLocalDialFailure 测试本地服务无法连接时用户连接立即关闭，而不是等待数据连接超时
+func TestLocalDialFailure(t *testing.T) {
+	// 占用一个端口后关闭，得到一个无人监听的本地地址
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("监听失败: %v", err)
+	}
+	deadAddr := ln.Addr().String()
+	ln.Close()
+
+	serverCfg := &config.ServerConfig{
+		Server: config.ServerSettings{ControlAddr: "127.0.0.1:17023", Token: "valid-token"},
+	}
+	if err := serverCfg.Validate(); err != nil {
+		t.Fatalf("服务端配置无效: %v", err)
+	}
+	s := server.NewServer(serverCfg)
+	if err := s.Start(); err != nil {
+		t.Fatalf("启动服务端失败: %v", err)
+	}
+	defer s.Stop()
+
+	clientCfg := &config.ClientConfig{
+		Client: config.ClientSettings{
+			ServerAddr: "127.0.0.1:17023",
+			Token:      "valid-token",
+			Tunnels:    []config.TunnelConfig{{Name: "dead", LocalAddr: deadAddr, RemotePort: 18029}},
+		},
+	}
+	if err := clientCfg.Validate(); err != nil {
+		t.Fatalf("客户端配置无效: %v", err)
+	}
+	client := NewClient(clientCfg)
+	if err := client.Start(); err != nil {
+		t.Fatalf("客户端启动失败: %v", err)
+	}
+	defer client.Stop()
+
+	userConn, err := net.DialTimeout("tcp", "127.0.0.1:18029", time.Second)
+	if err != nil {
+		t.Fatalf("连接公网端口失败: %v", err)
+	}
+	defer userConn.Close()
+
+	start := time.Now()
+	userConn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := userConn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("用户连接应被关闭, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("应立即关闭而不是等待数据连接超时: %v", elapsed)
+	}
+}
+
+// TestDataDialFailure 测试本地服务可用但无法建立数据连接时同样通知服务端
+func TestDataDialFailure(t *testing.T) {
+	local, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("监听失败: %v", err)
+	}
+	defer local.Close()
+	go func() {
+		for {
+			conn, err := local.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	server := newMockServer(t, "valid-token")
+	defer server.Close()
+
+	failed := make(chan *proto.ProxyFailed, 1)
+	server.wg.Add(1)
+	go func() {
+		defer server.wg.Done()
+		conn, err := server.listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		c := connect.WrapConnect(conn)
+
+		c.ReadMessage()
+		respData, _ := proto.Encode(&proto.AuthResponse{
+			Success:      true,
+			Version:      proto.ProtocolVersion,
+			Capabilities: []string{proto.CapBinary, proto.CapProxyFailed},
+		})
+		c.WriteMessage(&proto.Message{Type: proto.TypeAuthResp, Data: respData})
+
+		msg, err := c.ReadMessage()
+		if err != nil || msg.Type != proto.TypeRegisterTunnel {
+			return
+		}
+		req, _ := proto.Decode[proto.RegisterTunnelRequest](msg.Data)
+		respData, _ = proto.Encode(&proto.RegisterTunnelResponse{Success: true, TunnelName: req.Tunnel.Name, RemotePort: req.Tunnel.RemotePort})
+		c.WriteMessage(&proto.Message{Type: proto.TypeRegisterTunnelResp, Data: respData})
+
+		// 控制端口不再接受连接，客户端无法建立数据连接
+		server.listener.Close()
+		data, _ := proto.Encode(&proto.NewProxyRequest{ProxyID: "p-1", TunnelName: req.Tunnel.Name})
+		c.WriteMessage(&proto.Message{Type: proto.TypeNewProxy, Data: data})
+
+		for {
+			msg, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			if msg.Type == proto.TypeProxyFailed {
+				notice, _ := proto.Decode[proto.ProxyFailed](msg.Data)
+				failed <- notice
+				return
+			}
+		}
+	}()
+
+	cfg := &config.ClientConfig{
+		Client: config.ClientSettings{
+			ServerAddr:        server.Addr(),
+			Token:             "valid-token",
+			HeartbeatInterval: 30,
+			Tunnels:           []config.TunnelConfig{{Name: "web", LocalAddr: local.Addr().String(), RemotePort: 9080}},
+		},
+	}
+	client := NewClient(cfg)
+	if err := client.Start(); err != nil {
+		t.Fatalf("客户端启动失败: %v", err)
+	}
+	defer client.Stop()
+
+	select {
+	case notice := <-failed:
+		if notice.ProxyID != "p-1" {
+			t.Errorf("ProxyID = %s, want p-1", notice.ProxyID)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("建立数据连接失败时未通知服务端")
+	}
+}
+
+// TestTargetSelector 测试本地地址的选择顺序和熔断
+func TestTargetSelector(t *testing.T) {
+	failover := newTargetSelector(&config.TunnelConfig{LocalAddrs: []string{"a:1", "b:1", "c:1"}})
+	if got := strings.Join(failover.order(), ","); got != "a:1,b:1,c:1" {
+		t.Errorf("failover 顺序 = %s", got)
+	}
+
+	// 连续失败达到阈值前不熔断
+	if failover.failure("a:1") {
+		t.Error("第一次失败不应熔断")
+	}
+	if got := failover.order()[0]; got != "a:1" {
+		t.Errorf("未熔断时仍应优先使用主地址, got %s", got)
+	}
+	if !failover.failure("a:1") {
+		t.Error("连续失败应熔断")
+	}
+	if got := strings.Join(failover.order(), ","); got != "b:1,c:1,a:1" {
+		t.Errorf("熔断的地址应排在最后, got %s", got)
+	}
+
+	// 冷却结束后重新尝试
+	failover.mu.Lock()
+	failover.openTill["a:1"] = time.Now().Add(-time.Second)
+	failover.mu.Unlock()
+	if got := failover.order()[0]; got != "a:1" {
+		t.Errorf("冷却结束后应重新尝试主地址, got %s", got)
+	}
+	failover.success("a:1")
+	if failover.failure("a:1") {
+		t.Error("成功后失败次数应清零")
+	}
+
+	roundRobin := newTargetSelector(&config.TunnelConfig{LocalAddrs: []string{"a:1", "b:1"}, LocalStrategy: config.LocalRoundRobin})
+	var firsts []string
+	for i := 0; i < 4; i++ {
+		firsts = append(firsts, roundRobin.order()[0])
+	}
+	if got := strings.Join(firsts, ","); got != "a:1,b:1,a:1,b:1" {
+		t.Errorf("round_robin 起始地址 = %s", got)
+	}
+}
+
+// TestDialLocalFailover 测试主地址不可用时连接备用地址
+func TestDialLocalFailover(t *testing.T) {
+	dead, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("监听失败: %v", err)
+	}
+	deadAddr := dead.Addr().String()
+	dead.Close()
+
+	standby, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("监听失败: %v", err)
+	}
+	defer standby.Close()
+	go func() {
+		for {
+			conn, err := standby.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	client := NewClient(&config.ClientConfig{})
+	tunnel := &config.TunnelConfig{Name: "db", LocalAddrs: []string{deadAddr, standby.Addr().String()}}
+	for i := 0; i < 3; i++ {
+		conn, err := client.dialLocal(client.ctx, tunnel)
+		if err != nil {
+			t.Fatalf("应连接备用地址: %v", err)
+		}
+		if got := conn.RemoteAddr().String(); got != standby.Addr().String() {
+			t.Errorf("连接地址 = %s, want %s", got, standby.Addr())
+		}
+		conn.Close()
+	}
+	if got := client.selector(tunnel).order()[0]; got != standby.Addr().String() {
+		t.Errorf("主地址熔断后应优先尝试备用地址, got %s", got)
+	}
+
+	// 所有地址都不可用时返回错误
+	standby.Close()
+	if _, err := client.dialLocal(client.ctx, tunnel); err == nil {
+		t.Error("所有地址都不可用时应返回错误")
+	}
+}
+
+// TestPluginEndToEnd 测试静态文件插件通过隧道提供服务
+func TestPluginEndToEnd(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "index.txt"), []byte("from plugin"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	serverCfg := &config.ServerConfig{
+		Server: config.ServerSettings{ControlAddr: "127.0.0.1:17024", Token: "valid-token"},
+	}
+	if err := serverCfg.Validate(); err != nil {
+		t.Fatalf("服务端配置无效: %v", err)
+	}
+	s := server.NewServer(serverCfg)
+	if err := s.Start(); err != nil {
+		t.Fatalf("启动服务端失败: %v", err)
+	}
+	defer s.Stop()
+
+	clientCfg := &config.ClientConfig{
+		Client: config.ClientSettings{
+			ServerAddr: "127.0.0.1:17024",
+			Token:      "valid-token",
+			Tunnels: []config.TunnelConfig{{
+				Name:       "files",
+				RemotePort: 18030,
+				Plugin:     config.PluginConfig{Type: config.PluginStaticFile, LocalPath: dir},
+			}},
+		},
+	}
+	if err := clientCfg.Validate(); err != nil {
+		t.Fatalf("客户端配置无效: %v", err)
+	}
+	client := NewClient(clientCfg)
+	if err := client.Start(); err != nil {
+		t.Fatalf("客户端启动失败: %v", err)
+	}
+	defer client.Stop()
+
+	httpClient := &http.Client{Timeout: 5 * time.Second}
+	for i := 0; i < 2; i++ {
+		resp, err := httpClient.Get("http://127.0.0.1:18030/index.txt")
+		if err != nil {
+			t.Fatalf("请求失败: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || string(body) != "from plugin" {
+			t.Errorf("status = %d, body = %q", resp.StatusCode, body)
+		}
+	}
+}
+
+// TestUnixSocketTarget 测试连接和检查 unix socket 本地服务
+func TestUnixSocketTarget(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "app.sock")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Skipf("当前平台不支持 unix socket: %v", err)
+	}
+	backend := &httptest.Server{
+		Listener: ln,
+		Config: &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/healthz" {
+				w.WriteHeader(http.StatusNotFound)
+			}
+		})},
+	}
+	backend.Start()
+	defer backend.Close()
+
+	addr := config.UnixSocketPrefix + sock
+	client := NewClient(&config.ClientConfig{})
+	tunnel := &config.TunnelConfig{Name: "app", LocalAddr: addr}
+	conn, err := client.dialLocal(client.ctx, tunnel)
+	if err != nil {
+		t.Fatalf("连接 unix socket 失败: %v", err)
+	}
+	conn.Close()
+
+	tunnel.HealthCheck = config.HealthCheckConfig{Type: config.HealthCheckHTTP, Path: "/healthz", Timeout: time.Second}
+	h := newHealthChecker(client.ctx, tunnel, true, func(string, bool, string) {})
+	if err := h.check(); err != nil {
+		t.Errorf("unix socket 的 http 检查应通过: %v", err)
+	}
+	tunnel.HealthCheck.Type = config.HealthCheckTCP
+	if err := newHealthChecker(client.ctx, tunnel, true, func(string, bool, string) {}).check(); err != nil {
+		t.Errorf("unix socket 的 tcp 检查应通过: %v", err)
+	}
+
+	backend.Close()
+	if _, err := client.dialLocal(client.ctx, tunnel); err == nil {
+		t.Error("socket 关闭后连接应失败")
+	}
+}
+
+// TestHealthReportsOutsideLock 测试持有 tunnelMu 时只收集健康状态，控制连接阻塞也不会卡住
+func TestHealthReportsOutsideLock(t *testing.T) {
+	// 对端从不读取的控制连接，任何写入都会阻塞
+	conn, peer := net.Pipe()
+	defer conn.Close()
+	defer peer.Close()
+
+	client := NewClient(&config.ClientConfig{})
+	client.conn = connect.WrapConnect(conn)
+	client.capabilities = []string{proto.CapHealth}
+	unhealthy := &healthChecker{name: "web", stopCh: make(chan struct{}), healthy: false, message: "connection refused"}
+	client.health = map[string]*healthChecker{"web": unhealthy}
+	client.tunnelCache = map[string]*config.TunnelConfig{"web": {Name: "web", LocalAddr: "127.0.0.1:80"}}
+
+	done := make(chan []healthReport, 1)
+	go func() {
+		client.tunnelMu.Lock()
+		defer client.tunnelMu.Unlock()
+		// 不健康的隧道重新上报，移除了健康检查的隧道撤销不健康状态
+		reports := client.unhealthyReports("web")
+		reports = append(reports, client.syncHealthChecks()...)
+		done <- reports
+	}()
+
+	select {
+	case reports := <-done:
+		want := []healthReport{{name: "web", healthy: false, message: "connection refused"}, {name: "web", healthy: true}}
+		if fmt.Sprint(reports) != fmt.Sprint(want) {
+			t.Errorf("reports = %v, want %v", reports, want)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("持有 tunnelMu 时不应写入控制连接")
+	}
+}
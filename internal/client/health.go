@@ -0,0 +1,292 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/chiredeqiudong-dev/go-tunnel-lite/internal/pkg/config"
+	"github.com/chiredeqiudong-dev/go-tunnel-lite/internal/pkg/log"
+	"github.com/chiredeqiudong-dev/go-tunnel-lite/internal/pkg/proto"
+)
+
+/*
+本地服务健康检查
+1. 配置了 health_check 的隧道由独立协程按 interval 检查本地服务，配置了多个地址时任一地址通过即健康
+2. 连续失败 max_failed 次判定为不健康，一次成功即恢复健康
+3. 状态变化时通过 TunnelHealth 上报服务端，服务端直接拒绝不健康隧道的用户连接，负载均衡组跳过该成员
+4. 重新连接或重新注册隧道后，服务端状态重置为健康，需要重新上报不健康的隧道
+*/
+
+// healthChecker 单个隧道的健康检查
+type healthChecker struct {
+	name   string
+	addrs  []string // 本地服务地址，任一地址检查通过即健康
+	cfg    config.HealthCheckConfig
+	http   map[string]*http.Client // http 检查使用，每个地址一个，不复用连接，每次检查都重新建立
+	report func(name string, healthy bool, message string)
+	ctx    context.Context // 日志上下文（隧道名）
+	stopCh chan struct{}
+
+	mu       sync.Mutex
+	healthy  bool
+	failures int    // 连续失败次数
+	message  string // 最近一次失败的原因
+}
+
+// newHealthChecker 创建健康检查，healthy 为初始状态，配置变更重建检查时沿用之前的状态
+func newHealthChecker(ctx context.Context, tunnel *config.TunnelConfig, healthy bool,
+	report func(name string, healthy bool, message string)) *healthChecker {
+	h := &healthChecker{
+		name:    tunnel.Name,
+		addrs:   slices.Clone(tunnel.Targets()),
+		cfg:     tunnel.HealthCheck,
+		report:  report,
+		ctx:     log.WithAttrs(ctx, "tunnelName", tunnel.Name),
+		stopCh:  make(chan struct{}),
+		healthy: healthy,
+	}
+	if h.cfg.Type == config.HealthCheckHTTP {
+		h.http = make(map[string]*http.Client, len(h.addrs))
+		for _, addr := range h.addrs {
+			h.http[addr] = newHealthHTTPClient(addr, h.cfg.Timeout)
+		}
+	}
+	return h
+}
+
+// newHealthHTTPClient 创建检查 addr 的 HTTP 客户端，始终连接 addr，unix socket 地址同样适用
+func newHealthHTTPClient(addr string, timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				if path, ok := config.UnixSocketPath(addr); ok {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", path)
+				}
+				d := net.Dialer{FallbackDelay: fallbackDelay}
+				return d.DialContext(ctx, network, addr)
+			},
+			DisableKeepAlives: true,
+		},
+		// 重定向视为检查结果，不跟随
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+}
+
+// run 按间隔执行检查，直到 stop 或客户端停止
+func (h *healthChecker) run(clientStop <-chan struct{}) {
+	log.DebugContext(h.ctx, "健康检查启动", "type", h.cfg.Type, "interval", h.cfg.Interval)
+
+	ticker := time.NewTicker(h.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		h.update(h.check())
+
+		select {
+		case <-clientStop:
+			return
+		case <-h.stopCh:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// stop 停止检查
+func (h *healthChecker) stop() {
+	close(h.stopCh)
+}
+
+// check 依次检查各本地服务地址，任一地址通过即健康，否则返回最后一个地址的失败原因
+func (h *healthChecker) check() error {
+	var err error
+	for _, addr := range h.addrs {
+		if err = h.checkAddr(addr); err == nil {
+			return nil
+		}
+		if len(h.addrs) > 1 {
+			err = fmt.Errorf("%s: %w", addr, err)
+		}
+	}
+	return err
+}
+
+// checkAddr 检查单个地址
+func (h *healthChecker) checkAddr(addr string) error {
+	if h.cfg.Type == config.HealthCheckHTTP {
+		return h.checkHTTP(addr)
+	}
+	conn, err := dialLocalAddr(addr, h.cfg.Timeout)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// checkHTTP 发送 GET 请求，状态码符合 expected_status（未配置时为 2xx）即健康
+func (h *healthChecker) checkHTTP(addr string) error {
+	host := addr
+	if _, ok := config.UnixSocketPath(addr); ok {
+		host = "localhost"
+	}
+	resp, err := h.http[addr].Get("http://" + host + h.cfg.Path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	if h.cfg.ExpectedStatus != 0 {
+		if resp.StatusCode != h.cfg.ExpectedStatus {
+			return fmt.Errorf("状态码 %d，期望 %d", resp.StatusCode, h.cfg.ExpectedStatus)
+		}
+		return nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("状态码 %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// update 记录检查结果，状态变化时上报
+func (h *healthChecker) update(err error) {
+	h.mu.Lock()
+	if err == nil {
+		h.failures = 0
+		if h.healthy {
+			h.mu.Unlock()
+			return
+		}
+		h.healthy, h.message = true, ""
+	} else {
+		h.failures++
+		h.message = err.Error()
+		log.DebugContext(h.ctx, "健康检查失败", "failures", h.failures, "error", err)
+		if !h.healthy || h.failures < h.cfg.MaxFailed {
+			h.mu.Unlock()
+			return
+		}
+		h.healthy = false
+	}
+	healthy, message := h.healthy, h.message
+	h.mu.Unlock()
+
+	// 已被 syncHealthChecks 替换的检查不再上报，状态由新的检查接管
+	select {
+	case <-h.stopCh:
+		return
+	default:
+	}
+
+	if healthy {
+		log.InfoContext(h.ctx, "本地服务恢复健康", "localAddr", h.addrs)
+	} else {
+		log.WarnContext(h.ctx, "本地服务不健康", "localAddr", h.addrs, "reason", message)
+	}
+	h.report(h.name, healthy, message)
+}
+
+// status 返回当前健康状态和最近一次失败的原因
+func (h *healthChecker) status() (bool, string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.healthy, h.message
+}
+
+// healthReport 待上报服务端的健康状态
+// 在 tunnelMu 内收集，释放锁后由 sendHealthReports 发送，避免控制连接阻塞时卡住持有 tunnelMu 的流程
+type healthReport struct {
+	name    string
+	healthy bool
+	message string
+}
+
+// syncHealthChecks 按隧道配置启动、重建或停止健康检查，返回需要上报的状态，调用方需持有 tunnelMu
+func (c *Client) syncHealthChecks() []healthReport {
+	if c.health == nil {
+		c.health = make(map[string]*healthChecker)
+	}
+
+	// 配置变化的检查重建后沿用之前的状态，与服务端保持一致
+	var reports []healthReport
+	previous := make(map[string]bool)
+	for name, h := range c.health {
+		tunnel := c.tunnelCache[name]
+		if tunnel != nil && tunnel.HealthCheck == h.cfg && slices.Equal(tunnel.Targets(), h.addrs) {
+			continue
+		}
+		h.stop()
+		delete(c.health, name)
+
+		healthy, _ := h.status()
+		previous[name] = healthy
+		// 隧道仍在但不再检查时，撤销之前上报的不健康状态
+		if tunnel != nil && !tunnel.HealthCheck.Enabled() && !healthy {
+			reports = append(reports, healthReport{name: name, healthy: true})
+		}
+	}
+
+	for name, tunnel := range c.tunnelCache {
+		if !tunnel.HealthCheck.Enabled() || c.health[name] != nil {
+			continue
+		}
+		healthy, ok := previous[name]
+		if !ok {
+			healthy = true
+		}
+		h := newHealthChecker(c.ctx, tunnel, healthy, c.reportHealth)
+		c.health[name] = h
+		c.wg.Add(1)
+		go func() {
+			defer c.wg.Done()
+			h.run(c.stopCh)
+		}()
+	}
+	return reports
+}
+
+// unhealthyReports 返回需要重新上报的不健康状态，服务端重新注册隧道后健康状态会被重置
+// 调用方需持有 tunnelMu
+func (c *Client) unhealthyReports(name string) []healthReport {
+	h := c.health[name]
+	if h == nil {
+		return nil
+	}
+	if healthy, message := h.status(); !healthy {
+		return []healthReport{{name: name, healthy: false, message: message}}
+	}
+	return nil
+}
+
+// sendHealthReports 上报收集到的健康状态，调用方不能持有 tunnelMu
+func (c *Client) sendHealthReports(reports []healthReport) {
+	for _, r := range reports {
+		c.reportHealth(r.name, r.healthy, r.message)
+	}
+}
+
+// reportHealth 向服务端上报隧道健康状态，服务端不支持时只在本地记录
+func (c *Client) reportHealth(name string, healthy bool, message string) {
+	if !c.HasCapability(proto.CapHealth) {
+		return
+	}
+	data, err := proto.EncodeAs(c.control().Encoding(), &proto.TunnelHealth{
+		TunnelName: name,
+		Healthy:    healthy,
+		Message:    message,
+	})
+	if err == nil {
+		err = c.control().WriteMessage(&proto.Message{Type: proto.TypeTunnelHealth, Data: data})
+	}
+	if err != nil {
+		log.WarnContext(c.ctx, "上报健康状态失败", "tunnelName", name, "error", err)
+	}
+}
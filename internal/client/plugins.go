@@ -0,0 +1,82 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/chiredeqiudong-dev/go-tunnel-lite/internal/pkg/config"
+	"github.com/chiredeqiudong-dev/go-tunnel-lite/internal/pkg/log"
+	"github.com/chiredeqiudong-dev/go-tunnel-lite/internal/pkg/plugin"
+)
+
+// tunnelPlugin 隧道使用的插件及创建时的配置，配置变化时重建
+type tunnelPlugin struct {
+	cfg       config.PluginConfig
+	localAddr string
+	plugin    plugin.Plugin
+}
+
+// servePlugin 将数据连接直接交给隧道插件处理，插件关闭连接时数据流结束
+// 数据连接支持半关闭，插件转发时 EOF 能正确传递到对端
+func (c *Client) servePlugin(ctx context.Context, p plugin.Plugin, remote net.Conn) {
+	c.addStream(remote)
+	p.Handle(ctx, &pluginConn{Conn: remote, onClose: func() { c.removeStream(remote) }})
+}
+
+// pluginConn 交给插件的数据连接，关闭时从活跃数据流中移除
+type pluginConn struct {
+	net.Conn
+	closeOnce sync.Once
+	onClose   func()
+}
+
+func (c *pluginConn) Close() error {
+	err := c.Conn.Close()
+	c.closeOnce.Do(c.onClose)
+	return err
+}
+
+// CloseWrite 半关闭数据连接，插件转发时读到 EOF 后调用
+func (c *pluginConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return errors.ErrUnsupported
+}
+
+// plugin 返回隧道的插件，首次使用或配置变化时创建
+func (c *Client) plugin(tunnel *config.TunnelConfig) (plugin.Plugin, error) {
+	c.targetsMu.Lock()
+	defer c.targetsMu.Unlock()
+
+	if tp := c.plugins[tunnel.Name]; tp != nil {
+		if tp.cfg == tunnel.Plugin && tp.localAddr == tunnel.LocalAddr {
+			return tp.plugin, nil
+		}
+		tp.plugin.Close()
+	}
+
+	p, err := plugin.New(tunnel.Plugin, tunnel.LocalAddr)
+	if err != nil {
+		return nil, err
+	}
+	if c.plugins == nil {
+		c.plugins = make(map[string]*tunnelPlugin)
+	}
+	c.plugins[tunnel.Name] = &tunnelPlugin{cfg: tunnel.Plugin, localAddr: tunnel.LocalAddr, plugin: p}
+	log.InfoContext(c.ctx, "插件已启动", "tunnelName", tunnel.Name, "plugin", tunnel.Plugin.Type)
+	return p, nil
+}
+
+// closePlugins 停止所有插件
+func (c *Client) closePlugins() {
+	c.targetsMu.Lock()
+	defer c.targetsMu.Unlock()
+
+	for name, tp := range c.plugins {
+		tp.plugin.Close()
+		delete(c.plugins, name)
+	}
+}
@@ -0,0 +1,158 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/chiredeqiudong-dev/go-tunnel-lite/internal/pkg/config"
+	"github.com/chiredeqiudong-dev/go-tunnel-lite/internal/pkg/log"
+)
+
+/*
+多个本地服务地址
+1. failover 按配置顺序尝试，第一个地址不可用时使用下一个，适合主备部署
+2. round_robin 每条连接从下一个地址开始尝试，失败时同样依次尝试其余地址
+3. 地址可以是 host:port，也可以是 unix:///path/to.sock 形式的 unix socket
+4. 每个地址有独立的熔断：连续失败 breakerThreshold 次后 breakerCooldown 内排到最后，
+   冷却结束后重新尝试一次，失败则再次熔断；所有地址都熔断时仍会依次尝试
+*/
+
+const (
+	// localDialTimeout 连接单个本地服务地址的超时时间
+	localDialTimeout = 5 * time.Second
+	// breakerThreshold 连续失败达到此次数时熔断该地址
+	breakerThreshold = 2
+	// breakerCooldown 熔断持续时间
+	breakerCooldown = 10 * time.Second
+)
+
+// targetSelector 隧道本地服务地址的选择和熔断状态
+type targetSelector struct {
+	addrs    []string
+	strategy string
+
+	mu       sync.Mutex
+	next     int                  // round_robin 的起始位置
+	failures map[string]int       // 连续失败次数
+	openTill map[string]time.Time // 熔断到期时间
+}
+
+func newTargetSelector(tunnel *config.TunnelConfig) *targetSelector {
+	return &targetSelector{
+		addrs:    slices.Clone(tunnel.Targets()),
+		strategy: tunnel.LocalStrategy,
+		failures: make(map[string]int),
+		openTill: make(map[string]time.Time),
+	}
+}
+
+// order 返回本次连接尝试地址的顺序，熔断中的地址排在最后
+func (s *targetSelector) order() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := len(s.addrs)
+	start := 0
+	if s.strategy == config.LocalRoundRobin {
+		start = s.next % n
+		s.next++
+	}
+
+	now := time.Now()
+	ordered := make([]string, 0, n)
+	var open []string
+	for i := 0; i < n; i++ {
+		addr := s.addrs[(start+i)%n]
+		if now.Before(s.openTill[addr]) {
+			open = append(open, addr)
+			continue
+		}
+		ordered = append(ordered, addr)
+	}
+	return append(ordered, open...)
+}
+
+// success 连接成功，关闭熔断
+func (s *targetSelector) success(addr string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.failures, addr)
+	delete(s.openTill, addr)
+}
+
+// failure 记录连接失败，达到阈值时熔断，返回是否熔断
+func (s *targetSelector) failure(addr string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failures[addr]++
+	if s.failures[addr] < breakerThreshold {
+		return false
+	}
+	s.openTill[addr] = time.Now().Add(breakerCooldown)
+	return true
+}
+
+// selector 返回隧道的地址选择器，本地地址或策略变化时重建
+func (c *Client) selector(tunnel *config.TunnelConfig) *targetSelector {
+	c.targetsMu.Lock()
+	defer c.targetsMu.Unlock()
+
+	if c.targets == nil {
+		c.targets = make(map[string]*targetSelector)
+	}
+	s := c.targets[tunnel.Name]
+	if s == nil || s.strategy != tunnel.LocalStrategy || !slices.Equal(s.addrs, tunnel.Targets()) {
+		s = newTargetSelector(tunnel)
+		c.targets[tunnel.Name] = s
+	}
+	return s
+}
+
+// removeLocal 清理已移除隧道的地址选择器和插件
+func (c *Client) removeLocal(name string) {
+	c.targetsMu.Lock()
+	defer c.targetsMu.Unlock()
+
+	delete(c.targets, name)
+	if tp := c.plugins[name]; tp != nil {
+		tp.plugin.Close()
+		delete(c.plugins, name)
+	}
+}
+
+// dialLocalAddr 连接单个本地服务地址，unix:// 地址连接 unix socket
+func dialLocalAddr(addr string, timeout time.Duration) (net.Conn, error) {
+	if path, ok := config.UnixSocketPath(addr); ok {
+		return net.DialTimeout("unix", path, timeout)
+	}
+	return dialTCP(addr, timeout)
+}
+
+// dialLocal 按隧道的选择策略连接本地服务，失败时依次尝试其余地址
+func (c *Client) dialLocal(ctx context.Context, tunnel *config.TunnelConfig) (net.Conn, error) {
+	s := c.selector(tunnel)
+
+	var lastErr error
+	for _, addr := range s.order() {
+		conn, err := dialLocalAddr(addr, localDialTimeout)
+		if err == nil {
+			s.success(addr)
+			return conn, nil
+		}
+		lastErr = err
+		if s.failure(addr) {
+			log.WarnContext(ctx, "本地服务地址连续失败，暂停使用", "localAddr", addr, "cooldown", breakerCooldown, "error", err)
+		} else {
+			log.WarnContext(ctx, "连接本地服务失败", "localAddr", addr, "error", err)
+		}
+	}
+	if len(s.addrs) > 1 {
+		return nil, fmt.Errorf("所有本地服务地址均不可用: %w", lastErr)
+	}
+	return nil, lastErr
+}
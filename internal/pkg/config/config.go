@@ -2,9 +2,13 @@ package config
 
 import (
 	"fmt"
+	"net"
 	"os"
+	"strings"
 	"time"
 
+	"github.com/chiredeqiudong-dev/go-tunnel-lite/internal/pkg/log"
+	"github.com/chiredeqiudong-dev/go-tunnel-lite/internal/pkg/proto"
 	"gopkg.in/yaml.v3"
 )
 
@@ -14,7 +18,9 @@ import (
 
 // ServerConfig 服务端配置
 type ServerConfig struct {
-	Server ServerSettings `yaml:"server"`
+	Server    ServerSettings `yaml:"server"`
+	Log       LogConfig      `yaml:"log"`
+	AccessLog LogConfig      `yaml:"access_log"` // 代理连接访问日志，未配置 output/file 时不记录
 }
 
 // ServerSettings 服务端详细设置
@@ -24,27 +30,292 @@ type ServerSettings struct {
 	HeartbeatInterval time.Duration `yaml:"heartbeat_interval"`
 	HeartbeatTimeout  time.Duration `yaml:"heartbeat_timeout"`
 	LogLevel          string        `yaml:"log_level"`
-	PublicPorts       []int         `yaml:"public_ports"` // 允许客户端使用的端口白名单，为空则允许所有端口
+	PublicPorts       []PortRange   `yaml:"public_ports"`       // 允许客户端使用的端口白名单，支持范围，为空则允许所有端口
+	DeniedPorts       []PortRange   `yaml:"denied_ports"`       // 禁止使用的端口，优先于其他端口配置
+	ReservedPorts     []Reservation `yaml:"reserved_ports"`     // 只允许指定客户端使用的端口
+	DrainTimeout      time.Duration `yaml:"drain_timeout"`      // 优雅关闭时等待已建立连接结束的最长时间
+	AuthTimeout       time.Duration `yaml:"auth_timeout"`       // 新连接发送首条消息的最长时间
+	MaxPendingConns   int           `yaml:"max_pending_conns"`  // 未完成认证的连接总数上限
+	MaxPendingPerIP   int           `yaml:"max_pending_per_ip"` // 单个 IP 未完成认证的连接数上限
+	IdleTimeout       time.Duration `yaml:"idle_timeout"`       // 代理连接两个方向都没有数据的最长时间，0 表示不限制
+	AutoPortRange     PortRange     `yaml:"auto_port_range"`    // remote_port 为 0 的隧道从此范围分配端口，未配置时从 public_ports 中分配
+	ProxyBindAddr     string        `yaml:"proxy_bind_addr"`    // 公网端口默认监听地址，默认 0.0.0.0，"::" 同时监听 IPv4 和 IPv6
+	AllowedBindAddrs  []string      `yaml:"allowed_bind_addrs"` // 允许隧道指定的监听地址，为空则隧道只能使用默认地址
+}
+
+// Reservation 为指定客户端保留的端口，保留的端口不受 public_ports 限制
+type Reservation struct {
+	Ports    PortRange `yaml:"ports"`
+	ClientID string    `yaml:"client_id"`
 }
 
 type ClientConfig struct {
 	Client ClientSettings `yaml:"client"`
+	Log    LogConfig      `yaml:"log"`
 }
 
 // ClientSettings 客户端详细设置
 type ClientSettings struct {
 	ServerAddr        string         `yaml:"server_addr"`
 	Token             string         `yaml:"token"`
+	ClientID          string         `yaml:"client_id"` // 客户端标识，服务端按此匹配保留端口，为空则自动生成
 	HeartbeatInterval time.Duration  `yaml:"heartbeat_interval"`
 	LogLevel          string         `yaml:"log_level"`
+	DrainTimeout      time.Duration  `yaml:"drain_timeout"` // 优雅关闭时等待已建立连接结束的最长时间
+	IdleTimeout       time.Duration  `yaml:"idle_timeout"`  // 代理连接两个方向都没有数据的最长时间，0 表示不限制
 	Tunnels           []TunnelConfig `yaml:"tunnels"`
 }
 
 // TunnelConfig 单个隧道配置
 type TunnelConfig struct {
-	Name       string `yaml:"name"`
-	LocalAddr  string `yaml:"local_addr"`
-	RemotePort int    `yaml:"remote_port"`
+	Name          string   `yaml:"name"`
+	Type          string   `yaml:"type"`           // tcp（默认）或 http，http 隧道无法连接本地服务时服务端返回 502 页面
+	LocalAddr     string   `yaml:"local_addr"`     // 本地服务地址 host:port，或 unix:///path/to.sock
+	LocalAddrs    []string `yaml:"local_addrs"`    // 多个本地服务地址，与 local_addr 二选一
+	CheckSocket   bool     `yaml:"check_socket"`   // 加载配置时检查 unix socket 存在且有读写权限
+	LocalStrategy string   `yaml:"local_strategy"` // 多个本地地址的选择策略: failover（默认）、round_robin
+	RemotePort    int      `yaml:"remote_port"`    // 0 表示由服务端分配
+	Compression   string   `yaml:"compression"`    // 数据连接压缩算法，为空表示不压缩
+	Encryption    bool     `yaml:"encryption"`     // 是否加密数据连接，密钥由 token 派生
+	BindAddr      string   `yaml:"bind_addr"`      // 服务端公网监听地址，为空使用服务端默认地址，需在服务端 allowed_bind_addrs 中
+	Group         string   `yaml:"group"`          // 负载均衡组，多个客户端加入同一组时共享公网端口
+	Strategy      string   `yaml:"strategy"`       // 负载均衡策略: round_robin（默认）、least_conn、weighted
+	Weight        int      `yaml:"weight"`         // weighted 策略下的权重，默认 1
+
+	HealthCheck HealthCheckConfig `yaml:"health_check"` // 本地服务健康检查，未配置 type 时不检查
+	Plugin      PluginConfig      `yaml:"plugin"`       // 客户端插件，代替连接本地服务处理用户连接
+}
+
+// PluginConfig 客户端内置插件，除 http2https 外不需要 local_addr
+type PluginConfig struct {
+	Type               string `yaml:"type"`                 // static_file、socks5、http_proxy、http2https，为空表示不使用插件
+	LocalPath          string `yaml:"local_path"`           // static_file: 提供访问的目录
+	StripPrefix        string `yaml:"strip_prefix"`         // static_file: 访问路径中去掉的前缀
+	Username           string `yaml:"username"`             // static_file、socks5、http_proxy: 认证用户名，static_file 为空表示不认证
+	Password           string `yaml:"password"`             // 认证密码
+	AllowAnonymous     bool   `yaml:"allow_anonymous"`      // socks5、http_proxy: 允许不配置 username，任何人都能经隧道访问客户端所在网络
+	HostHeaderRewrite  string `yaml:"host_header_rewrite"`  // http2https: 改写请求的 Host 头，为空保持原值
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"` // http2https: 不校验本地服务的证书
+}
+
+// 插件类型
+const (
+	PluginStaticFile = "static_file" // 静态文件服务
+	PluginSOCKS5     = "socks5"      // SOCKS5 代理
+	PluginHTTPProxy  = "http_proxy"  // HTTP 正向代理
+	PluginHTTP2HTTPS = "http2https"  // 将 HTTP 请求转发到 local_addr 上的 HTTPS 服务
+)
+
+// Enabled 是否使用插件
+func (p *PluginConfig) Enabled() bool {
+	return p.Type != ""
+}
+
+// NeedsLocalAddr 插件是否需要 local_addr
+func (p *PluginConfig) NeedsLocalAddr() bool {
+	return !p.Enabled() || p.Type == PluginHTTP2HTTPS
+}
+
+// validate 验证插件配置
+func (p *PluginConfig) validate() error {
+	switch p.Type {
+	case "", PluginSOCKS5, PluginHTTPProxy, PluginHTTP2HTTPS:
+	case PluginStaticFile:
+		if p.LocalPath == "" {
+			return fmt.Errorf("local_path is required for static_file")
+		}
+		if info, err := os.Stat(p.LocalPath); err != nil || !info.IsDir() {
+			return fmt.Errorf("local_path %q must be a directory", p.LocalPath)
+		}
+	default:
+		return fmt.Errorf("type must be one of static_file, socks5, http_proxy, http2https")
+	}
+	if p.Password != "" && p.Username == "" {
+		return fmt.Errorf("password requires username")
+	}
+	// 未认证的代理插件会把客户端所在网络开放给所有能访问公网端口的人，必须显式允许
+	if p.isProxy() && p.Username == "" && !p.AllowAnonymous {
+		return fmt.Errorf("%s requires username/password, or set allow_anonymous: true", p.Type)
+	}
+	if p.AllowAnonymous && !p.isProxy() {
+		return fmt.Errorf("allow_anonymous only applies to socks5 and http_proxy")
+	}
+	return nil
+}
+
+// isProxy 插件是否为可访问任意地址的代理
+func (p *PluginConfig) isProxy() bool {
+	return p.Type == PluginSOCKS5 || p.Type == PluginHTTPProxy
+}
+
+// HealthCheckConfig 本地服务健康检查
+type HealthCheckConfig struct {
+	Type           string        `yaml:"type"`            // tcp 或 http，为空表示不检查
+	Interval       time.Duration `yaml:"interval"`        // 检查间隔，默认 10s
+	Timeout        time.Duration `yaml:"timeout"`         // 单次检查超时，默认 3s 且不超过 interval
+	MaxFailed      int           `yaml:"max_failed"`      // 连续失败达到此次数判定为不健康，默认 3
+	Path           string        `yaml:"path"`            // http 检查的请求路径，默认 /
+	ExpectedStatus int           `yaml:"expected_status"` // http 检查期望的状态码，0 表示任意 2xx
+}
+
+// 健康检查类型
+const (
+	HealthCheckTCP  = "tcp"  // 能建立连接即健康（unix socket 地址同样适用）
+	HealthCheckHTTP = "http" // GET 请求返回期望的状态码即健康
+)
+
+// Enabled 是否启用健康检查
+func (h *HealthCheckConfig) Enabled() bool {
+	return h.Type != ""
+}
+
+// validate 验证健康检查配置并填充默认值
+func (h *HealthCheckConfig) validate() error {
+	switch h.Type {
+	case "":
+		return nil
+	case HealthCheckTCP, HealthCheckHTTP:
+	default:
+		return fmt.Errorf("type must be tcp or http")
+	}
+	if h.Interval <= 0 {
+		h.Interval = 10 * time.Second
+	}
+	if h.Timeout <= 0 {
+		h.Timeout = min(3*time.Second, h.Interval)
+	}
+	if h.Timeout > h.Interval {
+		return fmt.Errorf("timeout must not exceed interval")
+	}
+	if h.MaxFailed <= 0 {
+		h.MaxFailed = 3
+	}
+	if h.Type == HealthCheckHTTP {
+		if h.Path == "" {
+			h.Path = "/"
+		}
+		if !strings.HasPrefix(h.Path, "/") {
+			return fmt.Errorf("path must start with /")
+		}
+		if h.ExpectedStatus != 0 && (h.ExpectedStatus < 100 || h.ExpectedStatus > 599) {
+			return fmt.Errorf("expected_status must be a valid HTTP status code")
+		}
+	}
+	return nil
+}
+
+// 本地地址选择策略
+const (
+	LocalFailover   = "failover"    // 按配置顺序使用第一个可用的地址，适合主备部署
+	LocalRoundRobin = "round_robin" // 依次轮流使用各地址
+)
+
+// Targets 返回隧道的本地服务地址列表
+func (t *TunnelConfig) Targets() []string {
+	if len(t.LocalAddrs) > 0 {
+		return t.LocalAddrs
+	}
+	return []string{t.LocalAddr}
+}
+
+// 负载均衡策略
+const (
+	LBRoundRobin = "round_robin" // 轮询
+	LBLeastConn  = "least_conn"  // 活跃连接最少
+	LBWeighted   = "weighted"    // 平滑加权轮询
+)
+
+// ValidateStrategy 检查负载均衡策略，为空表示轮询
+func ValidateStrategy(name string) error {
+	switch name {
+	case "", LBRoundRobin, LBLeastConn, LBWeighted:
+		return nil
+	}
+	return fmt.Errorf("unsupported load balancing strategy %q", name)
+}
+
+// LogConfig 日志配置
+type LogConfig struct {
+	Level  string `yaml:"level"`  // debug/info/warn/error，为空则使用 log_level，默认 info
+	Format string `yaml:"format"` // text/json，默认 text
+	Output string `yaml:"output"` // stdout/stderr/file/syslog/journald，设置了 file 时默认 file，否则 stdout
+	File   string `yaml:"file"`   // 日志文件路径
+
+	MaxSize        int           `yaml:"max_size"`        // 单个日志文件最大大小（MB），0 表示不限制
+	RotateInterval time.Duration `yaml:"rotate_interval"` // 按时间轮转的间隔，0 表示不按时间轮转
+	MaxBackups     int           `yaml:"max_backups"`     // 最多保留的旧日志文件数，0 表示不限制
+	MaxAge         time.Duration `yaml:"max_age"`         // 旧日志文件最长保留时间，0 表示不限制
+
+	SyslogNetwork string `yaml:"syslog_network"` // tcp/udp，为空则连接本机 syslog
+	SyslogAddr    string `yaml:"syslog_addr"`
+	SyslogTag     string `yaml:"syslog_tag"`
+}
+
+// validate 验证日志配置并填充默认值
+// fallbackLevel 为 server/client 段中旧的 log_level 字段
+func (l *LogConfig) validate(fallbackLevel string) error {
+	if l.Level == "" {
+		l.Level = fallbackLevel
+	}
+	if l.Level == "" {
+		l.Level = "info"
+	}
+	if _, err := log.ParseLevel(l.Level); err != nil {
+		return fmt.Errorf("log.level must be one of debug, info, warn, error")
+	}
+
+	if l.Format == "" {
+		l.Format = log.FormatText
+	}
+	if l.Format != log.FormatText && l.Format != log.FormatJSON {
+		return fmt.Errorf("log.format must be text or json")
+	}
+
+	if l.Output == "" {
+		l.Output = log.OutputStdout
+		if l.File != "" {
+			l.Output = log.OutputFile
+		}
+	}
+	switch l.Output {
+	case log.OutputStdout, log.OutputStderr, log.OutputSyslog, log.OutputJournald:
+	case log.OutputFile:
+		if l.File == "" {
+			return fmt.Errorf("log.file is required when log.output is file")
+		}
+	default:
+		return fmt.Errorf("log.output must be one of stdout, stderr, file, syslog, journald")
+	}
+
+	if l.MaxSize < 0 || l.MaxBackups < 0 || l.RotateInterval < 0 || l.MaxAge < 0 {
+		return fmt.Errorf("log rotation settings must not be negative")
+	}
+	return nil
+}
+
+// Enabled 是否配置了输出（用于可选的日志，如访问日志）
+func (l *LogConfig) Enabled() bool {
+	return l.Output != "" || l.File != ""
+}
+
+// Options 转换为日志包的配置选项，需在 validate 之后调用
+func (l *LogConfig) Options() log.Options {
+	level, _ := log.ParseLevel(l.Level)
+	return log.Options{
+		Level:  level,
+		Format: l.Format,
+		Output: l.Output,
+		File:   l.File,
+		Rotate: log.RotateOptions{
+			MaxSize:    int64(l.MaxSize) * 1024 * 1024,
+			Interval:   l.RotateInterval,
+			MaxBackups: l.MaxBackups,
+			MaxAge:     l.MaxAge,
+		},
+		SyslogNetwork: l.SyslogNetwork,
+		SyslogAddr:    l.SyslogAddr,
+		SyslogTag:     l.SyslogTag,
+	}
 }
 
 // Validate 验证服务端配置
@@ -52,6 +323,9 @@ func (c *ServerConfig) Validate() error {
 	if c.Server.ControlAddr == "" {
 		return fmt.Errorf("server.control_addr is required")
 	}
+	if err := validateHostPort(c.Server.ControlAddr); err != nil {
+		return fmt.Errorf("server.control_addr: %w", err)
+	}
 	if c.Server.Token == "" {
 		return fmt.Errorf("server.token is required")
 	}
@@ -61,6 +335,49 @@ func (c *ServerConfig) Validate() error {
 	if c.Server.HeartbeatTimeout <= 0 {
 		c.Server.HeartbeatTimeout = 90 * time.Second // 默认90秒
 	}
+	if c.Server.DrainTimeout <= 0 {
+		c.Server.DrainTimeout = 30 * time.Second
+	}
+	if c.Server.AuthTimeout <= 0 {
+		c.Server.AuthTimeout = 10 * time.Second
+	}
+	if c.Server.MaxPendingConns <= 0 {
+		c.Server.MaxPendingConns = 1024
+	}
+	if c.Server.MaxPendingPerIP <= 0 {
+		c.Server.MaxPendingPerIP = 32
+	}
+	if c.Server.ProxyBindAddr == "" {
+		c.Server.ProxyBindAddr = "0.0.0.0"
+	}
+	if net.ParseIP(c.Server.ProxyBindAddr) == nil {
+		return fmt.Errorf("server.proxy_bind_addr must be an IP address")
+	}
+	for i, addr := range c.Server.AllowedBindAddrs {
+		if net.ParseIP(addr) == nil {
+			return fmt.Errorf("server.allowed_bind_addrs[%d] must be an IP address", i)
+		}
+	}
+	for i, r := range c.Server.ReservedPorts {
+		if r.Ports.IsZero() {
+			return fmt.Errorf("server.reserved_ports[%d].ports is required", i)
+		}
+		if r.ClientID == "" {
+			return fmt.Errorf("server.reserved_ports[%d].client_id is required", i)
+		}
+	}
+	if err := c.Log.validate(c.Server.LogLevel); err != nil {
+		return err
+	}
+	if c.AccessLog.Enabled() {
+		// 访问日志默认使用 JSON Lines
+		if c.AccessLog.Format == "" {
+			c.AccessLog.Format = log.FormatJSON
+		}
+		if err := c.AccessLog.validate("info"); err != nil {
+			return fmt.Errorf("access_%w", err)
+		}
+	}
 	return nil
 }
 
@@ -69,6 +386,9 @@ func (c *ClientConfig) Validate() error {
 	if c.Client.ServerAddr == "" {
 		return fmt.Errorf("client.server_addr is required")
 	}
+	if err := validateHostPort(c.Client.ServerAddr); err != nil {
+		return fmt.Errorf("client.server_addr: %w", err)
+	}
 	if c.Client.Token == "" {
 		return fmt.Errorf("client.token is required")
 	}
@@ -78,18 +398,125 @@ func (c *ClientConfig) Validate() error {
 	if c.Client.HeartbeatInterval <= 0 {
 		c.Client.HeartbeatInterval = 30 * time.Second
 	}
+	if c.Client.DrainTimeout <= 0 {
+		c.Client.DrainTimeout = 30 * time.Second
+	}
 
 	// 验证每个隧道配置
 	for i, t := range c.Client.Tunnels {
 		if t.Name == "" {
 			return fmt.Errorf("tunnel[%d].name is required", i)
 		}
-		if t.LocalAddr == "" {
+		if t.Type != "" && t.Type != proto.TunnelTCP && t.Type != proto.TunnelHTTP {
+			return fmt.Errorf("tunnel[%d].type must be tcp or http", i)
+		}
+		if err := c.Client.Tunnels[i].Plugin.validate(); err != nil {
+			return fmt.Errorf("tunnel[%d].plugin: %w", i, err)
+		}
+		if !t.Plugin.NeedsLocalAddr() {
+			if t.LocalAddr != "" || len(t.LocalAddrs) > 0 || t.HealthCheck.Enabled() {
+				return fmt.Errorf("tunnel[%d]: plugin %s does not use local_addr, local_addrs or health_check", i, t.Plugin.Type)
+			}
+		} else if t.LocalAddr == "" && len(t.LocalAddrs) == 0 {
 			return fmt.Errorf("tunnel[%d].local_addr is required", i)
+		} else if t.Plugin.Enabled() && t.LocalAddr == "" {
+			return fmt.Errorf("tunnel[%d]: plugin %s requires local_addr", i, t.Plugin.Type)
+		}
+		if t.LocalAddr != "" && len(t.LocalAddrs) > 0 {
+			return fmt.Errorf("tunnel[%d]: local_addr and local_addrs are mutually exclusive", i)
 		}
-		if t.RemotePort <= 0 || t.RemotePort > 65535 {
-			return fmt.Errorf("tunnel[%d].remote_port must be between 1 and 65535", i)
+		if t.LocalAddr != "" {
+			if err := validateLocalAddr(t.LocalAddr, t.CheckSocket); err != nil {
+				return fmt.Errorf("tunnel[%d].local_addr: %w", i, err)
+			}
 		}
+		for j, addr := range t.LocalAddrs {
+			if err := validateLocalAddr(addr, t.CheckSocket); err != nil {
+				return fmt.Errorf("tunnel[%d].local_addrs[%d]: %w", i, j, err)
+			}
+		}
+		if _, isUnix := UnixSocketPath(t.LocalAddr); isUnix && t.Plugin.Type == PluginHTTP2HTTPS {
+			return fmt.Errorf("tunnel[%d]: plugin %s does not support unix socket local_addr", i, t.Plugin.Type)
+		}
+		if t.CheckSocket && !t.hasUnixTarget() {
+			return fmt.Errorf("tunnel[%d].check_socket requires a unix:// local address", i)
+		}
+		switch t.LocalStrategy {
+		case "", LocalFailover, LocalRoundRobin:
+		default:
+			return fmt.Errorf("tunnel[%d].local_strategy must be failover or round_robin", i)
+		}
+		if t.RemotePort < 0 || t.RemotePort > 65535 {
+			return fmt.Errorf("tunnel[%d].remote_port must be between 0 and 65535 (0 lets the server choose)", i)
+		}
+		if t.BindAddr != "" && net.ParseIP(t.BindAddr) == nil {
+			return fmt.Errorf("tunnel[%d].bind_addr must be an IP address", i)
+		}
+		if t.Group == "" && (t.Strategy != "" || t.Weight != 0) {
+			return fmt.Errorf("tunnel[%d].strategy and weight require group", i)
+		}
+		if err := ValidateStrategy(t.Strategy); err != nil {
+			return fmt.Errorf("tunnel[%d].strategy: %w", i, err)
+		}
+		if t.Weight < 0 {
+			return fmt.Errorf("tunnel[%d].weight must not be negative", i)
+		}
+		if err := proto.ValidateCompression(t.Compression); err != nil {
+			return fmt.Errorf("tunnel[%d].compression: %w", i, err)
+		}
+		if err := c.Client.Tunnels[i].HealthCheck.validate(); err != nil {
+			return fmt.Errorf("tunnel[%d].health_check: %w", i, err)
+		}
+	}
+	return c.Log.validate(c.Client.LogLevel)
+}
+
+// UnixSocketPrefix unix socket 本地地址的前缀，如 unix:///var/run/docker.sock
+const UnixSocketPrefix = "unix://"
+
+// UnixSocketPath 返回 unix:// 地址的 socket 路径，不是 unix socket 地址时 ok 为 false
+func UnixSocketPath(addr string) (path string, ok bool) {
+	return strings.CutPrefix(addr, UnixSocketPrefix)
+}
+
+// hasUnixTarget 本地地址中是否有 unix socket
+func (t *TunnelConfig) hasUnixTarget() bool {
+	for _, addr := range t.Targets() {
+		if _, ok := UnixSocketPath(addr); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// validateLocalAddr 验证本地服务地址，unix socket 地址在 checkSocket 时检查文件和权限
+func validateLocalAddr(addr string, checkSocket bool) error {
+	path, ok := UnixSocketPath(addr)
+	if !ok {
+		return validateHostPort(addr)
+	}
+	if path == "" {
+		return fmt.Errorf("%q is missing the socket path, use unix:///path/to.sock", addr)
+	}
+	if checkSocket {
+		if err := checkUnixSocket(path); err != nil {
+			return fmt.Errorf("%q: %w", addr, err)
+		}
+	}
+	return nil
+}
+
+// validateHostPort 验证 host:port 格式的地址，IPv6 地址需要加方括号
+func validateHostPort(addr string) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("%q must be host:port, IPv6 addresses need brackets like [::1]:7000", addr)
+	}
+	if strings.Contains(host, ":") && net.ParseIP(host) == nil {
+		return fmt.Errorf("%q has an invalid IPv6 address", addr)
+	}
+	if _, err := net.LookupPort("tcp", port); err != nil {
+		return fmt.Errorf("%q has an invalid port", addr)
 	}
 	return nil
 }
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"net"
 	"os"
 	"path/filepath"
 	"testing"
@@ -37,6 +38,49 @@ server:
 	if config.Server.HeartbeatInterval != 30*time.Second {
 		t.Errorf("HeartbeatInterval = %v, want %v", config.Server.HeartbeatInterval, 30*time.Second)
 	}
+	if config.Server.ProxyBindAddr != "0.0.0.0" {
+		t.Errorf("ProxyBindAddr = %q, want %q", config.Server.ProxyBindAddr, "0.0.0.0")
+	}
+}
+
+// TestLoadServerPortPolicy 测试端口白名单、禁止端口和保留端口的加载
+func TestLoadServerPortPolicy(t *testing.T) {
+	content := `
+server:
+  control_addr: "0.0.0.0:7000"
+  token: "secret"
+  public_ports:
+    - 8080
+    - "10000-10299"
+  denied_ports:
+    - "10100-10109"
+  reserved_ports:
+    - ports: "10200-10299"
+      client_id: "office"
+`
+	tmpFile := createTempFile(t, "server-*.yaml", content)
+
+	config, err := LoadServerConfig(tmpFile)
+	if err != nil {
+		t.Fatalf("LoadServerConfig failed: %v", err)
+	}
+
+	wantPublic := []PortRange{{Min: 8080, Max: 8080}, {Min: 10000, Max: 10299}}
+	if len(config.Server.PublicPorts) != len(wantPublic) {
+		t.Fatalf("PublicPorts = %v, want %v", config.Server.PublicPorts, wantPublic)
+	}
+	for i, r := range wantPublic {
+		if config.Server.PublicPorts[i] != r {
+			t.Errorf("PublicPorts[%d] = %v, want %v", i, config.Server.PublicPorts[i], r)
+		}
+	}
+	if !ContainsPort(config.Server.DeniedPorts, 10105) || ContainsPort(config.Server.DeniedPorts, 10110) {
+		t.Errorf("DeniedPorts = %v", config.Server.DeniedPorts)
+	}
+	want := Reservation{Ports: PortRange{Min: 10200, Max: 10299}, ClientID: "office"}
+	if len(config.Server.ReservedPorts) != 1 || config.Server.ReservedPorts[0] != want {
+		t.Errorf("ReservedPorts = %+v, want %+v", config.Server.ReservedPorts, want)
+	}
 }
 
 // TestLoadClientConfig 测试客户端配置加载
@@ -80,6 +124,24 @@ client:
 	}
 }
 
+// TestHealthCheckDefaults 测试健康检查的默认值
+func TestHealthCheckDefaults(t *testing.T) {
+	h := HealthCheckConfig{Type: HealthCheckHTTP}
+	if err := h.validate(); err != nil {
+		t.Fatalf("validate() error = %v", err)
+	}
+	want := HealthCheckConfig{Type: HealthCheckHTTP, Interval: 10 * time.Second, Timeout: 3 * time.Second, MaxFailed: 3, Path: "/"}
+	if h != want {
+		t.Errorf("默认值 = %+v, want %+v", h, want)
+	}
+
+	// 间隔小于默认超时时，超时不超过间隔
+	h = HealthCheckConfig{Type: HealthCheckTCP, Interval: time.Second}
+	if err := h.validate(); err != nil || h.Timeout != time.Second {
+		t.Errorf("Timeout = %v, want 1s (err=%v)", h.Timeout, err)
+	}
+}
+
 // TestServerConfigValidation 测试服务端配置验证
 func TestServerConfigValidation(t *testing.T) {
 	tests := []struct {
@@ -109,6 +171,89 @@ server:
 			content: `
 server:
   control_addr: "0.0.0.0:7000"
+`,
+			wantErr: true,
+		},
+		{
+			name: "auto port range",
+			content: `
+server:
+  control_addr: "0.0.0.0:7000"
+  token: "secret"
+  auto_port_range: "20000-20100"
+`,
+			wantErr: false,
+		},
+		{
+			name: "reservation without client_id",
+			content: `
+server:
+  control_addr: "0.0.0.0:7000"
+  token: "secret"
+  reserved_ports:
+    - ports: "9000-9010"
+`,
+			wantErr: true,
+		},
+		{
+			name: "invalid public port range",
+			content: `
+server:
+  control_addr: "0.0.0.0:7000"
+  token: "secret"
+  public_ports:
+    - "10000-"
+`,
+			wantErr: true,
+		},
+		{
+			name: "ipv6 control_addr",
+			content: `
+server:
+  control_addr: "[::]:7000"
+  token: "secret"
+`,
+			wantErr: false,
+		},
+		{
+			name: "ipv6 control_addr without brackets",
+			content: `
+server:
+  control_addr: "::1:7000"
+  token: "secret"
+`,
+			wantErr: true,
+		},
+		{
+			name: "ipv6 bind addresses",
+			content: `
+server:
+  control_addr: "0.0.0.0:7000"
+  token: "secret"
+  proxy_bind_addr: "::"
+  allowed_bind_addrs:
+    - "10.0.0.5"
+    - "2001:db8::1"
+`,
+			wantErr: false,
+		},
+		{
+			name: "hostname bind address",
+			content: `
+server:
+  control_addr: "0.0.0.0:7000"
+  token: "secret"
+  proxy_bind_addr: "internal.example.com"
+`,
+			wantErr: true,
+		},
+		{
+			name: "reversed auto port range",
+			content: `
+server:
+  control_addr: "0.0.0.0:7000"
+  token: "secret"
+  auto_port_range: "20100-20000"
 `,
 			wantErr: true,
 		},
@@ -169,27 +314,685 @@ client:
 `,
 			wantErr: true,
 		},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			tmpFile := createTempFile(t, "client-*.yaml", tt.content)
-			defer os.Remove(tmpFile)
-
-			_, err := LoadClientConfig(tmpFile)
-			if (err != nil) != tt.wantErr {
-				t.Errorf("LoadClientConfig() error = %v, wantErr = %v", err, tt.wantErr)
-			}
-		})
-	}
-}
-
-// createTempFile 创建临时文件的辅助函数
-func createTempFile(t *testing.T, pattern, content string) string {
-	t.Helper()
-	tmpFile := filepath.Join(t.TempDir(), pattern)
-	if err := os.WriteFile(tmpFile, []byte(content), 0644); err != nil {
-		t.Fatalf("Failed to create temp file: %v", err)
-	}
-	return tmpFile
+		{
+			name: "server assigned remote_port",
+			content: `
+client:
+  server_addr: "server:7000"
+  token: "secret"
+  tunnels:
+    - name: "web"
+      local_addr: "127.0.0.1:80"
+      remote_port: 0
+`,
+			wantErr: false,
+		},
+		{
+			name: "ipv6 addresses",
+			content: `
+client:
+  server_addr: "[2001:db8::10]:7000"
+  token: "secret"
+  tunnels:
+    - name: "web"
+      local_addr: "[::1]:8080"
+      remote_port: 8080
+`,
+			wantErr: false,
+		},
+		{
+			name: "local_addr without port",
+			content: `
+client:
+  server_addr: "server:7000"
+  token: "secret"
+  tunnels:
+    - name: "web"
+      local_addr: "127.0.0.1"
+      remote_port: 8080
+`,
+			wantErr: true,
+		},
+		{
+			name: "invalid bind_addr",
+			content: `
+client:
+  server_addr: "server:7000"
+  token: "secret"
+  tunnels:
+    - name: "web"
+      local_addr: "127.0.0.1:80"
+      remote_port: 8080
+      bind_addr: "10.0.0"
+`,
+			wantErr: true,
+		},
+		{
+			name: "negative remote_port",
+			content: `
+client:
+  server_addr: "server:7000"
+  token: "secret"
+  tunnels:
+    - name: "web"
+      local_addr: "127.0.0.1:80"
+      remote_port: -1
+`,
+			wantErr: true,
+		},
+		{
+			name: "gzip compression",
+			content: `
+client:
+  server_addr: "server:7000"
+  token: "secret"
+  tunnels:
+    - name: "web"
+      local_addr: "127.0.0.1:80"
+      remote_port: 8080
+      compression: "gzip"
+      encryption: true
+`,
+			wantErr: false,
+		},
+		{
+			name: "http tunnel type",
+			content: `
+client:
+  server_addr: "server:7000"
+  token: "secret"
+  tunnels:
+    - name: "web"
+      type: "http"
+      local_addr: "127.0.0.1:80"
+      remote_port: 8080
+`,
+			wantErr: false,
+		},
+		{
+			name: "unknown tunnel type",
+			content: `
+client:
+  server_addr: "server:7000"
+  token: "secret"
+  tunnels:
+    - name: "dns"
+      type: "udp"
+      local_addr: "127.0.0.1:53"
+      remote_port: 5353
+`,
+			wantErr: true,
+		},
+		{
+			name: "zstd compression",
+			content: `
+client:
+  server_addr: "server:7000"
+  token: "secret"
+  tunnels:
+    - name: "web"
+      local_addr: "127.0.0.1:80"
+      remote_port: 8080
+      compression: "zstd"
+    - name: "ssh"
+      local_addr: "127.0.0.1:22"
+      remote_port: 2222
+      compression: "snappy"
+`,
+			wantErr: false,
+		},
+		{
+			name: "unknown compression",
+			content: `
+client:
+  server_addr: "server:7000"
+  token: "secret"
+  tunnels:
+    - name: "web"
+      local_addr: "127.0.0.1:80"
+      remote_port: 8080
+      compression: "lz4"
+`,
+			wantErr: true,
+		},
+		{
+			name: "weighted group",
+			content: `
+client:
+  server_addr: "server:7000"
+  token: "secret"
+  tunnels:
+    - name: "web"
+      local_addr: "127.0.0.1:80"
+      remote_port: 8080
+      group: "web"
+      strategy: "weighted"
+      weight: 3
+`,
+			wantErr: false,
+		},
+		{
+			name: "unknown strategy",
+			content: `
+client:
+  server_addr: "server:7000"
+  token: "secret"
+  tunnels:
+    - name: "web"
+      local_addr: "127.0.0.1:80"
+      remote_port: 8080
+      group: "web"
+      strategy: "random"
+`,
+			wantErr: true,
+		},
+		{
+			name: "weight without group",
+			content: `
+client:
+  server_addr: "server:7000"
+  token: "secret"
+  tunnels:
+    - name: "web"
+      local_addr: "127.0.0.1:80"
+      remote_port: 8080
+      weight: 2
+`,
+			wantErr: true,
+		},
+		{
+			name: "multiple local addresses",
+			content: `
+client:
+  server_addr: "server:7000"
+  token: "secret"
+  tunnels:
+    - name: "db"
+      local_addrs: ["10.0.0.1:5432", "10.0.0.2:5432"]
+      local_strategy: "failover"
+      remote_port: 5432
+`,
+			wantErr: false,
+		},
+		{
+			name: "local_addr with local_addrs",
+			content: `
+client:
+  server_addr: "server:7000"
+  token: "secret"
+  tunnels:
+    - name: "db"
+      local_addr: "10.0.0.1:5432"
+      local_addrs: ["10.0.0.2:5432"]
+      remote_port: 5432
+`,
+			wantErr: true,
+		},
+		{
+			name: "unknown local_strategy",
+			content: `
+client:
+  server_addr: "server:7000"
+  token: "secret"
+  tunnels:
+    - name: "db"
+      local_addrs: ["10.0.0.1:5432", "10.0.0.2:5432"]
+      local_strategy: "random"
+      remote_port: 5432
+`,
+			wantErr: true,
+		},
+		{
+			name: "http health check",
+			content: `
+client:
+  server_addr: "server:7000"
+  token: "secret"
+  tunnels:
+    - name: "web"
+      local_addr: "127.0.0.1:80"
+      remote_port: 8080
+      health_check:
+        type: "http"
+        interval: 5s
+        path: "/healthz"
+        expected_status: 204
+`,
+			wantErr: false,
+		},
+		{
+			name: "unknown health check type",
+			content: `
+client:
+  server_addr: "server:7000"
+  token: "secret"
+  tunnels:
+    - name: "web"
+      local_addr: "127.0.0.1:80"
+      remote_port: 8080
+      health_check:
+        type: "udp"
+`,
+			wantErr: true,
+		},
+		{
+			name: "health check timeout exceeds interval",
+			content: `
+client:
+  server_addr: "server:7000"
+  token: "secret"
+  tunnels:
+    - name: "web"
+      local_addr: "127.0.0.1:80"
+      remote_port: 8080
+      health_check:
+        type: "tcp"
+        interval: 1s
+        timeout: 2s
+`,
+			wantErr: true,
+		},
+		{
+			name: "unix socket local_addr",
+			content: `
+client:
+  server_addr: "server:7000"
+  token: "secret"
+  tunnels:
+    - name: "docker"
+      local_addr: "unix:///var/run/docker.sock"
+      remote_port: 2375
+`,
+			wantErr: false,
+		},
+		{
+			name: "unix socket without path",
+			content: `
+client:
+  server_addr: "server:7000"
+  token: "secret"
+  tunnels:
+    - name: "docker"
+      local_addr: "unix://"
+      remote_port: 2375
+`,
+			wantErr: true,
+		},
+		{
+			name: "check_socket on missing socket",
+			content: `
+client:
+  server_addr: "server:7000"
+  token: "secret"
+  tunnels:
+    - name: "docker"
+      local_addrs: ["unix:///nonexistent/docker.sock"]
+      check_socket: true
+      remote_port: 2375
+`,
+			wantErr: true,
+		},
+		{
+			name: "check_socket without unix address",
+			content: `
+client:
+  server_addr: "server:7000"
+  token: "secret"
+  tunnels:
+    - name: "web"
+      local_addr: "127.0.0.1:80"
+      check_socket: true
+      remote_port: 8080
+`,
+			wantErr: true,
+		},
+		{
+			name: "http2https over unix socket",
+			content: `
+client:
+  server_addr: "server:7000"
+  token: "secret"
+  tunnels:
+    - name: "web"
+      local_addr: "unix:///run/app.sock"
+      remote_port: 8080
+      plugin:
+        type: "http2https"
+`,
+			wantErr: true,
+		},
+		{
+			name: "socks5 plugin",
+			content: `
+client:
+  server_addr: "server:7000"
+  token: "secret"
+  tunnels:
+    - name: "proxy"
+      remote_port: 1080
+      plugin:
+        type: "socks5"
+        username: "user"
+        password: "pass"
+`,
+			wantErr: false,
+		},
+		{
+			name: "socks5 plugin without credentials",
+			content: `
+client:
+  server_addr: "server:7000"
+  token: "secret"
+  tunnels:
+    - name: "proxy"
+      remote_port: 1080
+      plugin:
+        type: "socks5"
+`,
+			wantErr: true,
+		},
+		{
+			name: "http_proxy plugin without credentials",
+			content: `
+client:
+  server_addr: "server:7000"
+  token: "secret"
+  tunnels:
+    - name: "proxy"
+      remote_port: 3128
+      plugin:
+        type: "http_proxy"
+`,
+			wantErr: true,
+		},
+		{
+			name: "anonymous socks5 plugin",
+			content: `
+client:
+  server_addr: "server:7000"
+  token: "secret"
+  tunnels:
+    - name: "proxy"
+      remote_port: 1080
+      plugin:
+        type: "socks5"
+        allow_anonymous: true
+`,
+			wantErr: false,
+		},
+		{
+			name: "allow_anonymous on static_file",
+			content: `
+client:
+  server_addr: "server:7000"
+  token: "secret"
+  tunnels:
+    - name: "files"
+      remote_port: 8081
+      plugin:
+        type: "static_file"
+        local_path: "."
+        allow_anonymous: true
+`,
+			wantErr: true,
+		},
+		{
+			name: "plugin with local_addr",
+			content: `
+client:
+  server_addr: "server:7000"
+  token: "secret"
+  tunnels:
+    - name: "proxy"
+      local_addr: "127.0.0.1:1080"
+      remote_port: 1080
+      plugin:
+        type: "http_proxy"
+`,
+			wantErr: true,
+		},
+		{
+			name: "static_file without local_path",
+			content: `
+client:
+  server_addr: "server:7000"
+  token: "secret"
+  tunnels:
+    - name: "files"
+      remote_port: 8080
+      plugin:
+        type: "static_file"
+`,
+			wantErr: true,
+		},
+		{
+			name: "http2https without local_addr",
+			content: `
+client:
+  server_addr: "server:7000"
+  token: "secret"
+  tunnels:
+    - name: "web"
+      remote_port: 8080
+      plugin:
+        type: "http2https"
+`,
+			wantErr: true,
+		},
+		{
+			name: "unknown plugin type",
+			content: `
+client:
+  server_addr: "server:7000"
+  token: "secret"
+  tunnels:
+    - name: "web"
+      remote_port: 8080
+      plugin:
+        type: "ftp"
+`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpFile := createTempFile(t, "client-*.yaml", tt.content)
+			defer os.Remove(tmpFile)
+
+			_, err := LoadClientConfig(tmpFile)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("LoadClientConfig() error = %v, wantErr = %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestParsePortRange 测试端口范围解析
+func TestParsePortRange(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    PortRange
+		wantErr bool
+	}{
+		{in: "20000-20100", want: PortRange{Min: 20000, Max: 20100}},
+		{in: " 8080 ", want: PortRange{Min: 8080, Max: 8080}},
+		{in: "1-65535", want: PortRange{Min: 1, Max: 65535}},
+		{in: "0-100", wantErr: true},
+		{in: "20100-20000", wantErr: true},
+		{in: "20000-70000", wantErr: true},
+		{in: "web", wantErr: true},
+		{in: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParsePortRange(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParsePortRange(%q) error = %v, wantErr = %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParsePortRange(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+
+	r := PortRange{Min: 20000, Max: 20009}
+	if !r.Contains(20000) || !r.Contains(20009) || r.Contains(20010) || r.Size() != 10 {
+		t.Errorf("PortRange %s 的 Contains/Size 不正确", r)
+	}
+	if (PortRange{}).Contains(0) {
+		t.Error("未配置的范围不应包含任何端口")
+	}
+}
+
+// createTempFile 创建临时文件的辅助函数
+func createTempFile(t *testing.T, pattern, content string) string {
+	t.Helper()
+	tmpFile := filepath.Join(t.TempDir(), pattern)
+	if err := os.WriteFile(tmpFile, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	return tmpFile
+}
+
+// TestWatcher 测试配置文件变更监听
+func TestWatcher(t *testing.T) {
+	tmpFile := createTempFile(t, "client-*.yaml", "client: {}\n")
+
+	changed := make(chan struct{}, 1)
+	w := NewWatcher(tmpFile, 20*time.Millisecond, func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	})
+	if err := w.Start(); err != nil {
+		t.Fatalf("Watcher.Start failed: %v", err)
+	}
+	defer w.Stop()
+
+	// 内容未变化时不应触发
+	select {
+	case <-changed:
+		t.Fatal("unexpected change notification")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if err := os.WriteFile(tmpFile, []byte("client:\n  token: \"new\"\n"), 0644); err != nil {
+		t.Fatalf("Failed to update temp file: %v", err)
+	}
+
+	select {
+	case <-changed:
+	case <-time.After(time.Second):
+		t.Fatal("change notification not received")
+	}
+}
+
+// TestLogConfig 测试日志配置解析和默认值
+func TestLogConfig(t *testing.T) {
+	tests := []struct {
+		name       string
+		content    string
+		wantErr    bool
+		wantLevel  string
+		wantOutput string
+	}{
+		{
+			name: "fallback to log_level",
+			content: `
+server:
+  control_addr: "0.0.0.0:7000"
+  token: "secret"
+  log_level: "debug"
+`,
+			wantLevel:  "debug",
+			wantOutput: "stdout",
+		},
+		{
+			name: "file output with rotation",
+			content: `
+server:
+  control_addr: "0.0.0.0:7000"
+  token: "secret"
+log:
+  level: "warn"
+  format: "json"
+  file: "/var/log/tunnel.log"
+  max_size: 100
+  max_age: 168h
+`,
+			wantLevel:  "warn",
+			wantOutput: "file",
+		},
+		{
+			name: "invalid level",
+			content: `
+server:
+  control_addr: "0.0.0.0:7000"
+  token: "secret"
+log:
+  level: "verbose"
+`,
+			wantErr: true,
+		},
+		{
+			name: "file output without file",
+			content: `
+server:
+  control_addr: "0.0.0.0:7000"
+  token: "secret"
+log:
+  output: "file"
+`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpFile := createTempFile(t, "server-*.yaml", tt.content)
+
+			cfg, err := LoadServerConfig(tmpFile)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadServerConfig() error = %v, wantErr = %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if cfg.Log.Level != tt.wantLevel {
+				t.Errorf("Log.Level = %q, want %q", cfg.Log.Level, tt.wantLevel)
+			}
+			if cfg.Log.Output != tt.wantOutput {
+				t.Errorf("Log.Output = %q, want %q", cfg.Log.Output, tt.wantOutput)
+			}
+		})
+	}
+}
+
+// TestCheckSocket 测试 check_socket 对 unix socket 的检查
+func TestCheckSocket(t *testing.T) {
+	dir := t.TempDir()
+	sock := filepath.Join(dir, "app.sock")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Skipf("当前平台不支持 unix socket: %v", err)
+	}
+	defer ln.Close()
+
+	if err := validateLocalAddr(UnixSocketPrefix+sock, true); err != nil {
+		t.Errorf("存在的 socket 应通过检查: %v", err)
+	}
+
+	file := filepath.Join(dir, "plain")
+	if err := os.WriteFile(file, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := validateLocalAddr(UnixSocketPrefix+file, true); err == nil {
+		t.Error("普通文件不应通过检查")
+	}
+	// 未开启检查时不访问文件
+	if err := validateLocalAddr(UnixSocketPrefix+filepath.Join(dir, "missing.sock"), false); err != nil {
+		t.Errorf("未开启检查时不应报错: %v", err)
+	}
 }
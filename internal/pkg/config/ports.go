@@ -0,0 +1,91 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// PortRange 端口范围，YAML 中写作 "20000-20100"，单个端口可写作 20000
+type PortRange struct {
+	Min int
+	Max int
+}
+
+// ParsePortRange 解析 "起始-结束" 或单个端口
+func ParsePortRange(s string) (PortRange, error) {
+	first, last, isRange := strings.Cut(strings.TrimSpace(s), "-")
+	if !isRange {
+		last = first
+	}
+	start, err := strconv.Atoi(strings.TrimSpace(first))
+	if err != nil {
+		return PortRange{}, fmt.Errorf("invalid port range %q", s)
+	}
+	end, err := strconv.Atoi(strings.TrimSpace(last))
+	if err != nil {
+		return PortRange{}, fmt.Errorf("invalid port range %q", s)
+	}
+	r := PortRange{Min: start, Max: end}
+	if err := r.validate(); err != nil {
+		return PortRange{}, err
+	}
+	return r, nil
+}
+
+// UnmarshalYAML 支持字符串范围和整数端口两种写法
+func (r *PortRange) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind != yaml.ScalarNode {
+		return fmt.Errorf("line %d: port range must be a string like \"20000-20100\"", value.Line)
+	}
+	parsed, err := ParsePortRange(value.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: %w", value.Line, err)
+	}
+	*r = parsed
+	return nil
+}
+
+// IsZero 是否未配置
+func (r PortRange) IsZero() bool {
+	return r.Min == 0 && r.Max == 0
+}
+
+// Contains 判断端口是否在范围内
+func (r PortRange) Contains(port int) bool {
+	return !r.IsZero() && port >= r.Min && port <= r.Max
+}
+
+// Size 范围内的端口数
+func (r PortRange) Size() int {
+	if r.IsZero() {
+		return 0
+	}
+	return r.Max - r.Min + 1
+}
+
+func (r PortRange) String() string {
+	if r.Min == r.Max {
+		return strconv.Itoa(r.Min)
+	}
+	return fmt.Sprintf("%d-%d", r.Min, r.Max)
+}
+
+// ContainsPort 判断端口是否在任一范围内
+func ContainsPort(ranges []PortRange, port int) bool {
+	for _, r := range ranges {
+		if r.Contains(port) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r PortRange) validate() error {
+	if r.Min < 1 || r.Max > 65535 || r.Min > r.Max {
+		return fmt.Errorf("invalid port range %s: ports must be between 1 and 65535 and start <= end", r)
+	}
+	return nil
+}
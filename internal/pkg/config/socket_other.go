@@ -0,0 +1,11 @@
+//go:build windows || plan9
+
+package config
+
+import "os"
+
+// checkUnixSocket 当前平台无法检查 socket 类型和权限，只检查文件存在
+func checkUnixSocket(path string) error {
+	_, err := os.Stat(path)
+	return err
+}
@@ -0,0 +1,30 @@
+//go:build !windows && !plan9
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// access(2) 的读写权限位
+const (
+	accessRead  = 0x4
+	accessWrite = 0x2
+)
+
+// checkUnixSocket 检查 path 是 unix socket 且当前用户可以读写（连接 socket 需要写权限）
+func checkUnixSocket(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s is not a unix socket", path)
+	}
+	if err := syscall.Access(path, accessRead|accessWrite); err != nil {
+		return fmt.Errorf("no read/write permission on %s (mode %s): %w", path, info.Mode().Perm(), err)
+	}
+	return nil
+}
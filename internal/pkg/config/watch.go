@@ -0,0 +1,91 @@
+package config
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+/*
+配置文件变更监听
+通过轮询文件的修改时间和大小判断是否变化，不依赖平台相关的文件通知机制
+*/
+
+// DefaultWatchInterval 默认轮询间隔
+const DefaultWatchInterval = 2 * time.Second
+
+// Watcher 配置文件监听器
+type Watcher struct {
+	path     string
+	interval time.Duration
+	onChange func()
+
+	modTime time.Time
+	size    int64
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// NewWatcher 创建配置文件监听器，文件变化时调用 onChange
+func NewWatcher(path string, interval time.Duration, onChange func()) *Watcher {
+	if interval <= 0 {
+		interval = DefaultWatchInterval
+	}
+	return &Watcher{
+		path:     path,
+		interval: interval,
+		onChange: onChange,
+		stopCh:   make(chan struct{}),
+	}
+}
+
+// Start 记录文件当前状态并开始监听
+func (w *Watcher) Start() error {
+	info, err := os.Stat(w.path)
+	if err != nil {
+		return err
+	}
+	w.modTime = info.ModTime()
+	w.size = info.Size()
+
+	w.wg.Add(1)
+	go w.loop()
+	return nil
+}
+
+// Stop 停止监听
+func (w *Watcher) Stop() {
+	w.stopOnce.Do(func() {
+		close(w.stopCh)
+	})
+	w.wg.Wait()
+}
+
+// loop 轮询文件状态
+func (w *Watcher) loop() {
+	defer w.wg.Done()
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stopCh:
+			return
+		case <-ticker.C:
+			info, err := os.Stat(w.path)
+			if err != nil {
+				// 编辑器保存时文件可能短暂不存在，等待下一轮
+				continue
+			}
+			if info.ModTime().Equal(w.modTime) && info.Size() == w.size {
+				continue
+			}
+			w.modTime = info.ModTime()
+			w.size = info.Size()
+			w.onChange()
+		}
+	}
+}
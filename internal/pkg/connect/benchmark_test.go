@@ -0,0 +1,162 @@
+package connect
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/chiredeqiudong-dev/go-tunnel-lite/internal/pkg/proto"
+)
+
+// loopConn 循环读出同一段帧数据、丢弃写入数据的连接，用于排除网络开销
+type loopConn struct {
+	data   []byte
+	offset int
+	reads  int // Read 调用次数，对应真实连接上的系统调用
+	writes int // Write 调用次数
+}
+
+func (c *loopConn) Read(p []byte) (int, error) {
+	c.reads++
+	n := copy(p, c.data[c.offset:])
+	c.offset = (c.offset + n) % len(c.data)
+	return n, nil
+}
+
+func (c *loopConn) Write(p []byte) (int, error) {
+	c.writes++
+	return len(p), nil
+}
+
+func (c *loopConn) Close() error                       { return nil }
+func (c *loopConn) LocalAddr() net.Addr                { return nil }
+func (c *loopConn) RemoteAddr() net.Addr               { return nil }
+func (c *loopConn) SetDeadline(t time.Time) error      { return nil }
+func (c *loopConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *loopConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// controlFrames 控制连接上最常见的两种消息：心跳和 NewProxy
+func controlFrames(b *testing.B) map[string]*proto.Message {
+	data, err := proto.Encode(&proto.NewProxyRequest{TunnelName: "web", ProxyID: "0123456789abcdef0123456789abcdef"})
+	if err != nil {
+		b.Fatal(err)
+	}
+	return map[string]*proto.Message{
+		"Ping":     {Type: proto.TypePing},
+		"NewProxy": {Type: proto.TypeNewProxy, Data: data},
+	}
+}
+
+// newLoopConn 生成重复 64 次同一帧的连接，读取时跨越帧边界
+func newLoopConn(b *testing.B, msg *proto.Message) *loopConn {
+	var buf bytes.Buffer
+	for i := 0; i < 64; i++ {
+		if _, err := msg.WriteTo(&buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return &loopConn{data: buf.Bytes()}
+}
+
+func BenchmarkReadFrame(b *testing.B) {
+	for name, msg := range controlFrames(b) {
+		b.Run(name+"/Message.ReadFrom", func(b *testing.B) {
+			conn := newLoopConn(b, msg)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				var m proto.Message
+				if _, err := m.ReadFrom(conn); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(conn.reads)/float64(b.N), "reads/op")
+		})
+
+		b.Run(name+"/Connect.ReadMessage", func(b *testing.B) {
+			raw := newLoopConn(b, msg)
+			conn := WrapConnect(raw)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := conn.ReadMessage(); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(raw.reads)/float64(b.N), "reads/op")
+		})
+
+		b.Run(name+"/Connect.ReadFrame", func(b *testing.B) {
+			raw := newLoopConn(b, msg)
+			conn := WrapConnect(raw)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				m, err := conn.ReadFrame()
+				if err != nil {
+					b.Fatal(err)
+				}
+				Release(m)
+			}
+			b.ReportMetric(float64(raw.reads)/float64(b.N), "reads/op")
+		})
+	}
+}
+
+func BenchmarkWriteFrame(b *testing.B) {
+	req := &proto.NewProxyRequest{TunnelName: "web", ProxyID: "0123456789abcdef0123456789abcdef"}
+
+	b.Run("NewProxy/Message.WriteTo", func(b *testing.B) {
+		conn := &loopConn{}
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			data, err := proto.Encode(req)
+			if err != nil {
+				b.Fatal(err)
+			}
+			msg := &proto.Message{Type: proto.TypeNewProxy, Data: data}
+			if _, err := msg.WriteTo(conn); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.ReportMetric(float64(conn.writes)/float64(b.N), "writes/op")
+	})
+
+	b.Run("NewProxy/Connect.WritePayload", func(b *testing.B) {
+		raw := &loopConn{}
+		conn := WrapConnect(raw)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if err := conn.WritePayload(proto.TypeNewProxy, req); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.ReportMetric(float64(raw.writes)/float64(b.N), "writes/op")
+	})
+
+	b.Run("Ping/Connect.WriteMessage", func(b *testing.B) {
+		raw := &loopConn{}
+		conn := WrapConnect(raw)
+		ping := &proto.Message{Type: proto.TypePing}
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if err := conn.WriteMessage(ping); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.ReportMetric(float64(raw.writes)/float64(b.N), "writes/op")
+	})
+
+	// 并发写入时等待中的写入者负责刷新，多条消息合并为一次写入
+	b.Run("NewProxy/Connect.WritePayload/parallel", func(b *testing.B) {
+		raw := &loopConn{}
+		conn := WrapConnect(raw)
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				if err := conn.WritePayload(proto.TypeNewProxy, req); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.ReportMetric(float64(raw.writes)/float64(b.N), "writes/op")
+	})
+}
@@ -1,8 +1,11 @@
 package connect
 
 import (
+	"bufio"
+	"io"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/chiredeqiudong-dev/go-tunnel-lite/internal/pkg/proto"
@@ -15,14 +18,19 @@ TCP 连接封装
 
 // 提供消息级别的读写，支持并发安全
 type Connect struct {
-	conn net.Conn
-	// reader *bufio.Reader
+	conn   net.Conn
+	reader *bufio.Reader
+	writer *bufio.Writer
 
 	writeMu sync.Mutex
 	readMu  sync.Mutex
+	writers atomic.Int32 // 正在写入或等待写锁的写入者数量
 
 	closed   bool
 	closedMu sync.Mutex
+
+	encoding   atomic.Uint32 // 消息体编码，认证协商后设置
+	frameFlags atomic.Uint32 // 默认帧标志，认证协商后设置
 }
 
 // 将原生 net.Conn 封装为 Connect
@@ -31,8 +39,9 @@ func WrapConnect(c net.Conn) *Connect {
 	SetTCPSocketOptions(c)
 
 	return &Connect{
-		conn: c,
-		// reader: bufio.NewReader(c),
+		conn:   c,
+		reader: bufio.NewReaderSize(c, readBufferSize),
+		writer: bufio.NewWriterSize(c, writeBufferSize),
 	}
 }
 
@@ -43,7 +52,7 @@ func (c *Connect) ReadMessage() (*proto.Message, error) {
 
 	// 解码
 	msg := &proto.Message{}
-	_, err := msg.ReadFrom(c.conn)
+	_, err := msg.ReadFrom(c.reader)
 	if err != nil {
 		return nil, err
 	}
@@ -51,16 +60,70 @@ func (c *Connect) ReadMessage() (*proto.Message, error) {
 	return msg, nil
 }
 
+// 读取一条消息，限制消息体长度和允许的消息类型，用于认证前的连接
+// 读缓冲区为空时直接从连接读取，不预读后续数据，数据连接随后可以交给零拷贝转发
+func (c *Connect) ReadMessageLimit(maxDataLen uint32, types ...uint8) (*proto.Message, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	var r io.Reader = c.reader
+	if c.reader.Buffered() == 0 {
+		r = c.conn
+	}
+
+	msg := &proto.Message{}
+	if _, err := msg.ReadFromLimit(r, maxDataLen, types...); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
 // 写入一条消息
 func (c *Connect) WriteMessage(msg *proto.Message) error {
-	c.writeMu.Lock()
-	defer c.writeMu.Unlock()
+	// 未指定帧标志的消息使用连接的默认标志
+	if flags := uint8(c.frameFlags.Load()); flags != 0 && msg.Flags == 0 {
+		framed := *msg
+		framed.Flags = flags
+		msg = &framed
+	}
+
+	return c.writeFrame(msg)
+}
 
-	// 编码
-	_, err := msg.WriteTo(c.conn)
+// 按连接的编码序列化 payload 并写入一条消息，编码缓冲区在写入后复用
+func (c *Connect) WritePayload(msgType uint8, payload any) error {
+	if payload == nil {
+		return c.WriteMessage(&proto.Message{Type: msgType})
+	}
+
+	bp := payloadPool.Get().(*[]byte)
+	data, err := proto.AppendMarshal(c.Encoding(), (*bp)[:0], payload)
+	if err == nil {
+		// 写入返回时消息体已复制到写缓冲区或写入连接，可以归还
+		err = c.WriteMessage(&proto.Message{Type: msgType, Data: data})
+	}
+	if cap(data) <= maxPooledPayload {
+		*bp = data[:0]
+		payloadPool.Put(bp)
+	}
 	return err
 }
 
+// 设置消息体编码
+func (c *Connect) SetEncoding(enc proto.Encoding) {
+	c.encoding.Store(uint32(enc))
+}
+
+// 获取消息体编码，默认为二进制
+func (c *Connect) Encoding() proto.Encoding {
+	return proto.Encoding(c.encoding.Load())
+}
+
+// 设置默认帧标志，对端支持 v2 帧后才能设置
+func (c *Connect) SetFrameFlags(flags uint8) {
+	c.frameFlags.Store(uint32(flags))
+}
+
 // 设置读取超时
 func (c *Connect) SetReadDeadLine(t time.Time) error {
 	return c.conn.SetDeadline(t)
@@ -108,6 +171,13 @@ func (c *Connect) LocalAddr() net.Addr {
 }
 
 // 获取底层连接（用于特殊场景，如数据转发）
+// 读缓冲区中还有未读取的数据时，返回的连接会先读出这些数据
 func (c *Connect) RawConn() net.Conn {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	if c.reader.Buffered() > 0 {
+		return &bufferedConn{Conn: c.conn, reader: c.reader}
+	}
 	return c.conn
 }
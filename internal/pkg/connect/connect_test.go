@@ -1,6 +1,9 @@
 package connect
 
 import (
+	"bytes"
+	"errors"
+	"io"
 	"net"
 	"sync"
 	"testing"
@@ -227,3 +230,118 @@ func TestEmptyMessage(t *testing.T) {
 		t.Errorf("空消息的 Data 应该为空，实际长度: %d", len(receivedMsg.Data))
 	}
 }
+
+// 测试连接默认帧标志
+func TestFrameFlags(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	serverConn := WrapConnect(server)
+	clientConn := WrapConnect(client)
+	clientConn.SetFrameFlags(proto.FlagChecksum)
+
+	testMsg := &proto.Message{Type: proto.TypePing, Data: []byte("ping")}
+	done := make(chan error, 1)
+	go func() {
+		done <- clientConn.WriteMessage(testMsg)
+	}()
+
+	receivedMsg, err := serverConn.ReadMessage()
+	if err != nil {
+		t.Fatalf("读取消息失败: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("写入消息失败: %v", err)
+	}
+
+	if receivedMsg.Flags != proto.FlagChecksum {
+		t.Errorf("帧标志不匹配: 期望 %d, 实际 %d", proto.FlagChecksum, receivedMsg.Flags)
+	}
+	if testMsg.Flags != 0 {
+		t.Error("WriteMessage 不应修改调用方的消息")
+	}
+}
+
+// 测试 ReadFrame 复用消息体，Release 后解码得到的结构体不受影响
+func TestReadFrameRelease(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	serverConn := WrapConnect(server)
+	clientConn := WrapConnect(client)
+
+	go func() {
+		clientConn.WritePayload(proto.TypeNewProxy, &proto.NewProxyRequest{TunnelName: "web", ProxyID: "first"})
+		clientConn.WritePayload(proto.TypeNewProxy, &proto.NewProxyRequest{TunnelName: "web", ProxyID: "second"})
+		clientConn.WriteMessage(&proto.Message{Type: proto.TypePing})
+	}()
+
+	var reqs []*proto.NewProxyRequest
+	for i := 0; i < 2; i++ {
+		msg, err := serverConn.ReadFrame()
+		if err != nil {
+			t.Fatalf("读取消息失败: %v", err)
+		}
+		req, err := proto.Decode[proto.NewProxyRequest](msg.Data)
+		if err != nil {
+			t.Fatalf("解码失败: %v", err)
+		}
+		reqs = append(reqs, req)
+		Release(msg)
+	}
+	if reqs[0].ProxyID != "first" || reqs[1].ProxyID != "second" {
+		t.Errorf("归还消息后解码结果被覆盖: %+v %+v", reqs[0], reqs[1])
+	}
+
+	// 复用的消息不能残留上一条消息的内容
+	msg, err := serverConn.ReadFrame()
+	if err != nil {
+		t.Fatalf("读取消息失败: %v", err)
+	}
+	defer Release(msg)
+	if msg.Type != proto.TypePing || len(msg.Data) != 0 {
+		t.Errorf("空消息应没有消息体: type=%d len=%d", msg.Type, len(msg.Data))
+	}
+}
+
+// 测试读缓冲区中多读的数据在交给数据转发后不会丢失
+func TestRawConnBuffered(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	serverConn := WrapConnect(server)
+
+	// 消息和紧随其后的转发数据一次写入，会被一起读入缓冲区
+	var frame bytes.Buffer
+	(&proto.Message{Type: proto.TypeProxyReady, Data: []byte("id")}).WriteTo(&frame)
+	frame.WriteString("payload")
+	go client.Write(frame.Bytes())
+
+	if _, err := serverConn.ReadMessage(); err != nil {
+		t.Fatalf("读取消息失败: %v", err)
+	}
+
+	raw := serverConn.RawConn()
+	buf := make([]byte, len("payload"))
+	if _, err := io.ReadFull(raw, buf); err != nil {
+		t.Fatalf("读取转发数据失败: %v", err)
+	}
+	if string(buf) != "payload" {
+		t.Errorf("转发数据不匹配: %q", buf)
+	}
+
+	// 底层连接不支持半关闭时返回 ErrUnsupported，连接仍可双向使用
+	err := raw.(interface{ CloseWrite() error }).CloseWrite()
+	if !errors.Is(err, errors.ErrUnsupported) {
+		t.Fatalf("CloseWrite 应返回 ErrUnsupported: %v", err)
+	}
+	go raw.Write([]byte("reply"))
+	reply := make([]byte, len("reply"))
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := io.ReadFull(client, reply); err != nil || string(reply) != "reply" {
+		t.Errorf("CloseWrite 后连接不应被关闭: %q, %v", reply, err)
+	}
+}
@@ -0,0 +1,103 @@
+package connect
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/chiredeqiudong-dev/go-tunnel-lite/internal/pkg/proto"
+)
+
+/*
+帧读写缓冲
+1. 读写均经过 bufio，消息头和消息体合并为一次系统调用，并发写入时多条消息合并发送
+2. ReadFrame 返回的消息及其消息体来自内存池，处理完成后必须调用 Release 归还
+3. Release 之后不能再访问消息或其 Data，解码得到的结构体不引用 Data，可以继续使用
+*/
+
+const (
+	// readBufferSize 读缓冲区大小，心跳等小消息一次系统调用可读取多条
+	readBufferSize = 4 * 1024
+	// writeBufferSize 写缓冲区大小，超过时 bufio 直接写入底层连接
+	writeBufferSize = 4 * 1024
+	// maxPooledPayload 超过此容量的编码缓冲区不放回内存池，避免长期占用大块内存
+	maxPooledPayload = 16 * 1024
+)
+
+// messagePool 复用 ReadFrame 返回的消息，Data 的底层数组随消息一起复用
+var messagePool = sync.Pool{
+	New: func() interface{} {
+		return new(proto.Message)
+	},
+}
+
+// payloadPool 复用 WritePayload 的编码缓冲区
+var payloadPool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, 0, 512)
+		return &b
+	},
+}
+
+// Release 将 ReadFrame 返回的消息归还内存池
+// 只能对 ReadFrame 返回的消息调用一次，之后不能再访问该消息
+func Release(msg *proto.Message) {
+	if msg == nil {
+		return
+	}
+	msg.Type, msg.Flags, msg.StreamID = 0, 0, 0
+	msg.Data = msg.Data[:0]
+	messagePool.Put(msg)
+}
+
+// ReadFrame 读取一条消息，消息和消息体使用内存池，处理完成后必须调用 Release
+func (c *Connect) ReadFrame() (*proto.Message, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	msg := messagePool.Get().(*proto.Message)
+	if _, err := msg.ReadFromBuffer(c.reader, proto.MaxDataLen); err != nil {
+		Release(msg)
+		return nil, err
+	}
+	return msg, nil
+}
+
+// writeFrame 将消息写入写缓冲区，没有其他等待中的写入者时刷新到连接
+// 等待中的写入者会在获得锁后继续写入并负责刷新，从而将多条消息合并为一次系统调用
+func (c *Connect) writeFrame(msg *proto.Message) error {
+	c.writers.Add(1)
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	_, err := msg.WriteTo(c.writer)
+	if c.writers.Add(-1) == 0 || err != nil {
+		if flushErr := c.writer.Flush(); err == nil {
+			err = flushErr
+		}
+	}
+	return err
+}
+
+// bufferedConn 读取时先返回读缓冲区中剩余的数据，用于把连接交给数据转发
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	if c.reader.Buffered() > 0 {
+		return c.reader.Read(p)
+	}
+	return c.Conn.Read(p)
+}
+
+// CloseWrite 半关闭底层连接，供转发时传递 EOF
+// 底层连接不支持半关闭时返回 errors.ErrUnsupported，由调用方决定是否关闭整个连接
+func (c *bufferedConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return errors.ErrUnsupported
+}
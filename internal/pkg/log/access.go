@@ -0,0 +1,68 @@
+package log
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"time"
+)
+
+/*
+访问日志
+每条公网连接关闭时记录一条，与运行日志使用独立的输出，便于安全审计
+*/
+
+// AccessRecord 一次代理连接的访问记录
+type AccessRecord struct {
+	Tunnel      string
+	ClientID    string
+	RemoteAddr  string // 公网用户地址
+	Start       time.Time
+	Duration    time.Duration
+	BytesIn     int64  // 用户 -> 内网服务
+	BytesOut    int64  // 内网服务 -> 用户
+	CloseReason string // eof、reset、timeout、server_shutdown、unhealthy、local_failed 等
+	ForwardMode string // 转发路径：splice 或 copy
+}
+
+// AccessLogger 访问日志记录器，nil 表示未启用
+type AccessLogger struct {
+	logger *slog.Logger
+	closer io.Closer
+}
+
+// NewAccessLogger 按配置创建访问日志记录器，Level 配置被忽略
+func NewAccessLogger(opts Options) (*AccessLogger, error) {
+	opts.Level = LevelInfo
+	h, c, err := newHandler(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &AccessLogger{logger: slog.New(h), closer: c}, nil
+}
+
+// Log 写入一条访问记录
+func (a *AccessLogger) Log(r AccessRecord) {
+	if a == nil {
+		return
+	}
+	a.logger.LogAttrs(context.Background(), LevelInfo, "access",
+		slog.String("tunnel", r.Tunnel),
+		slog.String("client_id", r.ClientID),
+		slog.String("remote_addr", r.RemoteAddr),
+		slog.Time("start", r.Start),
+		slog.Int64("duration_ms", r.Duration.Milliseconds()),
+		slog.Int64("bytes_in", r.BytesIn),
+		slog.Int64("bytes_out", r.BytesOut),
+		slog.String("close_reason", r.CloseReason),
+		slog.String("forward_mode", r.ForwardMode),
+	)
+}
+
+// Close 关闭访问日志输出
+func (a *AccessLogger) Close() error {
+	if a == nil || a.closer == nil {
+		return nil
+	}
+	return a.closer.Close()
+}
@@ -0,0 +1,49 @@
+package log
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+/*
+日志上下文
+会话、代理连接等把 clientID、隧道名、proxyID 放入 context，
+通过 *Context 系列函数输出的日志自动附加这些字段，便于跨两端关联同一条用户连接
+*/
+
+type attrsKey struct{}
+
+// WithAttrs 返回携带日志属性的 context，args 与 Info 等函数的键值对格式相同
+func WithAttrs(ctx context.Context, args ...any) context.Context {
+	prev := attrsFromContext(ctx)
+
+	r := slog.NewRecord(time.Time{}, 0, "", 0)
+	r.Add(args...)
+
+	attrs := make([]slog.Attr, 0, len(prev)+r.NumAttrs())
+	attrs = append(attrs, prev...)
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, a)
+		return true
+	})
+	return context.WithValue(ctx, attrsKey{}, attrs)
+}
+
+// attrsFromContext 取出 context 中的日志属性
+func attrsFromContext(ctx context.Context) []slog.Attr {
+	if ctx == nil {
+		return nil
+	}
+	attrs, _ := ctx.Value(attrsKey{}).([]slog.Attr)
+	return attrs
+}
+
+// withContextAttrs 将 context 中的属性附加到日志记录
+func withContextAttrs(ctx context.Context, r slog.Record) slog.Record {
+	if attrs := attrsFromContext(ctx); len(attrs) > 0 {
+		r = r.Clone()
+		r.AddAttrs(attrs...)
+	}
+	return r
+}
@@ -2,8 +2,13 @@ package log
 
 import (
 	"context"
+	"fmt"
+	"io"
 	"log/slog"
 	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
 )
 
 /*
@@ -21,34 +26,157 @@ const (
 	LevelError = slog.LevelError
 )
 
+// 日志格式
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// 日志输出目标
+const (
+	OutputStdout   = "stdout"
+	OutputStderr   = "stderr"
+	OutputFile     = "file"
+	OutputSyslog   = "syslog"
+	OutputJournald = "journald"
+)
+
+// Options 日志配置选项
+type Options struct {
+	Level  slog.Level
+	Format string // text 或 json
+	Output string // stdout、stderr、file、syslog、journald
+
+	File   string        // Output 为 file 时的日志文件路径
+	Rotate RotateOptions // 日志文件轮转策略
+
+	SyslogNetwork string // 为空则连接本机 syslog
+	SyslogAddr    string
+	SyslogTag     string
+}
+
 // logger 全局日志实例
 var logger *slog.Logger
 
+// root 全局日志实例的底层 handler，支持运行时替换
+var root = &swapHandler{}
+
 // 全局初始化
 func init() {
 	// TextHandler 输出文本的格式
 	// JSONHandler 输出 JSON 格式（生产环境）
-	handler := slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+	root.store(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
 		Level: LevelDebug, // 默认显示所有级别
 		// AddSource: true,       // 添加源代码位置
-	})
-	logger = slog.New(handler)
+	}))
+	logger = slog.New(root)
+}
+
+// Setup 按配置重建日志输出，可在运行时重复调用（配置热加载）
+func Setup(opts Options) error {
+	h, c, err := newHandler(opts)
+	if err != nil {
+		return err
+	}
+
+	root.swap(h, c)
+	return nil
+}
+
+// newHandler 按配置创建 handler，返回的 io.Closer 可能为 nil
+func newHandler(opts Options) (slog.Handler, io.Closer, error) {
+	handlerOpts := &slog.HandlerOptions{Level: opts.Level}
+
+	var (
+		w       io.Writer
+		c       io.Closer
+		leveled levelWriter
+	)
+	switch opts.Output {
+	case "", OutputStdout:
+		w = os.Stdout
+	case OutputStderr:
+		w = os.Stderr
+	case OutputFile:
+		rw, err := NewRotateWriter(opts.File, opts.Rotate)
+		if err != nil {
+			return nil, nil, err
+		}
+		w, c = rw, rw
+	case OutputSyslog:
+		sw, err := newSyslogWriter(opts.SyslogNetwork, opts.SyslogAddr, opts.SyslogTag)
+		if err != nil {
+			return nil, nil, err
+		}
+		leveled, c = sw, sw
+	case OutputJournald:
+		leveled = &journaldWriter{w: os.Stdout}
+	default:
+		return nil, nil, fmt.Errorf("log: unknown output %q", opts.Output)
+	}
+
+	// syslog 和 journald 自带时间戳和级别，不再重复输出时间
+	var out *levelOutput
+	if leveled != nil {
+		out = &levelOutput{w: leveled}
+		w = out
+		handlerOpts.ReplaceAttr = dropTime
+	}
+
+	var h slog.Handler
+	switch opts.Format {
+	case "", FormatText:
+		h = slog.NewTextHandler(w, handlerOpts)
+	case FormatJSON:
+		h = slog.NewJSONHandler(w, handlerOpts)
+	default:
+		if c != nil {
+			c.Close()
+		}
+		return nil, nil, fmt.Errorf("log: unknown format %q", opts.Format)
+	}
+	if out != nil {
+		h = &levelHandler{inner: h, out: out}
+	}
+	return h, c, nil
+}
+
+// ParseLevel 解析日志级别字符串（debug/info/warn/error）
+func ParseLevel(s string) (slog.Level, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return LevelDebug, nil
+	case "", "info":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	default:
+		return LevelInfo, fmt.Errorf("log: unknown level %q", s)
+	}
 }
 
 // SetLevel 设置日志级别
 func SetLevel(level slog.Level) {
-	handler := slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+	root.store(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
 		Level: level,
-	})
-	logger = slog.New(handler)
+	}))
 }
 
 // SetJSONOutput 切换为 JSON 输出格式
 func SetJSONOutput(level slog.Level) {
-	handler := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+	root.store(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
 		Level: level,
-	})
-	logger = slog.New(handler)
+	}))
+}
+
+// dropTime 去掉顶层的时间字段
+func dropTime(groups []string, a slog.Attr) slog.Attr {
+	if len(groups) == 0 && a.Key == slog.TimeKey {
+		return slog.Attr{}
+	}
+	return a
 }
 
 // GetLogger 获取底层 slog.Logger
@@ -105,3 +233,83 @@ func With(args ...any) *slog.Logger {
 func WithGroup(name string) *slog.Logger {
 	return logger.WithGroup(name)
 }
+
+// swapHandler 可在运行时原子替换的 handler
+// 通过 With/WithGroup 派生的子 Logger 在替换后同样生效
+type swapHandler struct {
+	mu      sync.RWMutex // 输出期间持有读锁，替换时持有写锁，旧输出关闭时已没有进行中的写入
+	current atomic.Pointer[slog.Handler]
+	closer  io.Closer // 当前输出需要释放的资源（日志文件、syslog 连接）
+}
+
+// store 替换为不需要释放资源的 handler（标准输出等）
+func (h *swapHandler) store(inner slog.Handler) {
+	h.swap(inner, nil)
+}
+
+// swap 替换 handler 及其输出资源，等待进行中的写入完成后释放旧资源
+func (h *swapHandler) swap(inner slog.Handler, c io.Closer) {
+	h.mu.Lock()
+	h.current.Store(&inner)
+	old := h.closer
+	h.closer = c
+	h.mu.Unlock()
+
+	if old != nil {
+		old.Close()
+	}
+}
+
+func (h *swapHandler) load() slog.Handler {
+	return *h.current.Load()
+}
+
+func (h *swapHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.load().Enabled(ctx, level)
+}
+
+func (h *swapHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.load().Handle(ctx, withContextAttrs(ctx, r))
+}
+
+func (h *swapHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &derivedHandler{root: h, apply: func(inner slog.Handler) slog.Handler {
+		return inner.WithAttrs(attrs)
+	}}
+}
+
+func (h *swapHandler) WithGroup(name string) slog.Handler {
+	return &derivedHandler{root: h, apply: func(inner slog.Handler) slog.Handler {
+		return inner.WithGroup(name)
+	}}
+}
+
+// derivedHandler 在每次输出时基于 root 当前的 handler 重放 With/WithGroup
+type derivedHandler struct {
+	root  *swapHandler
+	apply func(slog.Handler) slog.Handler
+}
+
+func (h *derivedHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.root.Enabled(ctx, level)
+}
+
+func (h *derivedHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.root.mu.RLock()
+	defer h.root.mu.RUnlock()
+	return h.apply(h.root.load()).Handle(ctx, withContextAttrs(ctx, r))
+}
+
+func (h *derivedHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &derivedHandler{root: h.root, apply: func(inner slog.Handler) slog.Handler {
+		return h.apply(inner).WithAttrs(attrs)
+	}}
+}
+
+func (h *derivedHandler) WithGroup(name string) slog.Handler {
+	return &derivedHandler{root: h.root, apply: func(inner slog.Handler) slog.Handler {
+		return h.apply(inner).WithGroup(name)
+	}}
+}
@@ -2,9 +2,14 @@ package log
 
 import (
 	"bytes"
+	"context"
 	"log/slog"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 // TestBasicLogging 测试基本日志功能
@@ -181,3 +186,300 @@ func BenchmarkSlogJSON(b *testing.B) {
 		testLogger.Info("benchmark message", "iteration", i)
 	}
 }
+
+// TestParseLevel 测试日志级别解析
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    slog.Level
+		wantErr bool
+	}{
+		{"debug", LevelDebug, false},
+		{"INFO", LevelInfo, false},
+		{"", LevelInfo, false},
+		{"warn", LevelWarn, false},
+		{"error", LevelError, false},
+		{"verbose", LevelInfo, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseLevel(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseLevel(%q) error = %v, wantErr = %v", tt.input, err, tt.wantErr)
+		}
+		if got != tt.want {
+			t.Errorf("ParseLevel(%q) = %v, want %v", tt.input, got, tt.want)
+		}
+	}
+}
+
+// TestSetupFileJSON 测试按配置输出 JSON 到文件，并在重新加载后切换输出
+func TestSetupFileJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+
+	if err := Setup(Options{Level: LevelWarn, Format: FormatJSON, Output: OutputFile, File: path}); err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	defer SetLevel(LevelDebug)
+
+	// 重新加载前创建的子 Logger 也应使用新配置
+	child := With("client_id", "abc123")
+
+	Info("filtered message")
+	child.Warn("kept message")
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read log file failed: %v", err)
+	}
+	output := string(data)
+	if strings.Contains(output, "filtered message") {
+		t.Error("INFO should be filtered when level is WARN")
+	}
+	if !strings.Contains(output, `"msg":"kept message"`) || !strings.Contains(output, `"client_id":"abc123"`) {
+		t.Errorf("unexpected JSON output:\n%s", output)
+	}
+
+	// 切换回标准输出后不应再写入文件
+	if err := Setup(Options{Level: LevelDebug, Output: OutputStdout}); err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	child.Warn("after reload")
+
+	data, _ = os.ReadFile(path)
+	if strings.Contains(string(data), "after reload") {
+		t.Error("log file should not be written after output switched")
+	}
+}
+
+// closeTrackWriter 记录关闭后的写入次数
+type closeTrackWriter struct {
+	mu     sync.Mutex
+	closed bool
+	late   int
+}
+
+func (w *closeTrackWriter) Write(p []byte) (int, error) {
+	// 放大写入窗口，让替换发生在写入过程中
+	time.Sleep(time.Millisecond)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		w.late++
+		return 0, os.ErrClosed
+	}
+	return len(p), nil
+}
+
+func (w *closeTrackWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closed = true
+	return nil
+}
+
+// TestSwapWaitsForWrites 测试替换输出时等待进行中的写入完成后再关闭旧输出
+func TestSwapWaitsForWrites(t *testing.T) {
+	defer SetLevel(LevelDebug)
+
+	var writers []*closeTrackWriter
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			child := With("worker", i)
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				Info("message")
+				child.Info("message")
+			}
+		}()
+	}
+
+	for i := 0; i < 20; i++ {
+		w := &closeTrackWriter{}
+		writers = append(writers, w)
+		root.swap(slog.NewTextHandler(w, nil), w)
+		time.Sleep(2 * time.Millisecond)
+	}
+	close(stop)
+	wg.Wait()
+	SetLevel(LevelDebug)
+
+	for i, w := range writers {
+		if w.late > 0 {
+			t.Errorf("writer %d: %d writes after close", i, w.late)
+		}
+	}
+}
+
+// TestSetupInvalid 测试无效配置
+func TestSetupInvalid(t *testing.T) {
+	if err := Setup(Options{Output: "kafka"}); err == nil {
+		t.Error("expected error for unknown output")
+	}
+	if err := Setup(Options{Format: "xml"}); err == nil {
+		t.Error("expected error for unknown format")
+	}
+}
+
+// TestRotateWriter 测试按大小轮转和旧文件清理
+func TestRotateWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+
+	w, err := NewRotateWriter(path, RotateOptions{MaxSize: 10, MaxBackups: 2})
+	if err != nil {
+		t.Fatalf("NewRotateWriter failed: %v", err)
+	}
+	defer w.Close()
+
+	for i := 0; i < 5; i++ {
+		if _, err := w.Write([]byte("12345678\n")); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		time.Sleep(2 * time.Millisecond) // 保证轮转文件名的时间戳不同
+	}
+
+	backups, _ := filepath.Glob(path + ".*")
+	if len(backups) != 2 {
+		t.Errorf("backups = %d, want 2", len(backups))
+	}
+
+	data, _ := os.ReadFile(path)
+	if string(data) != "12345678\n" {
+		t.Errorf("current file = %q, want a single line", data)
+	}
+}
+
+// TestRotateWriterPruneUnrelated 测试清理旧文件时不删除同前缀的其他文件
+func TestRotateWriterPruneUnrelated(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	for _, name := range []string{path + ".bak", path + ".old.1"} {
+		if err := os.WriteFile(name, []byte("keep"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w, err := NewRotateWriter(path, RotateOptions{MaxSize: 10, MaxBackups: 1})
+	if err != nil {
+		t.Fatalf("NewRotateWriter failed: %v", err)
+	}
+	defer w.Close()
+
+	for i := 0; i < 3; i++ {
+		if _, err := w.Write([]byte("12345678\n")); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	for _, name := range []string{path + ".bak", path + ".old.1"} {
+		if _, err := os.Stat(name); err != nil {
+			t.Errorf("unrelated file %s removed: %v", filepath.Base(name), err)
+		}
+	}
+	backups, _ := w.backups()
+	if len(backups) != 1 {
+		t.Errorf("backups = %d, want 1", len(backups))
+	}
+}
+
+// TestRotateWriterFailure 测试轮转失败后继续写入，恢复后正常轮转，错误只报告一次
+func TestRotateWriterFailure(t *testing.T) {
+	var report bytes.Buffer
+	errOutput = &report
+	defer func() { errOutput = os.Stderr }()
+
+	dir := filepath.Join(t.TempDir(), "logs")
+	path := filepath.Join(dir, "app.log")
+	w, err := NewRotateWriter(path, RotateOptions{MaxSize: 10})
+	if err != nil {
+		t.Fatalf("NewRotateWriter failed: %v", err)
+	}
+	defer w.Close()
+
+	if _, err := w.Write([]byte("12345678\n")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	// 日志目录被替换为普通文件：重命名和重新打开都失败
+	os.RemoveAll(dir)
+	if err := os.WriteFile(dir, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := w.Write([]byte("lost\n")); err == nil {
+			t.Fatal("Write should fail while log file cannot be opened")
+		}
+	}
+	if n := strings.Count(report.String(), "\n"); n != 1 {
+		t.Errorf("reported %d errors, want 1:\n%s", n, report.String())
+	}
+
+	// 恢复后重新打开文件继续写入
+	os.Remove(dir)
+	if _, err := w.Write([]byte("recovered\n")); err != nil {
+		t.Fatalf("Write after recovery failed: %v", err)
+	}
+	data, _ := os.ReadFile(path)
+	if string(data) != "recovered\n" {
+		t.Errorf("current file = %q, want recovered line", data)
+	}
+}
+
+// TestJournaldPrefix 测试 journald 级别前缀
+func TestJournaldPrefix(t *testing.T) {
+	var buf bytes.Buffer
+
+	out := &levelOutput{w: &journaldWriter{w: &buf}}
+	handler := &levelHandler{
+		inner: slog.NewTextHandler(out, &slog.HandlerOptions{Level: LevelDebug, ReplaceAttr: dropTime}),
+		out:   out,
+	}
+	oldLogger := logger
+	logger = slog.New(handler)
+	defer func() { logger = oldLogger }()
+
+	Error("boom")
+	Info("hello")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "<3>") || !strings.HasPrefix(lines[1], "<6>") {
+		t.Errorf("unexpected journald output:\n%s", buf.String())
+	}
+	if strings.Contains(buf.String(), "time=") {
+		t.Error("journald output should not contain time")
+	}
+}
+
+// TestContextAttrs 测试 context 中的属性自动附加到日志
+func TestContextAttrs(t *testing.T) {
+	var buf bytes.Buffer
+	root.store(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: LevelDebug}))
+	defer SetLevel(LevelDebug)
+
+	ctx := WithAttrs(context.Background(), "clientID", "abc123")
+	ctx = WithAttrs(ctx, "tunnelName", "web", "proxyID", "p-1")
+
+	InfoContext(ctx, "connected", "bytes", 10)
+	Info("no context")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got:\n%s", buf.String())
+	}
+	for _, field := range []string{"clientID=abc123", "tunnelName=web", "proxyID=p-1", "bytes=10"} {
+		if !strings.Contains(lines[0], field) {
+			t.Errorf("line should contain %q, got: %s", field, lines[0])
+		}
+	}
+	if strings.Contains(lines[1], "clientID") {
+		t.Errorf("line without context should not contain attrs: %s", lines[1])
+	}
+}
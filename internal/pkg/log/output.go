@@ -0,0 +1,83 @@
+package log
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+)
+
+/*
+按日志级别区分优先级的输出（syslog、journald）
+slog 的内置 handler 只向 io.Writer 写入格式化后的字节，不携带级别，
+这里在 Handle 时记录当前级别，再由 levelWriter 按级别写出
+*/
+
+// levelWriter 按级别写入一条已格式化的日志
+type levelWriter interface {
+	WriteLevel(level slog.Level, p []byte) (int, error)
+}
+
+// levelOutput 作为内置 handler 的 io.Writer，将写入转发给 levelWriter
+type levelOutput struct {
+	mu    sync.Mutex
+	level slog.Level
+	w     levelWriter
+}
+
+func (o *levelOutput) Write(p []byte) (int, error) {
+	return o.w.WriteLevel(o.level, p)
+}
+
+// levelHandler 在输出前把记录的级别告知 levelOutput
+type levelHandler struct {
+	inner slog.Handler
+	out   *levelOutput
+}
+
+func (h *levelHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.inner.Enabled(ctx, level)
+}
+
+func (h *levelHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.out.mu.Lock()
+	defer h.out.mu.Unlock()
+
+	h.out.level = r.Level
+	return h.inner.Handle(ctx, r)
+}
+
+func (h *levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &levelHandler{inner: h.inner.WithAttrs(attrs), out: h.out}
+}
+
+func (h *levelHandler) WithGroup(name string) slog.Handler {
+	return &levelHandler{inner: h.inner.WithGroup(name), out: h.out}
+}
+
+// syslogPriority 将 slog 级别映射为 syslog 优先级
+func syslogPriority(level slog.Level) int {
+	switch {
+	case level >= LevelError:
+		return 3 // err
+	case level >= LevelWarn:
+		return 4 // warning
+	case level >= LevelInfo:
+		return 6 // info
+	default:
+		return 7 // debug
+	}
+}
+
+// journaldWriter 输出 systemd 可识别的 "<N>" 级别前缀，由 journald 采集标准输出
+type journaldWriter struct {
+	w io.Writer
+}
+
+func (j *journaldWriter) WriteLevel(level slog.Level, p []byte) (int, error) {
+	if _, err := fmt.Fprintf(j.w, "<%d>", syslogPriority(level)); err != nil {
+		return 0, err
+	}
+	return j.w.Write(p)
+}
@@ -0,0 +1,222 @@
+package log
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+日志文件轮转
+1. 文件大小超过 MaxSize 或打开时间超过 Interval 时轮转
+2. 旧文件重命名为 <path>.<时间戳>
+3. 保留最近 MaxBackups 个旧文件，并删除超过 MaxAge 的旧文件
+4. 轮转失败时继续写入当前文件，稍后重试，错误只报告一次
+*/
+
+// backupTimeFormat 轮转文件的时间戳后缀，按字典序即按时间排序
+const backupTimeFormat = "20060102-150405.000"
+
+// rotateRetryInterval 轮转失败后再次尝试的间隔
+const rotateRetryInterval = time.Minute
+
+// errOutput 日志文件无法轮转或写入时的报告输出
+var errOutput io.Writer = os.Stderr
+
+// RotateOptions 日志轮转策略，零值表示不启用对应规则
+type RotateOptions struct {
+	MaxSize    int64         // 单个文件最大字节数
+	Interval   time.Duration // 按时间轮转的间隔
+	MaxBackups int           // 最多保留的旧文件数
+	MaxAge     time.Duration // 旧文件最长保留时间
+}
+
+// RotateWriter 支持轮转的日志文件写入器
+type RotateWriter struct {
+	path string
+	opts RotateOptions
+
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	openedAt time.Time
+	failedAt time.Time // 上次轮转失败的时间，用于推迟重试
+	failing  bool      // 已报告失败，恢复前不再重复报告
+	closed   bool
+}
+
+// NewRotateWriter 打开（或创建）日志文件
+func NewRotateWriter(path string, opts RotateOptions) (*RotateWriter, error) {
+	w := &RotateWriter{path: path, opts: opts}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Write 写入日志，必要时先轮转
+func (w *RotateWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return 0, os.ErrClosed
+	}
+	if w.shouldRotate(len(p)) {
+		if err := w.rotate(); err != nil {
+			w.failedAt = time.Now()
+			w.report(fmt.Errorf("rotate %s: %w", w.path, err))
+		} else {
+			w.failing = false
+		}
+	}
+	if w.file == nil {
+		// 轮转后重新打开失败，每次写入时重试
+		if err := w.open(); err != nil {
+			w.report(err)
+			return 0, err
+		}
+		w.failing = false
+	}
+
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Close 关闭日志文件
+func (w *RotateWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.closed = true
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+// shouldRotate 判断写入前是否需要轮转
+func (w *RotateWriter) shouldRotate(n int) bool {
+	if w.size == 0 {
+		return false // 空文件无需轮转，避免单条超大日志反复轮转
+	}
+	if !w.failedAt.IsZero() && time.Since(w.failedAt) < rotateRetryInterval {
+		return false
+	}
+	if w.opts.MaxSize > 0 && w.size+int64(n) > w.opts.MaxSize {
+		return true
+	}
+	if w.opts.Interval > 0 && time.Since(w.openedAt) >= w.opts.Interval {
+		return true
+	}
+	return false
+}
+
+// open 以追加方式打开日志文件
+func (w *RotateWriter) open() error {
+	if dir := filepath.Dir(w.path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+
+	file, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	w.file = file
+	w.size = info.Size()
+	w.openedAt = time.Now()
+	return nil
+}
+
+// rotate 重命名当前文件并打开新文件
+// 重命名失败时重新打开当前文件继续写入；重新打开也失败时 w.file 为 nil，由 Write 重试
+func (w *RotateWriter) rotate() error {
+	closeErr := w.file.Close()
+	w.file = nil
+
+	backup := w.path + "." + time.Now().Format(backupTimeFormat)
+	renameErr := os.Rename(w.path, backup)
+	if err := w.open(); err != nil {
+		return err
+	}
+	if renameErr != nil {
+		return renameErr
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+
+	w.failedAt = time.Time{}
+	w.prune()
+	return nil
+}
+
+// report 报告轮转或打开失败，连续失败只报告第一次
+// 日志输出本身出错，只能写到标准错误
+func (w *RotateWriter) report(err error) {
+	if w.failing {
+		return
+	}
+	w.failing = true
+	fmt.Fprintf(errOutput, "log: %v\n", err)
+}
+
+// prune 按数量和时间清理旧文件
+func (w *RotateWriter) prune() {
+	if w.opts.MaxBackups <= 0 && w.opts.MaxAge <= 0 {
+		return
+	}
+
+	backups, err := w.backups()
+	if err != nil {
+		return
+	}
+	// 新文件在前
+	sort.Sort(sort.Reverse(sort.StringSlice(backups)))
+
+	for i, backup := range backups {
+		if w.opts.MaxBackups > 0 && i >= w.opts.MaxBackups {
+			os.Remove(backup)
+			continue
+		}
+		if w.opts.MaxAge > 0 {
+			info, err := os.Stat(backup)
+			if err == nil && time.Since(info.ModTime()) > w.opts.MaxAge {
+				os.Remove(backup)
+			}
+		}
+	}
+}
+
+// backups 返回当前日志文件的所有轮转文件，只匹配时间戳后缀，不会误删同前缀的其他文件
+func (w *RotateWriter) backups() ([]string, error) {
+	matches, err := filepath.Glob(w.path + ".*")
+	if err != nil {
+		return nil, err
+	}
+
+	backups := matches[:0]
+	for _, name := range matches {
+		suffix := strings.TrimPrefix(name, w.path+".")
+		if _, err := time.Parse(backupTimeFormat, suffix); err == nil {
+			backups = append(backups, name)
+		}
+	}
+	return backups, nil
+}
@@ -0,0 +1,23 @@
+//go:build windows || plan9
+
+package log
+
+import (
+	"errors"
+	"log/slog"
+)
+
+// syslogWriter 当前平台不支持 syslog
+type syslogWriter struct{}
+
+func newSyslogWriter(network, addr, tag string) (*syslogWriter, error) {
+	return nil, errors.New("log: syslog is not supported on this platform")
+}
+
+func (s *syslogWriter) WriteLevel(level slog.Level, p []byte) (int, error) {
+	return 0, errors.New("log: syslog is not supported on this platform")
+}
+
+func (s *syslogWriter) Close() error {
+	return nil
+}
@@ -0,0 +1,50 @@
+//go:build !windows && !plan9
+
+package log
+
+import (
+	"log/slog"
+	"log/syslog"
+	"strings"
+)
+
+// syslogWriter 将日志写入 syslog
+type syslogWriter struct {
+	w *syslog.Writer
+}
+
+// newSyslogWriter 连接 syslog，network 和 addr 为空时使用本机 syslog
+func newSyslogWriter(network, addr, tag string) (*syslogWriter, error) {
+	if tag == "" {
+		tag = "go-tunnel-lite"
+	}
+	w, err := syslog.Dial(network, addr, syslog.LOG_INFO|syslog.LOG_DAEMON, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &syslogWriter{w: w}, nil
+}
+
+func (s *syslogWriter) WriteLevel(level slog.Level, p []byte) (int, error) {
+	msg := strings.TrimSuffix(string(p), "\n")
+
+	var err error
+	switch syslogPriority(level) {
+	case 3:
+		err = s.w.Err(msg)
+	case 4:
+		err = s.w.Warning(msg)
+	case 6:
+		err = s.w.Info(msg)
+	default:
+		err = s.w.Debug(msg)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (s *syslogWriter) Close() error {
+	return s.w.Close()
+}
@@ -0,0 +1,164 @@
+package plugin
+
+import (
+	"bufio"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+
+	"github.com/chiredeqiudong-dev/go-tunnel-lite/internal/pkg/config"
+	"github.com/chiredeqiudong-dev/go-tunnel-lite/internal/pkg/log"
+)
+
+// newStaticFile 提供 local_path 目录下的文件
+func newStaticFile(cfg config.PluginConfig) Plugin {
+	var handler http.Handler = http.FileServer(http.Dir(cfg.LocalPath))
+	if cfg.StripPrefix != "" {
+		handler = http.StripPrefix(cfg.StripPrefix, handler)
+	}
+	if cfg.Username != "" {
+		handler = basicAuth(cfg, handler)
+	}
+	return newHTTPPlugin(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logRequest(config.PluginStaticFile, r)
+		handler.ServeHTTP(w, r)
+	}))
+}
+
+// basicAuth 要求 HTTP Basic 认证
+func basicAuth(cfg config.PluginConfig, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		if !ok || !checkCredentials(cfg, username, password) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="go-tunnel-lite"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// newHTTP2HTTPS 将 HTTP 请求转发到 localAddr 上的 HTTPS 服务
+func newHTTP2HTTPS(cfg config.PluginConfig, localAddr string) Plugin {
+	target := &url.URL{Scheme: "https", Host: localAddr}
+	rp := &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetURL(target)
+			r.SetXForwarded()
+			// 默认保留用户请求的 Host，本地服务按虚拟主机区分时可以改写
+			r.Out.Host = r.In.Host
+			if cfg.HostHeaderRewrite != "" {
+				r.Out.Host = cfg.HostHeaderRewrite
+			}
+		},
+		Transport: &http.Transport{
+			DialContext:     dial,
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: cfg.InsecureSkipVerify},
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			log.Warn("转发到本地 HTTPS 服务失败", "plugin", config.PluginHTTP2HTTPS, "localAddr", localAddr, "error", err)
+			w.WriteHeader(http.StatusBadGateway)
+		},
+	}
+	return newHTTPPlugin(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logRequest(config.PluginHTTP2HTTPS, r)
+		rp.ServeHTTP(w, r)
+	}))
+}
+
+// httpProxy HTTP 正向代理，普通请求需使用绝对 URL，HTTPS 等使用 CONNECT 建立隧道
+type httpProxy struct {
+	cfg config.PluginConfig
+	rp  *httputil.ReverseProxy
+}
+
+func newHTTPProxy(cfg config.PluginConfig) Plugin {
+	p := &httpProxy{
+		cfg: cfg,
+		rp: &httputil.ReverseProxy{
+			// 请求已是绝对 URL，原样转发；Proxy-Authorization 等逐跳头由 ReverseProxy 去掉
+			Rewrite: func(r *httputil.ProxyRequest) {},
+			Transport: &http.Transport{
+				DialContext: dial,
+			},
+			ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+				log.Warn("代理请求失败", "plugin", config.PluginHTTPProxy, "host", r.Host, "error", err)
+				w.WriteHeader(http.StatusBadGateway)
+			},
+		},
+	}
+	return newHTTPPlugin(p)
+}
+
+func (p *httpProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	logRequest(config.PluginHTTPProxy, r)
+
+	if p.cfg.Username != "" {
+		username, password, ok := proxyAuth(r)
+		if !ok || !checkCredentials(p.cfg, username, password) {
+			w.Header().Set("Proxy-Authenticate", `Basic realm="go-tunnel-lite"`)
+			http.Error(w, http.StatusText(http.StatusProxyAuthRequired), http.StatusProxyAuthRequired)
+			return
+		}
+	}
+
+	if r.Method == http.MethodConnect {
+		p.connect(w, r)
+		return
+	}
+	if !r.URL.IsAbs() {
+		http.Error(w, "请求需要使用绝对 URL", http.StatusBadRequest)
+		return
+	}
+	p.rp.ServeHTTP(w, r)
+}
+
+// connect 处理 CONNECT：连接目标地址后接管连接双向转发
+func (p *httpProxy) connect(w http.ResponseWriter, r *http.Request) {
+	target, err := dial(r.Context(), "tcp", r.Host)
+	if err != nil {
+		log.Warn("连接目标地址失败", "plugin", config.PluginHTTPProxy, "host", r.Host, "error", err)
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		target.Close()
+		http.Error(w, "不支持 CONNECT", http.StatusInternalServerError)
+		return
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		target.Close()
+		return
+	}
+	if _, err := conn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
+		conn.Close()
+		target.Close()
+		return
+	}
+	forward(r.Context(), &hijackedConn{Conn: conn, reader: rw.Reader}, target)
+}
+
+// proxyAuth 解析 Proxy-Authorization 头中的 Basic 认证
+func proxyAuth(r *http.Request) (username, password string, ok bool) {
+	// 复用 Request.BasicAuth 的解析逻辑
+	req := http.Request{Header: http.Header{"Authorization": r.Header["Proxy-Authorization"]}}
+	return req.BasicAuth()
+}
+
+// hijackedConn 先读取 http.Server 已缓冲的数据
+type hijackedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *hijackedConn) Read(p []byte) (int, error) {
+	if c.reader.Buffered() > 0 {
+		return c.reader.Read(p)
+	}
+	return c.Conn.Read(p)
+}
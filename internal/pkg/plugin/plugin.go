@@ -0,0 +1,151 @@
+package plugin
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/chiredeqiudong-dev/go-tunnel-lite/internal/pkg/config"
+	"github.com/chiredeqiudong-dev/go-tunnel-lite/internal/pkg/log"
+	"github.com/chiredeqiudong-dev/go-tunnel-lite/internal/pkg/proxy"
+)
+
+/*
+客户端插件
+1. 配置了 plugin 的隧道不连接本地服务，由插件直接处理数据连接上的用户请求
+2. static_file、http_proxy、http2https 基于 http.Server，每条数据连接交给同一个 Server 处理
+3. socks5 逐条处理连接，握手完成后与目标地址双向转发
+4. socks5、http_proxy 可访问客户端所在网络的任意地址，配置校验要求 username/password，除非显式设置 allow_anonymous
+*/
+
+// dialTimeout 插件连接目标地址的超时时间
+const dialTimeout = 10 * time.Second
+
+// Plugin 代替本地服务处理用户连接
+type Plugin interface {
+	// Handle 处理一条用户连接，插件负责关闭 conn
+	Handle(ctx context.Context, conn net.Conn)
+	// Close 停止接受新连接，已在处理的连接不受影响，由 conn 关闭结束
+	Close() error
+}
+
+// New 按配置创建插件，localAddr 为 http2https 转发的目标地址
+func New(cfg config.PluginConfig, localAddr string) (Plugin, error) {
+	if (cfg.Type == config.PluginSOCKS5 || cfg.Type == config.PluginHTTPProxy) && cfg.Username == "" && !cfg.AllowAnonymous {
+		return nil, fmt.Errorf("plugin %s requires username/password or allow_anonymous", cfg.Type)
+	}
+	switch cfg.Type {
+	case config.PluginStaticFile:
+		return newStaticFile(cfg), nil
+	case config.PluginSOCKS5:
+		return newSOCKS5(cfg), nil
+	case config.PluginHTTPProxy:
+		return newHTTPProxy(cfg), nil
+	case config.PluginHTTP2HTTPS:
+		return newHTTP2HTTPS(cfg, localAddr), nil
+	}
+	return nil, fmt.Errorf("unsupported plugin type %q", cfg.Type)
+}
+
+// dial 连接插件的目标地址，双栈主机名同时尝试 IPv4 和 IPv6
+func dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	dialer := net.Dialer{Timeout: dialTimeout, FallbackDelay: 300 * time.Millisecond}
+	return dialer.DialContext(ctx, network, addr)
+}
+
+// forward 在用户连接和目标连接之间双向转发，直到任一方向结束
+func forward(ctx context.Context, conn, target net.Conn) {
+	pc := proxy.NewProxyConnection(ctx, target, conn)
+	defer pc.Close()
+	pc.Forward()
+}
+
+// checkCredentials 以固定时间比较用户名和密码
+func checkCredentials(cfg config.PluginConfig, username, password string) bool {
+	userOK := subtle.ConstantTimeCompare([]byte(username), []byte(cfg.Username)) == 1
+	passOK := subtle.ConstantTimeCompare([]byte(password), []byte(cfg.Password)) == 1
+	return userOK && passOK
+}
+
+// httpPlugin 用一个 http.Server 处理交给插件的所有连接
+type httpPlugin struct {
+	server   *http.Server
+	listener *connListener
+}
+
+func newHTTPPlugin(handler http.Handler) *httpPlugin {
+	p := &httpPlugin{
+		server: &http.Server{
+			Handler:           handler,
+			ReadHeaderTimeout: 30 * time.Second,
+		},
+		listener: newConnListener(),
+	}
+	go p.server.Serve(p.listener)
+	return p
+}
+
+func (p *httpPlugin) Handle(ctx context.Context, conn net.Conn) {
+	p.listener.push(conn)
+}
+
+// Close 关闭监听后 Serve 返回，已建立的连接继续处理直到关闭
+func (p *httpPlugin) Close() error {
+	return p.listener.Close()
+}
+
+// connListener 将插件收到的连接交给 http.Server
+type connListener struct {
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newConnListener() *connListener {
+	return &connListener{
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+}
+
+// push 交付一条连接，监听已关闭时直接关闭连接
+func (l *connListener) push(conn net.Conn) {
+	select {
+	case l.conns <- conn:
+	case <-l.done:
+		conn.Close()
+	}
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *connListener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *connListener) Addr() net.Addr {
+	return pluginAddr{}
+}
+
+// pluginAddr 插件监听没有实际地址
+type pluginAddr struct{}
+
+func (pluginAddr) Network() string { return "plugin" }
+func (pluginAddr) String() string  { return "plugin" }
+
+// logRequest 记录插件处理的 HTTP 请求
+func logRequest(plugin string, r *http.Request) {
+	log.Debug("插件处理请求", "plugin", plugin, "method", r.Method, "host", r.Host, "path", r.URL.Path)
+}
@@ -0,0 +1,277 @@
+package plugin
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/chiredeqiudong-dev/go-tunnel-lite/internal/pkg/config"
+)
+
+// pluginTransport 每个连接通过管道交给插件处理
+func pluginTransport(p Plugin) *http.Transport {
+	return &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, pluginConn := net.Pipe()
+			go p.Handle(context.Background(), pluginConn)
+			return conn, nil
+		},
+	}
+}
+
+// get 发送 GET 请求，返回状态码和响应体
+func get(t *testing.T, client *http.Client, rawURL string, setup func(*http.Request)) (int, string) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, rawURL, nil)
+	if setup != nil {
+		setup(req)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("请求 %s 失败: %v", rawURL, err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+// echoServer 启动回显服务
+func echoServer(t *testing.T) net.Listener {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("监听失败: %v", err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return ln
+}
+
+// TestStaticFile 测试静态文件插件的前缀去除和 Basic 认证
+func TestStaticFile(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "hello.txt"), []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := New(config.PluginConfig{
+		Type:        config.PluginStaticFile,
+		LocalPath:   dir,
+		StripPrefix: "/static",
+		Username:    "admin",
+		Password:    "secret",
+	}, "")
+	if err != nil {
+		t.Fatalf("创建插件失败: %v", err)
+	}
+	defer p.Close()
+	client := &http.Client{Transport: pluginTransport(p)}
+
+	if status, _ := get(t, client, "http://tunnel/static/hello.txt", nil); status != http.StatusUnauthorized {
+		t.Errorf("未认证 status = %d, want 401", status)
+	}
+	status, body := get(t, client, "http://tunnel/static/hello.txt", func(r *http.Request) { r.SetBasicAuth("admin", "secret") })
+	if status != http.StatusOK || body != "hello" {
+		t.Errorf("status = %d, body = %q", status, body)
+	}
+	if status, _ := get(t, client, "http://tunnel/static/missing.txt", func(r *http.Request) { r.SetBasicAuth("admin", "secret") }); status != http.StatusNotFound {
+		t.Errorf("不存在的文件 status = %d, want 404", status)
+	}
+}
+
+// TestHTTP2HTTPS 测试 HTTP 请求转发到 HTTPS 服务并改写 Host
+func TestHTTP2HTTPS(t *testing.T) {
+	backend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Host+" "+r.URL.Path+" "+r.Header.Get("X-Forwarded-Proto"))
+	}))
+	defer backend.Close()
+	addr := backend.Listener.Addr().String()
+
+	tests := []struct {
+		name    string
+		rewrite string
+		want    string
+	}{
+		{name: "keep host", want: "example.com /api http"},
+		{name: "rewrite host", rewrite: "internal.local", want: "internal.local /api http"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, _ := New(config.PluginConfig{
+				Type:               config.PluginHTTP2HTTPS,
+				HostHeaderRewrite:  tt.rewrite,
+				InsecureSkipVerify: true,
+			}, addr)
+			defer p.Close()
+			client := &http.Client{Transport: pluginTransport(p)}
+
+			status, body := get(t, client, "http://example.com/api", nil)
+			if status != http.StatusOK || body != tt.want {
+				t.Errorf("status = %d, body = %q, want %q", status, body, tt.want)
+			}
+		})
+	}
+
+	// 默认校验证书，自签名证书被拒绝
+	p, _ := New(config.PluginConfig{Type: config.PluginHTTP2HTTPS}, addr)
+	defer p.Close()
+	if status, _ := get(t, &http.Client{Transport: pluginTransport(p)}, "http://example.com/", nil); status != http.StatusBadGateway {
+		t.Errorf("证书校验失败 status = %d, want 502", status)
+	}
+}
+
+// TestHTTPProxy 测试 HTTP 正向代理的普通请求、CONNECT 和代理认证
+func TestHTTPProxy(t *testing.T) {
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "plain "+r.Header.Get("Proxy-Authorization"))
+	}))
+	defer plain.Close()
+	secure := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "secure")
+	}))
+	defer secure.Close()
+
+	p, _ := New(config.PluginConfig{Type: config.PluginHTTPProxy, Username: "user", Password: "pass"}, "")
+	defer p.Close()
+
+	client := func(proxyUser *url.Userinfo) *http.Client {
+		transport := pluginTransport(p)
+		transport.Proxy = http.ProxyURL(&url.URL{Scheme: "http", Host: "plugin", User: proxyUser})
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		return &http.Client{Transport: transport}
+	}
+
+	if status, _ := get(t, client(nil), plain.URL, nil); status != http.StatusProxyAuthRequired {
+		t.Errorf("未认证 status = %d, want 407", status)
+	}
+	if status, _ := get(t, client(url.UserPassword("user", "wrong")), plain.URL, nil); status != http.StatusProxyAuthRequired {
+		t.Errorf("密码错误 status = %d, want 407", status)
+	}
+
+	authed := client(url.UserPassword("user", "pass"))
+	// 代理认证头不应转发给目标服务
+	if status, body := get(t, authed, plain.URL, nil); status != http.StatusOK || body != "plain " {
+		t.Errorf("普通请求 status = %d, body = %q", status, body)
+	}
+	if status, body := get(t, authed, secure.URL, nil); status != http.StatusOK || body != "secure" {
+		t.Errorf("CONNECT 请求 status = %d, body = %q", status, body)
+	}
+}
+
+// socks5Connect 完成 SOCKS5 握手并请求连接 host:port，返回应答码
+func socks5Connect(t *testing.T, conn net.Conn, username, password string, host string, port int) byte {
+	t.Helper()
+	conn.SetDeadline(time.Now().Add(3 * time.Second))
+
+	method := byte(socks5AuthNone)
+	if username != "" {
+		method = socks5AuthPassword
+	}
+	conn.Write([]byte{socks5Version, 1, method})
+	resp := make([]byte, 2)
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		t.Fatalf("读取认证方式失败: %v", err)
+	}
+	if resp[1] != method {
+		return resp[1]
+	}
+	if username != "" {
+		auth := append([]byte{0x01, byte(len(username))}, username...)
+		auth = append(append(auth, byte(len(password))), password...)
+		conn.Write(auth)
+		if _, err := io.ReadFull(conn, resp); err != nil {
+			t.Fatalf("读取认证结果失败: %v", err)
+		}
+		if resp[1] != 0x00 {
+			return socks5AuthNoAccept
+		}
+	}
+
+	req := []byte{socks5Version, socks5CmdConnect, 0x00}
+	if ip := net.ParseIP(host).To4(); ip != nil {
+		req = append(append(req, socks5AddrIPv4), ip...)
+	} else {
+		req = append(append(req, socks5AddrDomain, byte(len(host))), host...)
+	}
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+	conn.Write(req)
+
+	reply := make([]byte, 10)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		t.Fatalf("读取应答失败: %v", err)
+	}
+	return reply[1]
+}
+
+// TestSOCKS5 测试 SOCKS5 认证、CONNECT 转发和目标不可达的应答
+func TestSOCKS5(t *testing.T) {
+	echo := echoServer(t)
+	defer echo.Close()
+	port := echo.Addr().(*net.TCPAddr).Port
+
+	if _, err := New(config.PluginConfig{Type: config.PluginSOCKS5}, ""); err == nil {
+		t.Error("未配置认证且未允许匿名时应拒绝创建")
+	}
+
+	p, _ := New(config.PluginConfig{Type: config.PluginSOCKS5, Username: "user", Password: "pass"}, "")
+	defer p.Close()
+	open := func() net.Conn {
+		conn, pluginConn := net.Pipe()
+		go p.Handle(context.Background(), pluginConn)
+		return conn
+	}
+
+	for _, host := range []string{"127.0.0.1", "localhost"} {
+		conn := open()
+		if code := socks5Connect(t, conn, "user", "pass", host, port); code != socks5ReplySuccess {
+			t.Fatalf("连接 %s 应答 = %d, want 0", host, code)
+		}
+		conn.Write([]byte("ping"))
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+			t.Errorf("经 %s 回显 = %q, err = %v", host, buf, err)
+		}
+		conn.Close()
+	}
+
+	conn := open()
+	if code := socks5Connect(t, conn, "user", "wrong", "127.0.0.1", port); code != socks5AuthNoAccept {
+		t.Errorf("密码错误应被拒绝, got %d", code)
+	}
+	conn.Close()
+
+	conn = open()
+	if code := socks5Connect(t, conn, "", "", "127.0.0.1", port); code != socks5AuthNoAccept {
+		t.Errorf("配置了认证时不应接受无认证, got %d", code)
+	}
+	conn.Close()
+
+	// 目标端口无人监听
+	dead, _ := net.Listen("tcp", "127.0.0.1:0")
+	deadPort := dead.Addr().(*net.TCPAddr).Port
+	dead.Close()
+	conn = open()
+	if code := socks5Connect(t, conn, "user", "pass", "127.0.0.1", deadPort); code != socks5ReplyRefused {
+		t.Errorf("连接被拒绝应答 = %d, want %d", code, socks5ReplyRefused)
+	}
+	conn.Close()
+}
@@ -0,0 +1,204 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/chiredeqiudong-dev/go-tunnel-lite/internal/pkg/config"
+	"github.com/chiredeqiudong-dev/go-tunnel-lite/internal/pkg/log"
+)
+
+/*
+SOCKS5 代理（RFC 1928），只支持 CONNECT
+配置了 username 时要求用户名/密码认证（RFC 1929），否则不认证（仅 allow_anonymous 时允许）
+*/
+
+const (
+	socks5Version = 0x05
+
+	socks5AuthNone     = 0x00
+	socks5AuthPassword = 0x02
+	socks5AuthNoAccept = 0xFF
+
+	socks5CmdConnect = 0x01
+
+	socks5AddrIPv4   = 0x01
+	socks5AddrDomain = 0x03
+	socks5AddrIPv6   = 0x04
+
+	socks5ReplySuccess         = 0x00
+	socks5ReplyFailure         = 0x01
+	socks5ReplyHostUnreachable = 0x04
+	socks5ReplyRefused         = 0x05
+	socks5ReplyCmdUnsupported  = 0x07
+	socks5ReplyAddrUnsupported = 0x08
+
+	// socks5HandshakeTimeout 完成握手的最长时间
+	socks5HandshakeTimeout = 10 * time.Second
+)
+
+type socks5 struct {
+	cfg config.PluginConfig
+}
+
+func newSOCKS5(cfg config.PluginConfig) Plugin {
+	return &socks5{cfg: cfg}
+}
+
+func (s *socks5) Close() error {
+	return nil
+}
+
+func (s *socks5) Handle(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(socks5HandshakeTimeout))
+	addr, err := s.handshake(conn)
+	if err != nil {
+		log.DebugContext(ctx, "SOCKS5 握手失败", "error", err)
+		return
+	}
+
+	target, err := dial(ctx, "tcp", addr)
+	if err != nil {
+		log.WarnContext(ctx, "SOCKS5 连接目标地址失败", "target", addr, "error", err)
+		writeSOCKS5Reply(conn, dialReply(err))
+		return
+	}
+	if err := writeSOCKS5Reply(conn, socks5ReplySuccess); err != nil {
+		target.Close()
+		return
+	}
+	conn.SetDeadline(time.Time{})
+
+	log.DebugContext(ctx, "SOCKS5 连接建立", "target", addr)
+	forward(ctx, conn, target)
+}
+
+// handshake 完成认证并读取 CONNECT 请求，返回目标地址
+func (s *socks5) handshake(conn net.Conn) (string, error) {
+	// 版本和认证方式
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return "", err
+	}
+	if header[0] != socks5Version {
+		return "", fmt.Errorf("不支持的版本 %d", header[0])
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return "", err
+	}
+
+	method := byte(socks5AuthNone)
+	if s.cfg.Username != "" {
+		method = socks5AuthPassword
+	}
+	if bytes.IndexByte(methods, method) < 0 {
+		conn.Write([]byte{socks5Version, socks5AuthNoAccept})
+		return "", errors.New("客户端不支持要求的认证方式")
+	}
+	if _, err := conn.Write([]byte{socks5Version, method}); err != nil {
+		return "", err
+	}
+	if method == socks5AuthPassword {
+		if err := s.authenticate(conn); err != nil {
+			return "", err
+		}
+	}
+
+	// 请求：VER CMD RSV ATYP DST.ADDR DST.PORT
+	req := make([]byte, 4)
+	if _, err := io.ReadFull(conn, req); err != nil {
+		return "", err
+	}
+	if req[1] != socks5CmdConnect {
+		writeSOCKS5Reply(conn, socks5ReplyCmdUnsupported)
+		return "", fmt.Errorf("不支持的命令 %d", req[1])
+	}
+
+	var host string
+	switch req[3] {
+	case socks5AddrIPv4, socks5AddrIPv6:
+		ip := make(net.IP, net.IPv4len)
+		if req[3] == socks5AddrIPv6 {
+			ip = make(net.IP, net.IPv6len)
+		}
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return "", err
+		}
+		host = ip.String()
+	case socks5AddrDomain:
+		n := make([]byte, 1)
+		if _, err := io.ReadFull(conn, n); err != nil {
+			return "", err
+		}
+		domain := make([]byte, n[0])
+		if _, err := io.ReadFull(conn, domain); err != nil {
+			return "", err
+		}
+		host = string(domain)
+	default:
+		writeSOCKS5Reply(conn, socks5ReplyAddrUnsupported)
+		return "", fmt.Errorf("不支持的地址类型 %d", req[3])
+	}
+
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+// authenticate 用户名/密码认证：VER ULEN UNAME PLEN PASSWD
+func (s *socks5) authenticate(conn net.Conn) error {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	username := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, username); err != nil {
+		return err
+	}
+	n := make([]byte, 1)
+	if _, err := io.ReadFull(conn, n); err != nil {
+		return err
+	}
+	password := make([]byte, n[0])
+	if _, err := io.ReadFull(conn, password); err != nil {
+		return err
+	}
+
+	if !checkCredentials(s.cfg, string(username), string(password)) {
+		conn.Write([]byte{0x01, 0x01})
+		return errors.New("用户名或密码错误")
+	}
+	_, err := conn.Write([]byte{0x01, 0x00})
+	return err
+}
+
+// writeSOCKS5Reply 发送应答，绑定地址固定为 0.0.0.0:0
+func writeSOCKS5Reply(conn net.Conn, code byte) error {
+	_, err := conn.Write([]byte{socks5Version, code, 0x00, socks5AddrIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
+
+// dialReply 将连接错误转换为应答码
+func dialReply(err error) byte {
+	var dnsErr *net.DNSError
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return socks5ReplyRefused
+	case errors.As(err, &dnsErr), errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH):
+		return socks5ReplyHostUnreachable
+	}
+	return socks5ReplyFailure
+}
@@ -2,10 +2,8 @@ package proto
 
 import (
 	"encoding/binary"
-	"encoding/json"
 	"errors"
 	"io"
-	"sync"
 )
 
 // BinaryEncoder 二进制编码器接口
@@ -33,35 +31,17 @@ const (
 // 二进制编码错误
 var (
 	ErrStringTooLong = errors.New("proto: string too long")
+	ErrTrailingData  = errors.New("proto: trailing data after message")
+	ErrInvalidBool   = errors.New("proto: invalid bool value")
 )
 
-// stringBufferPool 用于重用字符串编码缓冲区
-var stringBufferPool = sync.Pool{
-	New: func() interface{} {
-		return make([]byte, 0, 128) // 预分配128字节容量
-	},
-}
-
-// encodeBufferPool 用于重用一般编码缓冲区
-var encodeBufferPool = sync.Pool{
-	New: func() interface{} {
-		return make([]byte, 0, 1024) // 预分配1024字节容量
-	},
-}
-
-// encodeString 编码字符串（长度前缀）
-func encodeString(s string) []byte {
-	length := len(s)
-	if length > MaxStringLen {
-		length = MaxStringLen
-	}
-
-	// 创建新的缓冲区，避免数据污染
-	data := make([]byte, 2+length)
-	binary.BigEndian.PutUint16(data[0:2], uint16(length))
-	copy(data[2:], s[:length])
+// 以下为生成代码（codec_gen.go）使用的基础类型编解码函数
 
-	return data
+// appendString 追加字符串（2字节长度前缀），超过 MaxStringLen 的部分被截断
+func appendString(buf []byte, s string) []byte {
+	length := min(len(s), MaxStringLen)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(length))
+	return append(buf, s[:length]...)
 }
 
 // decodeString 解码字符串（长度前缀）
@@ -78,295 +58,76 @@ func decodeString(data []byte) (string, int, error) {
 	return string(data[2 : 2+length]), 2 + length, nil
 }
 
-// encodeBool 编码布尔值
-func encodeBool(b bool) []byte {
-	if b {
-		return []byte{1}
-	}
-	return []byte{0}
-}
-
-// getEncodeBuffer 从内存池获取编码缓冲区
-func getEncodeBuffer(size int) []byte {
-	// 创建新的缓冲区，避免数据污染
-	return make([]byte, size)
-}
-
-// putEncodeBuffer 将编码缓冲区归还到内存池
-func putEncodeBuffer(buf []byte) {
-	// 暂时不使用内存池，避免数据污染问题
-	// encodeBufferPool.Put(buf)
-}
-
-// decodeBool 解码布尔值
-func decodeBool(data []byte) (bool, error) {
-	if len(data) < 1 {
-		return false, io.ErrUnexpectedEOF
-	}
-	return data[0] == 1, nil
-}
-
-// AuthRequest 二进制编码实现
-func (r *AuthRequest) EncodeBinary() ([]byte, error) {
-	clientIDData := encodeString(r.ClientID)
-	tokenData := encodeString(r.Token)
-	versionData := encodeString(r.Version)
-
-	// 计算总长度
-	totalLen := len(clientIDData) + len(tokenData) + len(versionData)
-
-	// 从内存池获取缓冲区
-	data := getEncodeBuffer(totalLen)
-	defer putEncodeBuffer(data)
-
-	// 拼接数据
-	offset := 0
-	copy(data[offset:], clientIDData)
-	offset += len(clientIDData)
-	copy(data[offset:], tokenData)
-	offset += len(tokenData)
-	copy(data[offset:], versionData)
-
-	return data, nil
-}
-
-// AuthRequest 二进制解码实现
-func (r *AuthRequest) DecodeBinary(data []byte) error {
-	var offset int
-	var err error
-
-	// 解码 ClientID
-	r.ClientID, offset, err = decodeString(data)
-	if err != nil {
-		return err
+// appendStrings 追加字符串列表（2字节数量前缀）
+func appendStrings(buf []byte, list []string) []byte {
+	count := min(len(list), MaxStringLen)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(count))
+	for _, s := range list[:count] {
+		buf = appendString(buf, s)
 	}
-
-	// 解码 Token
-	var token string
-	token, n, err := decodeString(data[offset:])
-	if err != nil {
-		return err
-	}
-	r.Token = token
-	offset += n
-
-	// 解码 Version
-	r.Version, _, err = decodeString(data[offset:])
-	return err
-}
-
-// AuthResponse 二进制编码实现
-func (r *AuthResponse) EncodeBinary() ([]byte, error) {
-	successData := encodeBool(r.Success)
-	messageData := encodeString(r.Message)
-
-	totalLen := len(successData) + len(messageData)
-
-	// 从内存池获取缓冲区
-	data := getEncodeBuffer(totalLen)
-	defer putEncodeBuffer(data)
-
-	offset := 0
-	copy(data[offset:], successData)
-	offset += len(successData)
-	copy(data[offset:], messageData)
-
-	return data, nil
+	return buf
 }
 
-// AuthResponse 二进制解码实现
-func (r *AuthResponse) DecodeBinary(data []byte) error {
-	var offset int
-	var err error
-
-	// 解码 Success
-	r.Success, err = decodeBool(data)
-	if err != nil {
-		return err
+// decodeStrings 解码字符串列表（2字节数量前缀），返回的列表不为 nil
+func decodeStrings(data []byte) ([]string, int, error) {
+	if len(data) < 2 {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	count := int(binary.BigEndian.Uint16(data[0:2]))
+	offset := 2
+	list := make([]string, 0, min(count, len(data)/2))
+	for i := 0; i < count; i++ {
+		s, n, err := decodeString(data[offset:])
+		if err != nil {
+			return nil, 0, err
+		}
+		list = append(list, s)
+		offset += n
 	}
-	offset += 1
-
-	// 解码 Message
-	r.Message, _, err = decodeString(data[offset:])
-	return err
-}
-
-// TunnelConfig 二进制编码实现
-func (t *TunnelConfig) EncodeBinary() ([]byte, error) {
-	nameData := encodeString(t.Name)
-	typeData := encodeString(t.Type)
-	localAddrData := encodeString(t.LocalAddr)
-	remotePortData := make([]byte, 4)
-	binary.BigEndian.PutUint32(remotePortData, uint32(t.RemotePort))
-
-	totalLen := len(nameData) + len(typeData) + len(localAddrData) + len(remotePortData)
-
-	// 从内存池获取缓冲区
-	data := getEncodeBuffer(totalLen)
-	defer putEncodeBuffer(data)
-
-	offset := 0
-	copy(data[offset:], nameData)
-	offset += len(nameData)
-	copy(data[offset:], typeData)
-	offset += len(typeData)
-	copy(data[offset:], localAddrData)
-	offset += len(localAddrData)
-	copy(data[offset:], remotePortData)
-
-	return data, nil
+	return list, offset, nil
 }
 
-// TunnelConfig 二进制解码实现
-func (t *TunnelConfig) DecodeBinary(data []byte) error {
-	var offset int
-	var err error
-
-	// 解码 Name
-	t.Name, offset, err = decodeString(data)
-	if err != nil {
-		return err
-	}
-
-	// 解码 Type
-	var tunnelType string
-	tunnelType, n, err := decodeString(data[offset:])
-	if err != nil {
-		return err
-	}
-	t.Type = tunnelType
-	offset += n
-
-	// 解码 LocalAddr
-	t.LocalAddr, n, err = decodeString(data[offset:])
-	if err != nil {
-		return err
-	}
-	offset += n
-
-	// 解码 RemotePort
-	if len(data[offset:]) < 4 {
-		return io.ErrUnexpectedEOF
+// appendBool 追加布尔值（1字节）
+func appendBool(buf []byte, b bool) []byte {
+	if b {
+		return append(buf, 1)
 	}
-	t.RemotePort = int(binary.BigEndian.Uint32(data[offset : offset+4]))
-
-	return nil
-}
-
-// RegisterTunnelRequest 二进制编码实现
-func (r *RegisterTunnelRequest) EncodeBinary() ([]byte, error) {
-	return r.Tunnel.EncodeBinary()
-}
-
-// RegisterTunnelRequest 二进制解码实现
-func (r *RegisterTunnelRequest) DecodeBinary(data []byte) error {
-	return r.Tunnel.DecodeBinary(data)
+	return append(buf, 0)
 }
 
-// RegisterTunnelResponse 二进制编码实现
-func (r *RegisterTunnelResponse) EncodeBinary() ([]byte, error) {
-	successData := encodeBool(r.Success)
-	messageData := encodeString(r.Message)
-	tunnelNameData := encodeString(r.TunnelName)
-	remotePortData := make([]byte, 4)
-	binary.BigEndian.PutUint32(remotePortData, uint32(r.RemotePort))
-
-	totalLen := len(successData) + len(messageData) + len(tunnelNameData) + len(remotePortData)
-	data := make([]byte, totalLen)
-
-	offset := 0
-	copy(data[offset:], successData)
-	offset += len(successData)
-	copy(data[offset:], messageData)
-	offset += len(messageData)
-	copy(data[offset:], tunnelNameData)
-	offset += len(tunnelNameData)
-	copy(data[offset:], remotePortData)
-
-	return data, nil
-}
-
-// RegisterTunnelResponse 二进制解码实现
-func (r *RegisterTunnelResponse) DecodeBinary(data []byte) error {
-	var offset int
-	var err error
-
-	// 解码 Success
-	r.Success, err = decodeBool(data)
-	if err != nil {
-		return err
-	}
-	offset += 1
-
-	// 解码 Message
-	var message string
-	message, n, err := decodeString(data[offset:])
-	if err != nil {
-		return err
-	}
-	r.Message = message
-	offset += n
-
-	// 解码 TunnelName
-	r.TunnelName, n, err = decodeString(data[offset:])
-	if err != nil {
-		return err
+// decodeBool 解码布尔值，只接受 0 和 1
+func decodeBool(data []byte) (bool, int, error) {
+	if len(data) < 1 {
+		return false, 0, io.ErrUnexpectedEOF
 	}
-	offset += n
-
-	// 解码 RemotePort
-	if len(data[offset:]) < 4 {
-		return io.ErrUnexpectedEOF
+	switch data[0] {
+	case 0:
+		return false, 1, nil
+	case 1:
+		return true, 1, nil
+	default:
+		return false, 0, ErrInvalidBool
 	}
-	r.RemotePort = int(binary.BigEndian.Uint32(data[offset : offset+4]))
-
-	return nil
 }
 
-// NewProxyRequest 二进制编码实现
-func (r *NewProxyRequest) EncodeBinary() ([]byte, error) {
-	tunnelNameData := encodeString(r.TunnelName)
-	proxyIDData := encodeString(r.ProxyID)
-
-	totalLen := len(tunnelNameData) + len(proxyIDData)
-	data := make([]byte, totalLen)
-
-	offset := 0
-	copy(data[offset:], tunnelNameData)
-	offset += len(tunnelNameData)
-	copy(data[offset:], proxyIDData)
-
-	return data, nil
+// appendInt 追加整数（4字节大端）
+func appendInt(buf []byte, v int) []byte {
+	return binary.BigEndian.AppendUint32(buf, uint32(v))
 }
 
-// NewProxyRequest 二进制解码实现
-func (r *NewProxyRequest) DecodeBinary(data []byte) error {
-	var offset int
-	var err error
-
-	// 解码 TunnelName
-	r.TunnelName, offset, err = decodeString(data)
-	if err != nil {
-		return err
+// decodeInt 解码整数（4字节大端）
+func decodeInt(data []byte) (int, int, error) {
+	if len(data) < 4 {
+		return 0, 0, io.ErrUnexpectedEOF
 	}
-
-	// 解码 ProxyID
-	r.ProxyID, _, err = decodeString(data[offset:])
-	return err
+	return int(binary.BigEndian.Uint32(data[0:4])), 4, nil
 }
 
-// ProxyReadyRequest 二进制编码实现
-func (r *ProxyReadyRequest) EncodeBinary() ([]byte, error) {
-	return encodeString(r.ProxyID), nil
-}
-
-// ProxyReadyRequest 二进制解码实现
-func (r *ProxyReadyRequest) DecodeBinary(data []byte) error {
-	proxyID, _, err := decodeString(data)
-	if err != nil {
-		return err
+// checkEnd 检查消息体是否已完全解码，多余的字节视为格式错误
+func checkEnd(data []byte, offset int) error {
+	if offset != len(data) {
+		return ErrTrailingData
 	}
-	r.ProxyID = proxyID
 	return nil
 }
 
@@ -379,28 +140,3 @@ func EncodeBinary(msg BinaryMessage) ([]byte, error) {
 func DecodeBinary[T BinaryMessage](data []byte, msg T) error {
 	return msg.DecodeBinary(data)
 }
-
-// EncodeMixed 混合编码（优先二进制，回退JSON）
-func EncodeMixed(v interface{}) ([]byte, error) {
-	if msg, ok := v.(BinaryMessage); ok {
-		return msg.EncodeBinary()
-	}
-	return json.Marshal(v)
-}
-
-// DecodeMixed 混合解码（优先二进制，回退JSON）
-func DecodeMixed[T any](data []byte) (*T, error) {
-	v := new(T)
-
-	// 尝试二进制解码
-	if msg, ok := interface{}(v).(BinaryMessage); ok {
-		err := msg.DecodeBinary(data)
-		if err == nil {
-			return v, nil
-		}
-	}
-
-	// 回退到 JSON 解码
-	err := json.Unmarshal(data, v)
-	return v, err
-}
@@ -0,0 +1,94 @@
+package proto
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// binarySamples 每种消息类型的有效样例
+func binarySamples() []BinaryMessage {
+	return []BinaryMessage{
+		&AuthRequest{ClientID: "c1", Token: "t", Version: "1.0.0"},
+		&AuthRequest{ClientID: "c1", Token: "t", Version: ProtocolVersion, Capabilities: []string{CapBinary}},
+		&AuthResponse{Success: true, Message: "ok"},
+		&AuthResponse{Success: true, Message: "ok", Version: ProtocolVersion, Capabilities: []string{}},
+		&TunnelConfig{Name: "web", Type: "tcp", LocalAddr: "127.0.0.1:80", RemotePort: 8080},
+		&RegisterTunnelRequest{Tunnel: TunnelConfig{Name: "ssh", RemotePort: 2222}},
+		&RegisterTunnelRequest{Tunnel: TunnelConfig{Name: "api", RemotePort: 8081, Compression: "gzip"}},
+		&RegisterTunnelResponse{Success: true, TunnelName: "web", RemotePort: 8080},
+		&RegisterTunnelResponse{Success: true, TunnelName: "api", RemotePort: 8081, Compression: "gzip"},
+		&RegisterTunnelResponse{Success: true, TunnelName: "db", RemotePort: 8082, Encryption: true},
+		&UnregisterTunnelRequest{TunnelName: "web"},
+		&NewProxyRequest{TunnelName: "web", ProxyID: "abc"},
+		&ProxyReadyRequest{ProxyID: "abc"},
+		&DrainNotice{Message: "bye", Timeout: 30},
+	}
+}
+
+// TestDecodeBinaryStrict 测试解码拒绝多余字节和非法布尔值
+func TestDecodeBinaryStrict(t *testing.T) {
+	for _, sample := range binarySamples() {
+		data, err := sample.EncodeBinary()
+		if err != nil {
+			t.Fatalf("%T EncodeBinary failed: %v", sample, err)
+		}
+		msg := reflect.New(reflect.TypeOf(sample).Elem()).Interface().(BinaryMessage)
+		// 旧版本格式后的多余字节会被当作可选字段解析，同样必须报错
+		if err := msg.DecodeBinary(append(data, 0)); err == nil {
+			t.Errorf("%T 多余字节应解码失败", msg)
+		}
+		if err := msg.DecodeBinary(append(data, 0, 0, 0)); err == nil {
+			t.Errorf("%T 多余字节应解码失败", msg)
+		}
+	}
+
+	data := appendString([]byte{2}, "ok")
+	if err := (&AuthResponse{}).DecodeBinary(data); !errors.Is(err, ErrInvalidBool) {
+		t.Errorf("非法布尔值应返回 ErrInvalidBool, got %v", err)
+	}
+}
+
+// TestUnmarshalJSONStrict 测试 JSON 解码拒绝多余数据，且不再回退猜测编码
+func TestUnmarshalJSONStrict(t *testing.T) {
+	var req UnregisterTunnelRequest
+	if err := Unmarshal(EncodingJSON, []byte(`{"tunnel_name":"web"}`), &req); err != nil || req.TunnelName != "web" {
+		t.Fatalf("Unmarshal failed: %+v, %v", req, err)
+	}
+	if err := Unmarshal(EncodingJSON, []byte(`{"tunnel_name":"web"} {}`), &req); !errors.Is(err, ErrTrailingData) {
+		t.Errorf("多余 JSON 值应返回 ErrTrailingData, got %v", err)
+	}
+
+	// JSON 数据按二进制解码必须报错
+	if _, err := Decode[UnregisterTunnelRequest]([]byte(`{"tunnel_name":"web"}`)); err == nil {
+		t.Error("JSON 数据按二进制解码应失败")
+	}
+}
+
+// FuzzDecodeBinary 对所有消息类型的二进制解码器进行模糊测试
+// 解码成功的数据重新编码后必须与输入完全一致
+func FuzzDecodeBinary(f *testing.F) {
+	for _, sample := range binarySamples() {
+		data, err := sample.EncodeBinary()
+		if err != nil {
+			f.Fatalf("%T EncodeBinary failed: %v", sample, err)
+		}
+		f.Add(data)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, msg := range binaryMessages() {
+			if err := msg.DecodeBinary(data); err != nil {
+				continue
+			}
+			encoded, err := msg.EncodeBinary()
+			if err != nil {
+				t.Fatalf("%T 重新编码失败: %v", msg, err)
+			}
+			if !bytes.Equal(encoded, data) {
+				t.Fatalf("%T 重新编码结果不一致:\n got %x\nwant %x", msg, encoded, data)
+			}
+		}
+	})
+}
@@ -0,0 +1,67 @@
+// Code generated by protogen from types.go; DO NOT EDIT.
+
+package proto
+
+import (
+	"reflect"
+	"testing"
+)
+
+// binaryMessages 返回所有二进制消息类型的新实例
+func binaryMessages() []BinaryMessage {
+	return []BinaryMessage{
+		&AuthRequest{},
+		&AuthResponse{},
+		&TunnelConfig{},
+		&RegisterTunnelRequest{},
+		&RegisterTunnelResponse{},
+		&UnregisterTunnelRequest{},
+		&TunnelHealth{},
+		&NewProxyRequest{},
+		&ProxyReadyRequest{},
+		&ProxyFailed{},
+		&DrainNotice{},
+	}
+}
+
+// TestGeneratedRoundTrip 测试生成的编解码对零值和全字段赋值的消息往返一致
+func TestGeneratedRoundTrip(t *testing.T) {
+	tests := []BinaryMessage{
+		&AuthRequest{},
+		&AuthRequest{ClientID: "clientid", Token: "token", Version: "version", Capabilities: []string{"a", "b"}},
+		&AuthResponse{},
+		&AuthResponse{Success: true, Message: "message", Version: "version", Capabilities: []string{"a", "b"}},
+		&TunnelConfig{},
+		&TunnelConfig{Name: "name", Type: "type", LocalAddr: "localaddr", RemotePort: 4, Compression: "compression", Encryption: true, PreferredPort: 7, BindAddr: "bindaddr", Group: "group", Strategy: "strategy", Weight: 11},
+		&RegisterTunnelRequest{},
+		&RegisterTunnelRequest{Tunnel: TunnelConfig{Name: "name", Type: "type", LocalAddr: "localaddr", RemotePort: 4, Compression: "compression", Encryption: true, PreferredPort: 7, BindAddr: "bindaddr", Group: "group", Strategy: "strategy", Weight: 11}},
+		&RegisterTunnelResponse{},
+		&RegisterTunnelResponse{Success: true, Message: "message", TunnelName: "tunnelname", RemotePort: 4, Compression: "compression", Encryption: true},
+		&UnregisterTunnelRequest{},
+		&UnregisterTunnelRequest{TunnelName: "tunnelname"},
+		&TunnelHealth{},
+		&TunnelHealth{TunnelName: "tunnelname", Healthy: true, Message: "message"},
+		&NewProxyRequest{},
+		&NewProxyRequest{TunnelName: "tunnelname", ProxyID: "proxyid"},
+		&ProxyReadyRequest{},
+		&ProxyReadyRequest{ProxyID: "proxyid"},
+		&ProxyFailed{},
+		&ProxyFailed{ProxyID: "proxyid", Reason: "reason"},
+		&DrainNotice{},
+		&DrainNotice{Message: "message", Timeout: 2},
+	}
+
+	for _, want := range tests {
+		data, err := want.EncodeBinary()
+		if err != nil {
+			t.Fatalf("%T EncodeBinary failed: %v", want, err)
+		}
+		got := reflect.New(reflect.TypeOf(want).Elem()).Interface().(BinaryMessage)
+		if err := got.DecodeBinary(data); err != nil {
+			t.Fatalf("%T DecodeBinary failed: %v", want, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("往返结果不一致:\n got %+v\nwant %+v", got, want)
+		}
+	}
+}
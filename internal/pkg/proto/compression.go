@@ -0,0 +1,31 @@
+package proto
+
+import "fmt"
+
+/*
+数据连接压缩算法名称
+隧道注册时客户端在 TunnelConfig.Compression 中携带期望的算法，服务端在响应中确认
+配置校验和数据连接的压缩实现都使用这里的名称
+*/
+
+// 压缩算法名称
+const (
+	CompressionNone   = ""
+	CompressionGzip   = "gzip"
+	CompressionSnappy = "snappy"
+	CompressionZstd   = "zstd"
+)
+
+// Compressions 返回支持的压缩算法，按推荐程度排序
+func Compressions() []string {
+	return []string{CompressionZstd, CompressionSnappy, CompressionGzip}
+}
+
+// ValidateCompression 检查压缩算法名称，为空表示不压缩
+func ValidateCompression(name string) error {
+	switch name {
+	case CompressionNone, CompressionGzip, CompressionSnappy, CompressionZstd:
+		return nil
+	}
+	return fmt.Errorf("unknown compression %q", name)
+}
@@ -0,0 +1,107 @@
+package proto
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+/*
+消息体编码
+1. 每条连接使用明确的编码方式，不再按"先二进制、失败再 JSON"猜测
+2. 握手消息（Auth、AuthResp、ProxyReady）固定使用二进制编码
+3. 认证成功后按协商的能力选择编码：双方都支持 binary 时使用二进制，否则使用 JSON
+4. 解码严格校验，消息体有多余字节时返回错误
+*/
+
+// Encoding 消息体编码方式
+type Encoding uint8
+
+const (
+	EncodingBinary Encoding = iota // 二进制编码（默认）
+	EncodingJSON                   // JSON 编码
+)
+
+// ErrNotBinary 类型未实现二进制编解码
+var ErrNotBinary = errors.New("proto: type does not support binary encoding")
+
+// String 返回编码名称
+func (e Encoding) String() string {
+	switch e {
+	case EncodingBinary:
+		return "binary"
+	case EncodingJSON:
+		return "json"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(e))
+	}
+}
+
+// NegotiateEncoding 根据协商后的能力选择编码方式
+func NegotiateEncoding(capabilities []string) Encoding {
+	if HasCapability(capabilities, CapBinary) {
+		return EncodingBinary
+	}
+	return EncodingJSON
+}
+
+// Marshal 按指定编码序列化 v
+func Marshal(enc Encoding, v any) ([]byte, error) {
+	switch enc {
+	case EncodingBinary:
+		msg, ok := v.(BinaryEncoder)
+		if !ok {
+			return nil, ErrNotBinary
+		}
+		return msg.EncodeBinary()
+	case EncodingJSON:
+		return json.Marshal(v)
+	default:
+		return nil, fmt.Errorf("proto: unknown encoding %d", enc)
+	}
+}
+
+// binaryAppender 生成的编解码实现，支持追加到已有缓冲区
+type binaryAppender interface {
+	appendBinary(buf []byte) []byte
+}
+
+// AppendMarshal 按指定编码序列化 v 并追加到 buf，用于复用编码缓冲区
+func AppendMarshal(enc Encoding, buf []byte, v any) ([]byte, error) {
+	if enc == EncodingBinary {
+		if msg, ok := v.(binaryAppender); ok {
+			return msg.appendBinary(buf), nil
+		}
+	}
+	data, err := Marshal(enc, v)
+	if err != nil {
+		return nil, err
+	}
+	return append(buf, data...), nil
+}
+
+// Unmarshal 按指定编码反序列化到 v，v 必须是指针
+func Unmarshal(enc Encoding, data []byte, v any) error {
+	switch enc {
+	case EncodingBinary:
+		msg, ok := v.(BinaryDecoder)
+		if !ok {
+			return ErrNotBinary
+		}
+		return msg.DecodeBinary(data)
+	case EncodingJSON:
+		dec := json.NewDecoder(bytes.NewReader(data))
+		if err := dec.Decode(v); err != nil {
+			return err
+		}
+		// 只允许一个 JSON 值
+		if _, err := dec.Token(); err != io.EOF {
+			return ErrTrailingData
+		}
+		return nil
+	default:
+		return fmt.Errorf("proto: unknown encoding %d", enc)
+	}
+}
@@ -2,30 +2,57 @@ package proto
 
 import (
 	"encoding/binary"
-	"encoding/json"
+	"hash/crc32"
 	"io"
+	"slices"
 	"sync"
 )
 
 /*
 Message 结构体、编解码方法
+
+v1 帧（旧版本客户端）：
 +------+--------+---------+
 | Type | Length |  Data   |
 | 1字节 | 4字节  |  N字节   |
 +------+--------+---------+
+
+v2 帧：首字节最高位为 1，与 v1 的 Type（均小于 0x80）区分
++-------+------+--------+------------+-----------+---------+
+| Flags | Type | Length | [StreamID] | [CRC32]   |  Data   |
+| 1字节  | 1字节 | 4字节  | 4字节（可选）| 4字节（可选）|  N字节   |
++-------+------+--------+------------+-----------+---------+
 */
 
 // headerPool 用于重用消息头缓冲区，减少内存分配
+// 存放数组指针，避免 Put 时将切片装箱产生额外分配
 var headerPool = sync.Pool{
 	New: func() interface{} {
-		return make([]byte, HeaderLen)
+		return new([MaxHeaderLen]byte)
 	},
 }
 
+// crcTable CRC32 校验使用 Castagnoli 多项式（多数平台有硬件加速）
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
 // Message
 type Message struct {
-	Type uint8
-	Data []byte
+	Type     uint8
+	Flags    uint8  // 帧标志，非 0 时按 v2 帧编码
+	StreamID uint32 // 流 ID，非 0 时自动设置 FlagStreamID
+	Data     []byte
+}
+
+// frameFlags 返回实际写入的帧标志
+func (m *Message) frameFlags() uint8 {
+	flags := m.Flags
+	if m.StreamID != 0 {
+		flags |= FlagStreamID
+	}
+	if flags != 0 {
+		flags |= FlagV2
+	}
+	return flags
 }
 
 // 对 Message 按照已经定义好的协议进行编码
@@ -37,15 +64,42 @@ func (m *Message) WriteTo(w io.Writer) (n int64, err error) {
 	}
 
 	// 从内存池获取消息头
-	header := headerPool.Get().([]byte)
-	defer headerPool.Put(header)
+	hp := headerPool.Get().(*[MaxHeaderLen]byte)
+	defer headerPool.Put(hp)
+	header := hp[:]
+
+	// 消息类型最高位用于区分 v2 帧
+	if m.Type&FlagV2 != 0 {
+		return 0, ErrInvalidMsg
+	}
 
-	// 第1字节 数据类型，2-5字节 数据长度（大端序）
-	header[0] = m.Type
-	binary.BigEndian.PutUint32(header[1:5], uint32(dataLen))
+	headerLen := HeaderLen
+	flags := m.frameFlags()
+	if flags == 0 {
+		// v1：第1字节 数据类型，2-5字节 数据长度（大端序）
+		header[0] = m.Type
+		binary.BigEndian.PutUint32(header[1:5], uint32(dataLen))
+	} else {
+		if flags&^knownFlags != 0 {
+			return 0, ErrInvalidMsg
+		}
+		// v2：第1字节 标志，第2字节 数据类型，3-6字节 数据长度，之后为可选字段
+		header[0] = flags
+		header[1] = m.Type
+		binary.BigEndian.PutUint32(header[2:6], uint32(dataLen))
+		headerLen = HeaderLenV2
+		if flags&FlagStreamID != 0 {
+			binary.BigEndian.PutUint32(header[headerLen:], m.StreamID)
+			headerLen += 4
+		}
+		if flags&FlagChecksum != 0 {
+			binary.BigEndian.PutUint32(header[headerLen:], crc32.Checksum(m.Data, crcTable))
+			headerLen += 4
+		}
+	}
 
 	// 写入消息头
-	written, err := w.Write(header)
+	written, err := w.Write(header[:headerLen])
 	n = int64(written)
 	if err != nil {
 		return n, err
@@ -64,58 +118,143 @@ func (m *Message) WriteTo(w io.Writer) (n int64, err error) {
 	return n, nil
 }
 
-// 对 Message 按照已经定义好的协议进行解码
+// 对 Message 按照已经定义好的协议进行解码，同时支持 v1 和 v2 帧
 func (m *Message) ReadFrom(r io.Reader) (n int64, err error) {
+	return m.ReadFromLimit(r, MaxDataLen)
+}
+
+// ReadFromLimit 与 ReadFrom 相同，但限制消息体长度和允许的消息类型（types 为空时不限制）
+// 在分配消息体之前完成检查，用于认证前的不可信连接
+func (m *Message) ReadFromLimit(r io.Reader, maxDataLen uint32, types ...uint8) (n int64, err error) {
+	m.Data = nil
+	return m.readFrom(r, maxDataLen, false, types)
+}
+
+// ReadFromBuffer 与 ReadFromLimit 相同，但复用 m.Data 的底层数组存放消息体
+// 调用方必须确保之前读到的 m.Data 已不再使用
+func (m *Message) ReadFromBuffer(r io.Reader, maxDataLen uint32, types ...uint8) (n int64, err error) {
+	return m.readFrom(r, maxDataLen, true, types)
+}
+
+// minBodyCapacity 可复用消息体的最小容量
+const minBodyCapacity = 512
+
+// bodyCapacity 可复用消息体的分配容量，按 2 的幂向上取整以便后续消息复用
+func bodyCapacity(size int) int {
+	c := minBodyCapacity
+	for c < size {
+		c <<= 1
+	}
+	return c
+}
+
+// readFrom 读取一帧，reuse 为 true 时复用 m.Data 的底层数组
+func (m *Message) readFrom(r io.Reader, maxDataLen uint32, reuse bool, types []uint8) (n int64, err error) {
 	// 从内存池获取消息头
-	header := headerPool.Get().([]byte)
-	defer headerPool.Put(header)
+	hp := headerPool.Get().(*[MaxHeaderLen]byte)
+	defer headerPool.Put(hp)
+	header := hp[:]
 
-	readN, err := io.ReadFull(r, header)
+	// v1 和 v2 帧头都至少有 5 字节
+	readN, err := io.ReadFull(r, header[:HeaderLen])
 	n = int64(readN)
 	if err != nil {
 		return n, err
 	}
 
-	// 解析消息头
-	m.Type = header[0]
-	dataLen := binary.BigEndian.Uint32(header[1:5])
-	if dataLen > MaxDataLen {
+	var dataLen uint32
+	m.Flags, m.StreamID = 0, 0
+	checksum, hasChecksum := uint32(0), false
+
+	if header[0]&FlagV2 == 0 {
+		// 解析 v1 消息头
+		m.Type = header[0]
+		dataLen = binary.BigEndian.Uint32(header[1:5])
+	} else {
+		flags := header[0]
+		if flags&^knownFlags != 0 {
+			return n, ErrInvalidMsg
+		}
+
+		// 读取 v2 剩余的消息头
+		headerLen := HeaderLenV2
+		if flags&FlagStreamID != 0 {
+			headerLen += 4
+		}
+		if flags&FlagChecksum != 0 {
+			headerLen += 4
+		}
+		readN, err = io.ReadFull(r, header[HeaderLen:headerLen])
+		n += int64(readN)
+		if err != nil {
+			return n, err
+		}
+
+		m.Flags = flags &^ (FlagV2 | FlagStreamID)
+		m.Type = header[1]
+		if m.Type&FlagV2 != 0 {
+			return n, ErrInvalidMsg
+		}
+		dataLen = binary.BigEndian.Uint32(header[2:6])
+		offset := HeaderLenV2
+		if flags&FlagStreamID != 0 {
+			m.StreamID = binary.BigEndian.Uint32(header[offset:])
+			offset += 4
+		}
+		if flags&FlagChecksum != 0 {
+			checksum, hasChecksum = binary.BigEndian.Uint32(header[offset:]), true
+		}
+	}
+	if dataLen > maxDataLen || dataLen > MaxDataLen {
 		return n, ErrMsgTooLarge
 	}
+	if len(types) > 0 && !slices.Contains(types, m.Type) {
+		return n, ErrUnexpectedType
+	}
 
 	// 读取消息体
 	if dataLen > 0 {
-		m.Data = make([]byte, dataLen)
+		switch {
+		case !reuse:
+			m.Data = make([]byte, dataLen)
+		case cap(m.Data) >= int(dataLen):
+			m.Data = m.Data[:dataLen]
+		default:
+			m.Data = make([]byte, dataLen, bodyCapacity(int(dataLen)))
+		}
 		readN, err = io.ReadFull(r, m.Data)
 		n += int64(readN)
 		if err != nil {
 			return n, err
 		}
 	} else {
-		m.Data = nil
+		m.Data = m.Data[:0]
+	}
+
+	if hasChecksum && crc32.Checksum(m.Data, crcTable) != checksum {
+		return n, ErrChecksum
 	}
 
 	return n, nil
 }
 
-// 将消息体反序列化到制定结构体
+// 将消息体按指定编码反序列化到制定结构体
 // v 必须指针类型
-func (m *Message) Unmarshal(v interface{}) error {
+func (m *Message) Unmarshal(enc Encoding, v interface{}) error {
 	if len(m.Data) == 0 {
 		return nil
 	}
-	return json.Unmarshal(m.Data, v)
+	return Unmarshal(enc, m.Data, v)
 }
 
-// 创建一条 Message
-func NewMessage(msgType uint8, payload interface{}) (*Message, error) {
+// 创建一条 Message，payload 按指定编码序列化
+func NewMessage(msgType uint8, enc Encoding, payload interface{}) (*Message, error) {
 	msg := &Message{Type: msgType}
 	if payload == nil {
 		return msg, nil
 	}
 
-	// 将 payload 序列为 json
-	data, err := json.Marshal(payload)
+	data, err := Marshal(enc, payload)
 	if err != nil {
 		return nil, err
 	}
@@ -2,6 +2,7 @@ package proto
 
 import (
 	"bytes"
+	"slices"
 	"testing"
 )
 
@@ -53,7 +54,7 @@ func TestMessageWithPayload(t *testing.T) {
 		Version: "1.0.0",
 	}
 
-	msg, err := NewMessage(TypeAuth, authReq)
+	msg, err := NewMessage(TypeAuth, EncodingJSON, authReq)
 	if err != nil {
 		t.Fatalf("NewMessage failed: %v", err)
 	}
@@ -77,7 +78,7 @@ func TestMessageWithPayload(t *testing.T) {
 
 	// 4. 反序列化
 	var parsedReq AuthRequest
-	err = received.Unmarshal(&parsedReq)
+	err = received.Unmarshal(EncodingJSON, &parsedReq)
 	if err != nil {
 		t.Fatalf("Unmarshal failed: %v", err)
 	}
@@ -152,6 +153,8 @@ func TestGetTypeName(t *testing.T) {
 		{TypeAuthResp, "AuthResp"},
 		{TypePing, "Ping"},
 		{TypePong, "Pong"},
+		{TypeUnregisterTunnel, "UnregisterTunnel"},
+		{TypeDrain, "Drain"},
 		{0xFF, "Unknown"},
 	}
 
@@ -164,3 +167,243 @@ func TestGetTypeName(t *testing.T) {
 		}
 	}
 }
+
+// TestUnregisterTunnelRoundTrip 测试隧道注销请求的编解码
+func TestUnregisterTunnelRoundTrip(t *testing.T) {
+	req := &UnregisterTunnelRequest{TunnelName: "web"}
+
+	data, err := Encode(req)
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	decoded, err := Decode[UnregisterTunnelRequest](data)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if decoded.TunnelName != req.TunnelName {
+		t.Errorf("TunnelName mismatch: got %s, want %s", decoded.TunnelName, req.TunnelName)
+	}
+}
+
+// TestDrainNoticeRoundTrip 测试排空通知的编解码
+func TestDrainNoticeRoundTrip(t *testing.T) {
+	notice := &DrainNotice{Message: "服务端即将关闭", Timeout: 30}
+
+	data, err := Encode(notice)
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	decoded, err := Decode[DrainNotice](data)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if *decoded != *notice {
+		t.Errorf("DrainNotice mismatch: got %+v, want %+v", decoded, notice)
+	}
+}
+
+// TestAuthCapabilitiesRoundTrip 测试认证消息携带版本和能力的编解码
+func TestAuthCapabilitiesRoundTrip(t *testing.T) {
+	req := &AuthRequest{ClientID: "c1", Token: "t", Version: ProtocolVersion, Capabilities: []string{CapBinary, CapUDP}}
+	data, err := Encode(req)
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	decodedReq, err := Decode[AuthRequest](data)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if decodedReq.Version != req.Version || !slices.Equal(decodedReq.Capabilities, req.Capabilities) {
+		t.Errorf("AuthRequest mismatch: got %+v, want %+v", decodedReq, req)
+	}
+
+	resp := &AuthResponse{Success: true, Message: "ok", Version: ProtocolVersion, Capabilities: []string{CapBinary}}
+	data, err = Encode(resp)
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	decodedResp, err := Decode[AuthResponse](data)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if decodedResp.Version != resp.Version || !slices.Equal(decodedResp.Capabilities, resp.Capabilities) {
+		t.Errorf("AuthResponse mismatch: got %+v, want %+v", decodedResp, resp)
+	}
+}
+
+// TestAuthLegacyDecode 测试解码不携带能力列表的旧版本认证消息
+func TestAuthLegacyDecode(t *testing.T) {
+	data := appendString(appendString(appendString(nil, "c1"), "t"), "1.0.0")
+	req, err := Decode[AuthRequest](data)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if req.Version != "1.0.0" || req.Capabilities != nil {
+		t.Errorf("legacy AuthRequest = %+v", req)
+	}
+
+	data = appendString(appendBool(nil, true), "ok")
+	resp, err := Decode[AuthResponse](data)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if !resp.Success || resp.Version != "" || resp.Capabilities != nil {
+		t.Errorf("legacy AuthResponse = %+v", resp)
+	}
+}
+
+// TestCheckVersion 测试协议版本兼容性检查
+func TestCheckVersion(t *testing.T) {
+	tests := []struct {
+		version string
+		ok      bool
+	}{
+		{ProtocolVersion, true},
+		{"1.0.0", true},
+		{"1.9", true},
+		{"", true}, // 旧版本客户端
+		{"2.0.0", false},
+		{"0.9.0", false},
+		{"abc", false},
+	}
+	for _, tt := range tests {
+		if err := CheckVersion(tt.version); (err == nil) != tt.ok {
+			t.Errorf("CheckVersion(%q) = %v, want ok=%v", tt.version, err, tt.ok)
+		}
+	}
+}
+
+// TestNegotiateCapabilities 测试能力协商取交集
+func TestNegotiateCapabilities(t *testing.T) {
+	got := NegotiateCapabilities([]string{CapBinary, CapCompress, CapMux}, []string{CapMux, CapBinary, CapUDP})
+	want := []string{CapBinary, CapMux}
+	if !slices.Equal(got, want) {
+		t.Errorf("NegotiateCapabilities = %v, want %v", got, want)
+	}
+	if !HasCapability(got, CapMux) || HasCapability(got, CapUDP) {
+		t.Errorf("HasCapability 结果不正确: %v", got)
+	}
+}
+
+// TestMessageV2RoundTrip 测试 v2 帧携带流 ID 和校验和的往返编解码
+func TestMessageV2RoundTrip(t *testing.T) {
+	original := &Message{Type: TypeNewProxy, Flags: FlagChecksum, StreamID: 42, Data: []byte("payload")}
+
+	buf := &bytes.Buffer{}
+	if _, err := original.WriteTo(buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	if want := MaxHeaderLen + len(original.Data); buf.Len() != want {
+		t.Errorf("帧长度 = %d, want %d", buf.Len(), want)
+	}
+
+	received := &Message{}
+	if _, err := received.ReadFrom(buf); err != nil {
+		t.Fatalf("ReadFrom failed: %v", err)
+	}
+	if received.Type != original.Type || received.StreamID != 42 || received.Flags != FlagChecksum {
+		t.Errorf("帧头不一致: got %+v", received)
+	}
+	if !bytes.Equal(received.Data, original.Data) {
+		t.Errorf("Data mismatch: got %s, want %s", received.Data, original.Data)
+	}
+}
+
+// TestMessageV1Compat 测试未设置标志时写出 v1 帧，并能读取旧版本的 v1 帧
+func TestMessageV1Compat(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if _, err := (&Message{Type: TypePing, Data: []byte("x")}).WriteTo(buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), []byte{TypePing, 0, 0, 0, 1, 'x'}) {
+		t.Errorf("v1 帧格式不正确: %x", buf.Bytes())
+	}
+
+	received := &Message{}
+	if _, err := received.ReadFrom(buf); err != nil {
+		t.Fatalf("ReadFrom failed: %v", err)
+	}
+	if received.Type != TypePing || received.Flags != 0 || string(received.Data) != "x" {
+		t.Errorf("v1 帧解析不正确: %+v", received)
+	}
+}
+
+// TestMessageChecksumMismatch 测试校验和不一致和未知标志
+func TestMessageChecksumMismatch(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if _, err := (&Message{Type: TypePing, Flags: FlagChecksum, Data: []byte("data")}).WriteTo(buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	frame := buf.Bytes()
+	frame[len(frame)-1] ^= 0xFF // 篡改消息体
+
+	if _, err := (&Message{}).ReadFrom(bytes.NewReader(frame)); err != ErrChecksum {
+		t.Errorf("Expected ErrChecksum, got %v", err)
+	}
+
+	unknown := []byte{FlagV2 | 0x40, TypePing, 0, 0, 0, 0}
+	if _, err := (&Message{}).ReadFrom(bytes.NewReader(unknown)); err != ErrInvalidMsg {
+		t.Errorf("Expected ErrInvalidMsg, got %v", err)
+	}
+}
+
+// TestReadFromLimit 测试认证前的消息长度和类型限制
+func TestReadFromLimit(t *testing.T) {
+	buf := &bytes.Buffer{}
+	(&Message{Type: TypeAuth, Data: make([]byte, MaxPreAuthDataLen+1)}).WriteTo(buf)
+	if _, err := (&Message{}).ReadFromLimit(buf, MaxPreAuthDataLen, TypeAuth); err != ErrMsgTooLarge {
+		t.Errorf("Expected ErrMsgTooLarge, got %v", err)
+	}
+
+	buf.Reset()
+	(&Message{Type: TypePing}).WriteTo(buf)
+	if _, err := (&Message{}).ReadFromLimit(buf, MaxPreAuthDataLen, TypeAuth, TypeProxyReady); err != ErrUnexpectedType {
+		t.Errorf("Expected ErrUnexpectedType, got %v", err)
+	}
+
+	buf.Reset()
+	(&Message{Type: TypeAuth, Data: []byte("ok")}).WriteTo(buf)
+	msg := &Message{}
+	if _, err := msg.ReadFromLimit(buf, MaxPreAuthDataLen, TypeAuth); err != nil || string(msg.Data) != "ok" {
+		t.Errorf("ReadFromLimit = %+v, %v", msg, err)
+	}
+}
+
+// FuzzReadFrom 对帧解析进行模糊测试：任意输入不能 panic，解析成功的帧重新编码后可以再次解析
+func FuzzReadFrom(f *testing.F) {
+	for _, msg := range []*Message{
+		{Type: TypePing},
+		{Type: TypeAuth, Data: []byte("payload")},
+		{Type: TypeNewProxy, Flags: FlagChecksum, StreamID: 7, Data: []byte("v2")},
+	} {
+		buf := &bytes.Buffer{}
+		msg.WriteTo(buf)
+		f.Add(buf.Bytes())
+	}
+	f.Add([]byte{0x01, 0xFF, 0xFF, 0xFF, 0xFF})
+	f.Add([]byte{FlagV2 | 0x7F, TypePing, 0, 0, 0, 0})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		msg := &Message{}
+		if _, err := msg.ReadFromLimit(bytes.NewReader(data), MaxPreAuthDataLen); err != nil {
+			return
+		}
+		if len(msg.Data) > MaxPreAuthDataLen {
+			t.Fatalf("消息体超过限制: %d", len(msg.Data))
+		}
+
+		buf := &bytes.Buffer{}
+		if _, err := msg.WriteTo(buf); err != nil {
+			t.Fatalf("重新编码失败: %v", err)
+		}
+		again := &Message{}
+		if _, err := again.ReadFrom(buf); err != nil {
+			t.Fatalf("重新解析失败: %v", err)
+		}
+		if again.Type != msg.Type || again.StreamID != msg.StreamID || !bytes.Equal(again.Data, msg.Data) {
+			t.Fatalf("重新解析结果不一致: %+v vs %+v", again, msg)
+		}
+	})
+}
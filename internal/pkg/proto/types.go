@@ -7,8 +7,14 @@ import (
 /*
 1、通信协议、类型、错误定义
 2、请求响应编解码
+
+带有 //proto:binary 指令的结构体由 protogen 生成二进制编解码（codec_gen.go），
+新增消息类型只需定义结构体和 Type 常量后执行 go generate
 */
 
+//go:generate go run ../../tools/protogen -in types.go -out codec_gen.go -test codec_gen_test.go
+
+// 消息类型取值必须小于 0x80，首字节最高位用于区分 v2 帧
 const (
 	// 认证相关 (0x01-0x0F)
 	TypeAuth     uint8 = 0x01
@@ -17,100 +23,175 @@ const (
 	// 隧道管理 (0x10-0x1F)
 	TypeRegisterTunnel     uint8 = 0x10
 	TypeRegisterTunnelResp uint8 = 0x11
+	TypeUnregisterTunnel   uint8 = 0x12
+	TypeTunnelHealth       uint8 = 0x13
 
 	// 代理请求 (0x20-0x2F)
-	TypeNewProxy   uint8 = 0x20
-	TypeProxyReady uint8 = 0x21
+	TypeNewProxy    uint8 = 0x20
+	TypeProxyReady  uint8 = 0x21
+	TypeProxyFailed uint8 = 0x22
 
 	// 心跳保活 (0x30-0x3F)
 	TypePing uint8 = 0x30
 	TypePong uint8 = 0x31
+
+	// 连接管理 (0x40-0x4F)
+	TypeDrain uint8 = 0x40
 )
 
 const (
-	// HeaderLen 消息头长度：Type(1字节) + Length(4字节)
+	// HeaderLen v1 消息头长度：Type(1字节) + Length(4字节)
 	HeaderLen = 5
+	// HeaderLenV2 v2 消息头固定部分长度：Flags(1字节) + Type(1字节) + Length(4字节)
+	HeaderLenV2 = 6
+	// MaxHeaderLen v2 消息头最大长度：固定部分 + StreamID(4字节) + CRC32(4字节)
+	MaxHeaderLen = HeaderLenV2 + 8
 	// MaxDataLen 最大消息体长度 64KB, 防止恶意客户端发送超大消息耗尽内存
 	MaxDataLen = 64 * 1024
+	// MaxPreAuthDataLen 认证前（Auth、ProxyReady）允许的最大消息体长度
+	MaxPreAuthDataLen = 4 * 1024
+)
+
+// v2 帧标志
+const (
+	FlagV2       uint8 = 0x80 // v2 帧标记，写入时自动设置
+	FlagStreamID uint8 = 0x01 // 帧头携带 StreamID，写入时按 StreamID 自动设置
+	FlagChecksum uint8 = 0x02 // 帧头携带消息体的 CRC32
+
+	knownFlags = FlagV2 | FlagStreamID | FlagChecksum
 )
 
 // some errors
 var (
-	ErrMsgTooLarge = errors.New("proto: message too large")
-	ErrInvalidMsg  = errors.New("proto: invalid message")
+	ErrMsgTooLarge    = errors.New("proto: message too large")
+	ErrInvalidMsg     = errors.New("proto: invalid message")
+	ErrChecksum       = errors.New("proto: checksum mismatch")
+	ErrUnexpectedType = errors.New("proto: unexpected message type")
 )
 
 // 认证相关
+//
+//proto:binary
 type AuthRequest struct {
-	ClientID string `json:"client_id"`
-	Token    string `json:"token"`
-	Version  string `json:"version"`
+	ClientID     string   `json:"client_id"`
+	Token        string   `json:"token"`
+	Version      string   `json:"version"`                     // 客户端协议版本
+	Capabilities []string `json:"capabilities" bin:"optional"` // 客户端支持的能力
 }
 
+//proto:binary
 type AuthResponse struct {
-	Success bool   `json:"success"`
-	Message string `json:"message"`
+	Success      bool     `json:"success"`
+	Message      string   `json:"message"`
+	Version      string   `json:"version" bin:"optional"` // 服务端协议版本
+	Capabilities []string `json:"capabilities"`           // 协商后启用的能力
 }
 
+// 隧道类型，为空按 tcp 处理
+const (
+	TunnelTCP  = "tcp"  // 原样转发 TCP 流
+	TunnelHTTP = "http" // 承载 HTTP 流量，无法连接本地服务时服务端向用户返回 502 页面
+)
+
 // 隧道管理相关
+//
+//proto:binary
 type TunnelConfig struct {
-	Name       string `json:"name"`
-	Type       string `json:"type"`
-	LocalAddr  string `json:"local_addr"`
-	RemotePort int    `json:"remote_port"`
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	LocalAddr   string `json:"local_addr"`
+	RemotePort  int    `json:"remote_port"`
+	Compression string `json:"compression,omitempty" bin:"optional"` // 期望的数据连接压缩算法
+	Encryption  bool   `json:"encryption,omitempty"`                 // 是否加密数据连接
+	// RemotePort 为 0 时由服务端分配端口，PreferredPort 为客户端上次分配到的端口，可用时优先分配
+	PreferredPort int `json:"preferred_port,omitempty" bin:"optional"`
+	// BindAddr 公网监听地址，为空时使用服务端默认地址
+	BindAddr string `json:"bind_addr,omitempty" bin:"optional"`
+	// Group 负载均衡组名称，同组的隧道共享公网端口，由服务端按 Strategy 分配连接
+	Group    string `json:"group,omitempty" bin:"optional"`
+	Strategy string `json:"strategy,omitempty"` // 负载均衡策略，为空表示轮询
+	Weight   int    `json:"weight,omitempty"`   // 加权轮询的权重，0 按 1 处理
 }
 
+//proto:binary
 type RegisterTunnelRequest struct {
 	Tunnel TunnelConfig `json:"tunnel"`
 }
 
+//proto:binary
 type RegisterTunnelResponse struct {
-	Success    bool   `json:"success"`
-	Message    string `json:"message"`
+	Success     bool   `json:"success"`
+	Message     string `json:"message"`
+	TunnelName  string `json:"tunnel_name"`
+	RemotePort  int    `json:"remote_port"`
+	Compression string `json:"compression,omitempty" bin:"optional"` // 服务端确认的压缩算法，为空表示不压缩
+	Encryption  bool   `json:"encryption,omitempty"`                 // 服务端确认是否加密数据连接
+}
+
+//proto:binary
+type UnregisterTunnelRequest struct {
 	TunnelName string `json:"tunnel_name"`
-	RemotePort int    `json:"remote_port"`
+}
+
+// TunnelHealth 客户端上报隧道本地服务的健康状态，状态变化时发送
+//
+//proto:binary
+type TunnelHealth struct {
+	TunnelName string `json:"tunnel_name"`
+	Healthy    bool   `json:"healthy"`
+	Message    string `json:"message"` // 不健康的原因
 }
 
 // 代理相关
+//
+//proto:binary
 type NewProxyRequest struct {
 	TunnelName string `json:"tunnel_name"`
 	ProxyID    string `json:"proxy_id"`
 }
 
+//proto:binary
 type ProxyReadyRequest struct {
 	ProxyID string `json:"proxy_id"`
 }
 
-// GetTypeName 返回消息类型的可读名称
-func GetTypeName(t uint8) string {
-	switch t {
-	case TypeAuth:
-		return "Auth"
-	case TypeAuthResp:
-		return "AuthResp"
-	case TypeRegisterTunnel:
-		return "RegisterTunnel"
-	case TypeRegisterTunnelResp:
-		return "RegisterTunnelResp"
-	case TypeNewProxy:
-		return "NewProxy"
-	case TypeProxyReady:
-		return "ProxyReady"
-	case TypePing:
-		return "Ping"
-	case TypePong:
-		return "Pong"
-	default:
-		return "Unknown"
-	}
+// ProxyFailed 客户端无法处理 NewProxy（如连接本地服务失败），服务端据此立即关闭等待中的用户连接
+//
+//proto:binary
+type ProxyFailed struct {
+	ProxyID string `json:"proxy_id"`
+	Reason  string `json:"reason"`
+}
+
+// 连接管理相关
+// DrainNotice 通知对端本端即将关闭：不再接受新连接，已建立的数据流在 Timeout 秒后强制关闭
+//
+//proto:binary
+type DrainNotice struct {
+	Message string `json:"message"`
+	Timeout int    `json:"timeout"`
 }
 
 // Encode 将结构体序列化为二进制字节
 func Encode[T any](v *T) ([]byte, error) {
-	return EncodeMixed(v)
+	return Marshal(EncodingBinary, v)
 }
 
 // Decode 将二进制字节反序列化为结构体
 func Decode[T any](data []byte) (*T, error) {
-	return DecodeMixed[T](data)
+	return DecodeAs[T](EncodingBinary, data)
+}
+
+// EncodeAs 按指定编码序列化结构体
+func EncodeAs[T any](enc Encoding, v *T) ([]byte, error) {
+	return Marshal(enc, v)
+}
+
+// DecodeAs 按指定编码反序列化为结构体
+func DecodeAs[T any](enc Encoding, data []byte) (*T, error) {
+	v := new(T)
+	if err := Unmarshal(enc, data, v); err != nil {
+		return nil, err
+	}
+	return v, nil
 }
@@ -0,0 +1,90 @@
+package proto
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/*
+协议版本与能力协商
+1. 版本号格式为 主版本.次版本.修订号，主版本相同即视为兼容
+2. 双方在认证时交换各自支持的能力，取交集作为本次会话启用的能力
+3. 旧版本客户端不发送能力列表，按 1.0.0 且仅支持二进制编码处理
+*/
+
+// ProtocolVersion 当前协议版本
+const ProtocolVersion = "1.7.0"
+
+// legacyVersion 未携带版本号的对端视为该版本
+const legacyVersion = "1.0.0"
+
+// 能力名称
+const (
+	CapBinary      = "binary"       // 二进制编码
+	CapCompress    = "compress"     // 数据压缩
+	CapMux         = "mux"          // 连接多路复用
+	CapUDP         = "udp"          // UDP 隧道
+	CapFrameV2     = "frame_v2"     // v2 帧格式（标志位、流 ID、CRC32 校验）
+	CapAutoPort    = "auto_port"    // remote_port 为 0 时由服务端分配端口
+	CapBindAddr    = "bind_addr"    // 隧道指定公网监听地址
+	CapGroup       = "group"        // 负载均衡组
+	CapHealth      = "health"       // 客户端上报本地服务健康状态
+	CapProxyFailed = "proxy_failed" // 客户端通知服务端代理连接建立失败
+)
+
+// Capabilities 返回本端支持的能力
+func Capabilities() []string {
+	return []string{CapBinary, CapFrameV2, CapAutoPort, CapBindAddr, CapGroup, CapHealth, CapProxyFailed}
+}
+
+// CheckVersion 检查对端协议版本是否与本端兼容
+func CheckVersion(peer string) error {
+	if peer == "" {
+		peer = legacyVersion
+	}
+	peerMajor, err := majorVersion(peer)
+	if err != nil {
+		return err
+	}
+	localMajor, _ := majorVersion(ProtocolVersion)
+	if peerMajor != localMajor {
+		return fmt.Errorf("协议版本不兼容: 对端 %s, 本端 %s（要求主版本 %d）", peer, ProtocolVersion, localMajor)
+	}
+	return nil
+}
+
+// NegotiateCapabilities 返回双方都支持的能力，顺序与 local 一致
+func NegotiateCapabilities(local, peer []string) []string {
+	peerSet := make(map[string]bool, len(peer))
+	for _, c := range peer {
+		peerSet[c] = true
+	}
+	var agreed []string
+	for _, c := range local {
+		if peerSet[c] {
+			agreed = append(agreed, c)
+		}
+	}
+	return agreed
+}
+
+// HasCapability 判断能力列表中是否包含指定能力
+func HasCapability(caps []string, name string) bool {
+	for _, c := range caps {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
+
+// majorVersion 解析主版本号
+func majorVersion(v string) (int, error) {
+	major, _, _ := strings.Cut(v, ".")
+	n, err := strconv.Atoi(major)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("无效的协议版本: %q", v)
+	}
+	return n, nil
+}
@@ -0,0 +1,189 @@
+package proxy
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/chiredeqiudong-dev/go-tunnel-lite/internal/pkg/proto"
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+)
+
+/*
+数据连接压缩
+1. 隧道注册时协商压缩算法，客户端与服务端之间的数据连接两个方向都压缩
+2. 每次写入后立即 Flush，保证交互式协议的时延
+3. 压缩只作用于数据连接，用户连接和本地服务连接保持原样
+4. 算法名称定义在 proto 包，snappy 和 zstd 使用纯 Go 实现的 klauspost/compress
+*/
+
+// zstd 窗口和 snappy 块的上限，限制每条数据连接占用的内存
+const (
+	zstdWindow  = 1 << 20
+	snappyBlock = 64 << 10
+)
+
+// compressor 压缩算法实现
+type compressor struct {
+	newWriter func(w io.Writer) flushWriteCloser
+	newReader func(r io.Reader) (io.ReadCloser, error)
+}
+
+// flushWriteCloser 支持 Flush 的压缩写入器
+type flushWriteCloser interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// compressors 已实现的压缩算法
+var compressors = map[string]compressor{
+	proto.CompressionGzip: {
+		newWriter: func(w io.Writer) flushWriteCloser {
+			zw, _ := gzip.NewWriterLevel(w, gzip.BestSpeed) // 级别固定合法，不会出错
+			return zw
+		},
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		},
+	},
+	proto.CompressionSnappy: {
+		newWriter: func(w io.Writer) flushWriteCloser {
+			return s2.NewWriter(w, s2.WriterSnappyCompat(), s2.WriterConcurrency(1))
+		},
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			return io.NopCloser(s2.NewReader(r, s2.ReaderMaxBlockSize(snappyBlock))), nil
+		},
+	},
+	proto.CompressionZstd: {
+		newWriter: func(w io.Writer) flushWriteCloser {
+			// 选项均为合法的固定值，不会出错
+			zw, _ := zstd.NewWriter(w,
+				zstd.WithEncoderLevel(zstd.SpeedFastest),
+				zstd.WithEncoderConcurrency(1),
+				zstd.WithWindowSize(zstdWindow),
+				zstd.WithLowerEncoderMem(true))
+			return zw
+		},
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			zr, err := zstd.NewReader(r,
+				zstd.WithDecoderConcurrency(1),
+				zstd.WithDecoderMaxWindow(zstdWindow),
+				zstd.WithDecoderLowmem(true))
+			if err != nil {
+				return nil, err
+			}
+			return zr.IOReadCloser(), nil
+		},
+	},
+}
+
+// CompressionSupported 判断是否支持指定的压缩算法
+func CompressionSupported(name string) bool {
+	if name == proto.CompressionNone {
+		return true
+	}
+	_, ok := compressors[name]
+	return ok
+}
+
+// WrapCompression 使用指定算法包装数据连接，name 为空时原样返回
+func WrapCompression(conn net.Conn, name string) (net.Conn, error) {
+	if name == proto.CompressionNone {
+		return conn, nil
+	}
+	c, ok := compressors[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown compression %q", name)
+	}
+	return &compressConn{Conn: conn, codec: c, w: c.newWriter(conn)}, nil
+}
+
+// compressConn 读写时自动解压和压缩的连接
+type compressConn struct {
+	net.Conn
+	codec compressor
+
+	rmu sync.Mutex
+	r   io.ReadCloser // 首次读取时创建，避免读取压缩头时阻塞建连
+	src srcReader
+
+	wmu sync.Mutex
+	w   flushWriteCloser
+}
+
+// Read 读取并解压数据
+func (c *compressConn) Read(p []byte) (int, error) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+
+	if c.r == nil {
+		c.src.Reader = c.Conn
+		r, err := c.codec.newReader(&c.src)
+		if err != nil {
+			return 0, c.eofIfTruncated(err)
+		}
+		c.r = r
+	}
+	n, err := c.r.Read(p)
+	return n, c.eofIfTruncated(err)
+}
+
+// Write 压缩并立即发送数据
+func (c *compressConn) Write(p []byte) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	n, err := c.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	return n, c.w.Flush()
+}
+
+// CloseWrite 写入压缩流结尾并半关闭底层连接，对端读完剩余数据后得到 EOF
+func (c *compressConn) CloseWrite() error {
+	c.wmu.Lock()
+	err := c.w.Close()
+	c.wmu.Unlock()
+	if err != nil {
+		return err
+	}
+	return closeWrite(c.Conn)
+}
+
+// Close 写入压缩流结尾并关闭连接
+func (c *compressConn) Close() error {
+	// 写入方可能阻塞在网络上，拿不到锁时直接关闭连接
+	if c.wmu.TryLock() {
+		c.w.Close()
+		c.wmu.Unlock()
+	}
+	return c.Conn.Close()
+}
+
+// eofIfTruncated 对端未写入压缩流结尾就关闭连接时视为正常结束
+// 每次写入都已 Flush，之前收到的数据是完整的；底层连接本身报告截断（如加密流缺少结束分块）时原样返回
+func (c *compressConn) eofIfTruncated(err error) error {
+	if errors.Is(err, io.ErrUnexpectedEOF) && c.src.err == io.EOF {
+		return io.EOF
+	}
+	return err
+}
+
+// srcReader 记录底层连接最近一次读取错误
+type srcReader struct {
+	io.Reader
+	err error
+}
+
+func (r *srcReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if err != nil {
+		r.err = err
+	}
+	return n, err
+}
@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/chiredeqiudong-dev/go-tunnel-lite/internal/pkg/proto"
+)
+
+// TestCompressionRoundTrip 测试各压缩算法的交互式读写：每次写入后对端立即可读，半关闭后对端读到 EOF
+func TestCompressionRoundTrip(t *testing.T) {
+	for _, name := range proto.Compressions() {
+		t.Run(name, func(t *testing.T) {
+			a, b := tcpPair(t)
+			defer a.Close()
+			defer b.Close()
+			ca, err := WrapCompression(a, name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cb, err := WrapCompression(b, name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cb.SetReadDeadline(time.Now().Add(3 * time.Second))
+
+			// 不关闭连接也能读到刚写入的数据
+			if _, err := ca.Write([]byte("ping")); err != nil {
+				t.Fatalf("写入失败: %v", err)
+			}
+			buf := make([]byte, 4)
+			if _, err := io.ReadFull(cb, buf); err != nil || string(buf) != "ping" {
+				t.Fatalf("读取 = %q, %v, want ping", buf, err)
+			}
+
+			payload := bytes.Repeat([]byte("go-tunnel-lite "), 10000)
+			go func() {
+				ca.Write(payload)
+				ca.(interface{ CloseWrite() error }).CloseWrite()
+			}()
+			got, err := io.ReadAll(cb)
+			if err != nil {
+				t.Fatalf("读取失败: %v", err)
+			}
+			if !bytes.Equal(got, payload) {
+				t.Errorf("读取 %d 字节，want %d", len(got), len(payload))
+			}
+		})
+	}
+}
+
+// TestCompressionSupported 测试 proto 中定义的压缩算法都已实现
+func TestCompressionSupported(t *testing.T) {
+	for _, name := range proto.Compressions() {
+		if !CompressionSupported(name) {
+			t.Errorf("CompressionSupported(%q) = false", name)
+		}
+	}
+	if CompressionSupported("lz4") {
+		t.Error("CompressionSupported(lz4) = true")
+	}
+}
@@ -1,9 +1,16 @@
 package proxy
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"io"
 	"net"
+	"os"
 	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/chiredeqiudong-dev/go-tunnel-lite/internal/pkg/log"
 )
@@ -25,53 +32,273 @@ func PutBuffer(buf []byte) {
 	BufferPool.Put(buf)
 }
 
+// 转发路径
+const (
+	ForwardSplice = "splice" // 两端都是 TCP 连接，Linux 上使用 splice(2) 在内核中转发
+	ForwardCopy   = "copy"   // 经过压缩、加密等包装的连接，使用内存池缓冲区拷贝
+)
+
+// ForwardStats 转发路径的累计统计
+type ForwardStats struct {
+	SpliceStreams int64 // 使用 splice 转发的连接数
+	CopyStreams   int64 // 使用缓冲区拷贝转发的连接数
+	SpliceBytes   int64 // splice 转发的字节数
+	CopyBytes     int64 // 缓冲区拷贝转发的字节数
+}
+
+var forwardStats struct {
+	spliceStreams, copyStreams atomic.Int64
+	spliceBytes, copyBytes     atomic.Int64
+}
+
+// Stats 返回进程启动以来的转发路径统计
+func Stats() ForwardStats {
+	return ForwardStats{
+		SpliceStreams: forwardStats.spliceStreams.Load(),
+		CopyStreams:   forwardStats.copyStreams.Load(),
+		SpliceBytes:   forwardStats.spliceBytes.Load(),
+		CopyBytes:     forwardStats.copyBytes.Load(),
+	}
+}
+
 // ProxyConnection 代理连接，使用零拷贝
 type ProxyConnection struct {
-	localConn  net.Conn
-	remoteConn net.Conn
-	proxyID    string
+	ctx         context.Context // 携带 clientID、隧道名、proxyID 等日志字段
+	localConn   net.Conn
+	remoteConn  net.Conn
+	idleTimeout time.Duration // 空闲超时，0 表示不限制
+	closeOnce   sync.Once
 }
 
 // NewProxyConnection 创建代理连接
-func NewProxyConnection(local, remote net.Conn, proxyID string) *ProxyConnection {
+func NewProxyConnection(ctx context.Context, local, remote net.Conn) *ProxyConnection {
 	return &ProxyConnection{
+		ctx:        ctx,
 		localConn:  local,
 		remoteConn: remote,
-		proxyID:    proxyID,
 	}
 }
 
+// SetIdleTimeout 设置空闲超时，需在 Forward 之前调用
+// 每个方向在各自的连接上设置读超时，另一方向仍在传输数据时自动延长，
+// 只有所有未结束的方向都空闲超过 d 时才断开；向对端写入阻塞超过 d（对端不再读取）时同样断开
+func (pc *ProxyConnection) SetIdleTimeout(d time.Duration) {
+	pc.idleTimeout = d
+}
+
 // Close 关闭代理连接
 func (pc *ProxyConnection) Close() {
-	if pc.localConn != nil {
+	pc.closeBoth()
+}
+
+// closeBoth 关闭两端连接，可重复调用
+func (pc *ProxyConnection) closeBoth() {
+	pc.closeOnce.Do(func() {
 		pc.localConn.Close()
-	}
-	if pc.remoteConn != nil {
 		pc.remoteConn.Close()
-	}
-	pc.localConn = nil
-	pc.remoteConn = nil
+	})
 }
 
-// Forward 双向转发数据，使用零拷贝优化
-func (pc *ProxyConnection) Forward() {
-	var wg sync.WaitGroup
-	wg.Add(2)
+// ForwardResult 一次双向转发的统计结果
+type ForwardResult struct {
+	LocalToRemote int64  // local -> remote 字节数
+	RemoteToLocal int64  // remote -> local 字节数
+	Mode          string // 转发路径，两个方向都使用 splice 时为 ForwardSplice
+	Err           error  // 首个导致转发结束的错误，两个方向都正常结束时为 nil
+}
 
-	// local -> remote
-	go func() {
-		defer wg.Done()
-		n, _ := io.Copy(pc.remoteConn, pc.localConn)
-		log.Debug("转发完成", "proxyID", pc.proxyID, "direction", "local->remote", "bytes", n)
-	}()
+// Forward 双向转发数据，直到两个方向都结束
+// 两端都是未经包装的 TCP 连接时使用 splice(2) 零拷贝，否则使用内存池缓冲区
+// 一个方向读到 EOF 时对目标连接半关闭（CloseWrite），另一方向继续转发；
+// 任一方向出错或目标不支持半关闭时关闭两端，保证另一方向不会永久阻塞
+func (pc *ProxyConnection) Forward() ForwardResult {
+	var (
+		wg     sync.WaitGroup
+		result ForwardResult
+		errMu  sync.Mutex
+		modes  [2]string
+	)
+	setErr := func(err error) {
+		errMu.Lock()
+		if result.Err == nil {
+			result.Err = err
+		}
+		errMu.Unlock()
+	}
+
+	var idle *idleTimer
+	if pc.idleTimeout > 0 {
+		idle = newIdleTimer(pc.idleTimeout)
+	}
 
-	// remote -> local
-	go func() {
+	forward := func(dst, src net.Conn, direction string, bytes *int64, mode *string) {
 		defer wg.Done()
-		n, _ := io.Copy(pc.localConn, pc.remoteConn)
-		log.Debug("转发完成", "proxyID", pc.proxyID, "direction", "remote->local", "bytes", n)
-	}()
+		n, m, err := forwardCopy(dst, src, idle)
+		*bytes, *mode = n, m
+		if err == nil {
+			// 源端正常结束：把 EOF 传给对端，另一方向继续转发
+			err = closeWrite(dst)
+			if errors.Is(err, errors.ErrUnsupported) {
+				err = nil
+				pc.closeBoth()
+			}
+		}
+		if err != nil {
+			setErr(err)
+			pc.closeBoth()
+		}
+		log.DebugContext(pc.ctx, "转发完成", "direction", direction, "bytes", n, "mode", m)
+	}
 
+	wg.Add(2)
+	go forward(pc.remoteConn, pc.localConn, "local->remote", &result.LocalToRemote, &modes[0])
+	go forward(pc.localConn, pc.remoteConn, "remote->local", &result.RemoteToLocal, &modes[1])
 	wg.Wait()
-	log.Info("代理连接关闭", "proxyID", pc.proxyID)
+
+	result.Mode = ForwardCopy
+	if modes[0] == ForwardSplice && modes[1] == ForwardSplice {
+		result.Mode = ForwardSplice
+		forwardStats.spliceStreams.Add(1)
+	} else {
+		forwardStats.copyStreams.Add(1)
+	}
+	log.InfoContext(pc.ctx, "代理连接关闭", "mode", result.Mode)
+	return result
+}
+
+// errNoHalfClose 连接不支持半关闭，与其他连接类型一样归为 errors.ErrUnsupported
+var errNoHalfClose = fmt.Errorf("proxy: connection does not support half-close: %w", errors.ErrUnsupported)
+
+// closeWrite 关闭连接的写方向，对端读到 EOF 后仍可继续写入
+func closeWrite(conn net.Conn) error {
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return errNoHalfClose
+}
+
+// idleTimer 两个方向共享的最近活跃时间，用于按方向设置读超时和写超时
+// 读超时有意共享：下载等单向传输时另一方向长时间没有数据，但连接并不空闲，不能因此断开。
+// 写超时按方向独立：对端停止读取时写入阻塞，超过 timeout 后该方向失败并关闭两端
+type idleTimer struct {
+	timeout time.Duration
+	last    atomic.Int64 // 任一方向最近一次转发数据的时间（UnixNano）
+}
+
+func newIdleTimer(timeout time.Duration) *idleTimer {
+	t := &idleTimer{timeout: timeout}
+	t.touch()
+	return t
+}
+
+// touch 记录一次数据转发
+func (t *idleTimer) touch() {
+	if t != nil {
+		t.last.Store(time.Now().UnixNano())
+	}
+}
+
+// start 为源连接设置初始读超时
+func (t *idleTimer) start(src net.Conn) {
+	if t != nil {
+		src.SetReadDeadline(time.Now().Add(t.timeout))
+	}
+}
+
+// beforeWrite 为目标连接设置写超时，对端长时间不读取时写入失败
+func (t *idleTimer) beforeWrite(dst net.Conn) {
+	if t != nil {
+		dst.SetWriteDeadline(time.Now().Add(t.timeout))
+	}
+}
+
+// extend 读超时后判断是否仍有方向活跃，是则延长源连接的读超时并返回 true
+func (t *idleTimer) extend(src net.Conn, err error) bool {
+	if t == nil || !errors.Is(err, os.ErrDeadlineExceeded) {
+		return false
+	}
+	deadline := time.Unix(0, t.last.Load()).Add(t.timeout)
+	if !deadline.After(time.Now()) {
+		return false
+	}
+	src.SetReadDeadline(deadline)
+	return true
+}
+
+// forwardCopy 单向转发直到 src 结束或出错，返回转发字节数和实际使用的转发路径
+func forwardCopy(dst, src net.Conn, idle *idleTimer) (int64, string, error) {
+	idle.start(src)
+
+	if d, ok := dst.(*net.TCPConn); ok {
+		if s, ok := src.(*net.TCPConn); ok {
+			n, handled, err := spliceCopy(d, s, idle)
+			if handled {
+				forwardStats.spliceBytes.Add(n)
+				return n, ForwardSplice, err
+			}
+		}
+	}
+
+	n, err := copyBuffer(dst, src, idle)
+	forwardStats.copyBytes.Add(n)
+	return n, ForwardCopy, err
+}
+
+// copyBuffer 使用内存池缓冲区拷贝
+// 不使用 io.CopyBuffer：它会优先走 ReaderFrom/WriterTo，绕过缓冲区且无法确定实际路径
+func copyBuffer(dst, src net.Conn, idle *idleTimer) (written int64, err error) {
+	buf := GetBuffer()
+	defer PutBuffer(buf)
+
+	for {
+		nr, rerr := src.Read(buf)
+		if nr > 0 {
+			idle.touch()
+			idle.beforeWrite(dst)
+			nw, werr := dst.Write(buf[:nr])
+			written += int64(nw)
+			if werr != nil {
+				return written, werr
+			}
+			if nw != nr {
+				return written, io.ErrShortWrite
+			}
+		}
+		if rerr != nil {
+			if rerr == io.EOF {
+				return written, nil
+			}
+			if idle.extend(src, rerr) {
+				continue
+			}
+			return written, rerr
+		}
+	}
+}
+
+// 连接关闭原因
+const (
+	CloseReasonEOF     = "eof"
+	CloseReasonReset   = "reset"
+	CloseReasonTimeout = "timeout"
+	CloseReasonClosed  = "closed"
+	CloseReasonError   = "error"
+)
+
+// CloseReason 根据转发错误判断连接关闭原因
+func CloseReason(err error) string {
+	if err == nil || errors.Is(err, io.EOF) {
+		return CloseReasonEOF
+	}
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) {
+		return CloseReasonReset
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return CloseReasonTimeout
+	}
+	if errors.Is(err, net.ErrClosed) {
+		return CloseReasonClosed
+	}
+	return CloseReasonError
 }
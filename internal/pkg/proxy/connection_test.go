@@ -0,0 +1,372 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/chiredeqiudong-dev/go-tunnel-lite/internal/pkg/proto"
+)
+
+// tcpPair 返回一对已连接的回环 TCP 连接
+func tcpPair(tb testing.TB) (*net.TCPConn, *net.TCPConn) {
+	tb.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatalf("监听失败: %v", err)
+	}
+	defer ln.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, _ := ln.Accept()
+		accepted <- c
+	}()
+	dialed, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		tb.Fatalf("连接失败: %v", err)
+	}
+	peer := <-accepted
+	if peer == nil {
+		tb.Fatal("接受连接失败")
+	}
+	return dialed.(*net.TCPConn), peer.(*net.TCPConn)
+}
+
+// wrappedConn 隐藏 *net.TCPConn 类型，模拟压缩、加密等包装后的连接
+type wrappedConn struct {
+	net.Conn
+}
+
+// TestForwardMode 测试两端均为 TCP 时使用 splice，包装后的连接回退到缓冲区拷贝
+func TestForwardMode(t *testing.T) {
+	wantSplice := ForwardCopy
+	if runtime.GOOS == "linux" {
+		wantSplice = ForwardSplice
+	}
+
+	tests := []struct {
+		name string
+		wrap bool
+		want string
+	}{
+		{name: "tcp", wrap: false, want: wantSplice},
+		{name: "wrapped", wrap: true, want: ForwardCopy},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, local := tcpPair(t)
+			remote, service := tcpPair(t)
+
+			var remoteConn net.Conn = remote
+			if tt.wrap {
+				remoteConn = wrappedConn{remote}
+			}
+			before := Stats()
+
+			pc := NewProxyConnection(context.Background(), local, remoteConn)
+			done := make(chan ForwardResult, 1)
+			go func() { done <- pc.Forward() }()
+
+			// 大于单次 splice 的数据，验证分段转发的完整性
+			payload := make([]byte, 256*1024)
+			rand.Read(payload)
+			go user.Write(payload)
+
+			got := make([]byte, len(payload))
+			if _, err := io.ReadFull(service, got); err != nil {
+				t.Fatalf("读取转发数据失败: %v", err)
+			}
+			if !bytes.Equal(got, payload) {
+				t.Fatal("转发数据不一致")
+			}
+
+			user.Close()
+			service.Close()
+			result := <-done
+			pc.Close()
+
+			if result.Mode != tt.want {
+				t.Errorf("Mode = %q, want %q", result.Mode, tt.want)
+			}
+			if result.LocalToRemote != int64(len(payload)) {
+				t.Errorf("LocalToRemote = %d, want %d", result.LocalToRemote, len(payload))
+			}
+
+			after := Stats()
+			if tt.want == ForwardSplice && after.SpliceStreams != before.SpliceStreams+1 {
+				t.Errorf("SpliceStreams 未增加: %+v -> %+v", before, after)
+			}
+			if tt.want == ForwardCopy && after.CopyStreams != before.CopyStreams+1 {
+				t.Errorf("CopyStreams 未增加: %+v -> %+v", before, after)
+			}
+		})
+	}
+}
+
+// BenchmarkForwardLoopback 对比回环连接上 splice 与缓冲区拷贝的单向转发吞吐
+func BenchmarkForwardLoopback(b *testing.B) {
+	for _, wrap := range []bool{false, true} {
+		name := "splice"
+		if wrap {
+			name = "copy"
+		}
+		b.Run(name, func(b *testing.B) {
+			user, local := tcpPair(b)
+			remote, service := tcpPair(b)
+			defer user.Close()
+			defer service.Close()
+
+			var dst net.Conn = remote
+			var src net.Conn = local
+			if wrap {
+				dst, src = wrappedConn{remote}, wrappedConn{local}
+			}
+			go func() {
+				forwardCopy(dst, src, nil)
+				remote.Close()
+			}()
+
+			chunk := make([]byte, 128*1024)
+			b.SetBytes(int64(len(chunk)))
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			go func() {
+				for i := 0; i < b.N; i++ {
+					if _, err := user.Write(chunk); err != nil {
+						return
+					}
+				}
+				user.CloseWrite()
+			}()
+			n, err := io.Copy(io.Discard, service)
+			if err != nil || n != int64(b.N*len(chunk)) {
+				b.Fatalf("转发 %d 字节, want %d: %v", n, b.N*len(chunk), err)
+			}
+		})
+	}
+}
+
+// halfCloseConn 隐藏 *net.TCPConn 类型但保留半关闭，走缓冲区拷贝路径
+type halfCloseConn struct {
+	*net.TCPConn
+}
+
+func (c halfCloseConn) Read(p []byte) (int, error)  { return c.TCPConn.Read(p) }
+func (c halfCloseConn) Write(p []byte) (int, error) { return c.TCPConn.Write(p) }
+
+// startForward 在 local 和 remote 之间启动转发，返回结果通道
+func startForward(local, remote net.Conn, idle time.Duration) (*ProxyConnection, <-chan ForwardResult) {
+	pc := NewProxyConnection(context.Background(), local, remote)
+	pc.SetIdleTimeout(idle)
+	done := make(chan ForwardResult, 1)
+	go func() { done <- pc.Forward() }()
+	return pc, done
+}
+
+// waitForward 等待转发结束
+func waitForward(t *testing.T, done <-chan ForwardResult) ForwardResult {
+	t.Helper()
+	select {
+	case result := <-done:
+		return result
+	case <-time.After(3 * time.Second):
+		t.Fatal("转发未结束")
+		return ForwardResult{}
+	}
+}
+
+// TestForwardHalfClose 测试请求方半关闭后仍能收到完整响应
+func TestForwardHalfClose(t *testing.T) {
+	tests := []struct {
+		name string
+		// tunnel 在 user 与 service 之间建立转发，返回需要等待的转发结果
+		tunnel func(local, remote *net.TCPConn) []<-chan ForwardResult
+	}{
+		{
+			name: "splice",
+			tunnel: func(local, remote *net.TCPConn) []<-chan ForwardResult {
+				_, done := startForward(local, remote, 0)
+				return []<-chan ForwardResult{done}
+			},
+		},
+		{
+			name: "copy",
+			tunnel: func(local, remote *net.TCPConn) []<-chan ForwardResult {
+				_, done := startForward(halfCloseConn{local}, halfCloseConn{remote}, 0)
+				return []<-chan ForwardResult{done}
+			},
+		},
+		{
+			// 完整隧道：两段转发之间是压缩加密的数据连接
+			name: "compressed+encrypted",
+			tunnel: func(local, remote *net.TCPConn) []<-chan ForwardResult {
+				dataA, dataB := tcpPair(t)
+				wrap := func(c net.Conn, role CryptRole) net.Conn {
+					w, err := WrapCompression(WrapEncryption(c, "token", "stream", role), proto.CompressionGzip)
+					if err != nil {
+						t.Fatal(err)
+					}
+					return w
+				}
+				_, doneA := startForward(local, wrap(dataA, CryptServer), 0)
+				_, doneB := startForward(wrap(dataB, CryptClient), remote, 0)
+				return []<-chan ForwardResult{doneA, doneB}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, local := tcpPair(t)
+			remote, service := tcpPair(t)
+			defer user.Close()
+			defer service.Close()
+			dones := tt.tunnel(local, remote)
+
+			// 服务读到 EOF 后才返回响应
+			go func() {
+				req, _ := io.ReadAll(service)
+				service.Write(append([]byte("echo:"), req...))
+				service.CloseWrite()
+			}()
+
+			user.Write([]byte("request"))
+			user.CloseWrite()
+
+			user.SetReadDeadline(time.Now().Add(3 * time.Second))
+			resp, err := io.ReadAll(user)
+			if err != nil {
+				t.Fatalf("读取响应失败: %v", err)
+			}
+			if string(resp) != "echo:request" {
+				t.Errorf("响应 = %q, want %q", resp, "echo:request")
+			}
+
+			for _, done := range dones {
+				if result := waitForward(t, done); result.Err != nil {
+					t.Errorf("半关闭后正常结束不应有错误: %v", result.Err)
+				}
+			}
+		})
+	}
+}
+
+// TestForwardTeardown 测试一个方向出错或目标不支持半关闭时两端都被关闭
+func TestForwardTeardown(t *testing.T) {
+	t.Run("reset", func(t *testing.T) {
+		user, local := tcpPair(t)
+		remote, service := tcpPair(t)
+		defer user.Close()
+		_, done := startForward(local, remote, 0)
+
+		// 服务端发送 RST，另一方向不能继续阻塞
+		service.SetLinger(0)
+		service.Close()
+
+		result := waitForward(t, done)
+		if result.Err == nil {
+			t.Error("连接被重置应返回错误")
+		}
+		user.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err := user.Read(make([]byte, 1)); err == nil || errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Errorf("用户连接应被关闭, got %v", err)
+		}
+	})
+
+	t.Run("no half-close", func(t *testing.T) {
+		user, local := tcpPair(t)
+		remote, service := tcpPair(t)
+		defer user.Close()
+		defer service.Close()
+		_, done := startForward(local, wrappedConn{remote}, 0)
+
+		// 目标不支持半关闭时只能关闭两端
+		user.CloseWrite()
+		waitForward(t, done)
+
+		service.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err := service.Read(make([]byte, 1)); err != io.EOF {
+			t.Errorf("服务端应读到 EOF, got %v", err)
+		}
+	})
+}
+
+// TestForwardIdleTimeout 测试空闲超时：单向持续传输时不断开，双向空闲时断开
+func TestForwardIdleTimeout(t *testing.T) {
+	user, local := tcpPair(t)
+	remote, service := tcpPair(t)
+	defer user.Close()
+	defer service.Close()
+	_, done := startForward(local, remote, 200*time.Millisecond)
+
+	// 只有 remote->local 方向有数据，持续超过空闲超时
+	go io.Copy(io.Discard, user)
+	for i := 0; i < 8; i++ {
+		if _, err := service.Write([]byte("tick")); err != nil {
+			t.Fatalf("单向传输期间连接被关闭: %v", err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	select {
+	case result := <-done:
+		t.Fatalf("单向传输期间不应超时: %v", result.Err)
+	default:
+	}
+
+	start := time.Now()
+	result := waitForward(t, done)
+	if CloseReason(result.Err) != CloseReasonTimeout {
+		t.Errorf("CloseReason = %s, want timeout (err=%v)", CloseReason(result.Err), result.Err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("空闲超时过晚: %v", elapsed)
+	}
+}
+
+// TestForwardStuckWrite 测试对端停止读取时阻塞的写入在空闲超时后断开
+// 用户半关闭后只剩 remote->local 一个方向，读超时无法覆盖阻塞在写入上的情况
+func TestForwardStuckWrite(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		wrap func(net.Conn) net.Conn
+	}{
+		{"splice", func(c net.Conn) net.Conn { return c }},
+		{"copy", func(c net.Conn) net.Conn { return wrappedConn{c} }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			user, local := tcpPair(t)
+			remote, service := tcpPair(t)
+			defer user.Close()
+			defer service.Close()
+			user.SetReadBuffer(4096)
+			local.SetWriteBuffer(4096)
+			_, done := startForward(tc.wrap(local), remote, 200*time.Millisecond)
+
+			// 用户发送完请求后不再读取，服务端持续写入直到连接被关闭
+			user.CloseWrite()
+			go func() {
+				chunk := make([]byte, 32*1024)
+				for {
+					if _, err := service.Write(chunk); err != nil {
+						return
+					}
+				}
+			}()
+
+			result := waitForward(t, done)
+			if CloseReason(result.Err) != CloseReasonTimeout {
+				t.Errorf("CloseReason = %s, want timeout (err=%v)", CloseReason(result.Err), result.Err)
+			}
+		})
+	}
+}
@@ -0,0 +1,243 @@
+package proxy
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+)
+
+/*
+数据连接加密
+1. 使用 AES-256-GCM 对客户端与服务端之间的数据流分块加密，不依赖外层传输是否为 TLS
+2. 每个方向的写入方先发送 16 字节随机盐，密钥由 HKDF(token, proxyID + 盐, 方向) 派生
+   每条数据流、每个方向的密钥都不同，因此 nonce 可以使用从 0 开始的计数器
+   方向标签（c2s/s2c）写入 HKDF info，把一端发出的数据原样反射回去无法通过认证
+3. 分块格式：Length(2字节，密文长度) + 密文（含 16 字节认证标签）
+4. 写入方结束时发送明文为空的结束分块，读取方未收到结束分块就遇到连接结束时返回 io.ErrUnexpectedEOF，
+   防止数据流被中途截断而不被发现
+*/
+
+const (
+	cryptSaltLen  = 16
+	cryptKeyLen   = 32
+	cryptMaxChunk = 16 * 1024 // 单个分块的最大明文长度
+	cryptInfo     = "go-tunnel-lite data stream "
+)
+
+// ErrDecrypt 数据被篡改或密钥不一致
+var ErrDecrypt = errors.New("proxy: decrypt failed")
+
+// errCryptClosed 已发送结束分块后继续写入
+var errCryptClosed = errors.New("proxy: write after end of encrypted stream")
+
+// CryptRole 加密连接所在的一端，决定读写两个方向使用的密钥
+type CryptRole int
+
+const (
+	CryptClient CryptRole = iota // 客户端：写入 c2s，读取 s2c
+	CryptServer                  // 服务端：写入 s2c，读取 c2s
+)
+
+// labels 返回写入和读取方向的 HKDF 标签
+func (r CryptRole) labels() (write, read string) {
+	if r == CryptServer {
+		return "s2c", "c2s"
+	}
+	return "c2s", "s2c"
+}
+
+// WrapEncryption 使用共享密钥加密数据连接，streamID 为服务端分配的 proxyID
+func WrapEncryption(conn net.Conn, secret, streamID string, role CryptRole) net.Conn {
+	c := &cryptConn{Conn: conn, secret: []byte(secret), streamID: []byte(streamID)}
+	c.wlabel, c.rlabel = role.labels()
+	return c
+}
+
+// cryptConn 读写时自动解密和加密的连接
+type cryptConn struct {
+	net.Conn
+	secret   []byte
+	streamID []byte
+	wlabel   string // 写入方向的标签
+	rlabel   string // 读取方向的标签
+
+	rmu     sync.Mutex
+	raead   cipher.AEAD // 首次读取时根据对端的盐创建
+	rseq    uint64
+	pending []byte // 已解密但尚未读取的明文
+	rbuf    []byte
+	rdone   bool // 已收到结束分块
+
+	wmu   sync.Mutex
+	waead cipher.AEAD // 首次写入时生成盐并创建
+	wseq  uint64
+	wbuf  []byte
+	wdone bool // 已发送结束分块
+}
+
+// newAEAD 根据盐和方向标签派生密钥并创建 AEAD
+func (c *cryptConn) newAEAD(salt []byte, label string) (cipher.AEAD, error) {
+	key, err := hkdf.Key(sha256.New, c.secret, append(append([]byte{}, c.streamID...), salt...), cryptInfo+label, cryptKeyLen)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// nonce 由分块序号生成 nonce
+func nonce(aead cipher.AEAD, seq uint64) []byte {
+	n := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(n[len(n)-8:], seq)
+	return n
+}
+
+// Read 读取并解密数据
+func (c *cryptConn) Read(p []byte) (int, error) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+
+	for len(c.pending) == 0 {
+		if c.rdone {
+			return 0, io.EOF
+		}
+		if err := c.readChunk(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// readChunk 读取并解密一个分块，未收到结束分块时连接结束返回 io.ErrUnexpectedEOF
+func (c *cryptConn) readChunk() error {
+	if c.raead == nil {
+		salt := make([]byte, cryptSaltLen)
+		if _, err := io.ReadFull(c.Conn, salt); err != nil {
+			return unexpectedEOF(err)
+		}
+		aead, err := c.newAEAD(salt, c.rlabel)
+		if err != nil {
+			return err
+		}
+		c.raead = aead
+		c.rbuf = make([]byte, cryptMaxChunk+aead.Overhead())
+	}
+
+	var header [2]byte
+	if _, err := io.ReadFull(c.Conn, header[:]); err != nil {
+		return unexpectedEOF(err)
+	}
+	size := int(binary.BigEndian.Uint16(header[:]))
+	if size < c.raead.Overhead() || size > len(c.rbuf) {
+		return ErrDecrypt
+	}
+	if _, err := io.ReadFull(c.Conn, c.rbuf[:size]); err != nil {
+		return unexpectedEOF(err)
+	}
+
+	plain, err := c.raead.Open(c.rbuf[:0], nonce(c.raead, c.rseq), c.rbuf[:size], nil)
+	if err != nil {
+		return ErrDecrypt
+	}
+	c.rseq++
+	// 数据分块不会为空，空分块表示对端已结束写入
+	c.pending = plain
+	c.rdone = len(plain) == 0
+	return nil
+}
+
+// unexpectedEOF 未收到结束分块时连接结束视为被截断
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// CloseWrite 发送结束分块并半关闭底层连接
+func (c *cryptConn) CloseWrite() error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if err := c.writeEnd(); err != nil {
+		return err
+	}
+	return closeWrite(c.Conn)
+}
+
+// Close 发送结束分块并关闭连接
+func (c *cryptConn) Close() error {
+	// 写入方可能阻塞在网络上，拿不到锁时直接关闭连接，对端会读到 io.ErrUnexpectedEOF
+	if c.wmu.TryLock() {
+		c.writeEnd()
+		c.wmu.Unlock()
+	}
+	return c.Conn.Close()
+}
+
+// Write 分块加密并发送数据
+func (c *cryptConn) Write(p []byte) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	if c.wdone {
+		return 0, errCryptClosed
+	}
+	written := 0
+	for len(p) > 0 {
+		chunk := p[:min(len(p), cryptMaxChunk)]
+		if err := c.writeChunk(chunk); err != nil {
+			return written, err
+		}
+		written += len(chunk)
+		p = p[len(chunk):]
+	}
+	return written, nil
+}
+
+// writeEnd 发送结束分块，只发送一次
+func (c *cryptConn) writeEnd() error {
+	if c.wdone {
+		return nil
+	}
+	c.wdone = true
+	return c.writeChunk(nil)
+}
+
+// writeChunk 加密并发送一个分块，首次发送前先发送盐
+func (c *cryptConn) writeChunk(chunk []byte) error {
+	if c.waead == nil {
+		salt := make([]byte, cryptSaltLen)
+		if _, err := rand.Read(salt); err != nil {
+			return err
+		}
+		aead, err := c.newAEAD(salt, c.wlabel)
+		if err != nil {
+			return err
+		}
+		if _, err := c.Conn.Write(salt); err != nil {
+			return err
+		}
+		c.waead = aead
+		c.wbuf = make([]byte, 2+cryptMaxChunk+aead.Overhead())
+	}
+
+	sealed := c.waead.Seal(c.wbuf[2:2], nonce(c.waead, c.wseq), chunk, nil)
+	binary.BigEndian.PutUint16(c.wbuf[:2], uint16(len(sealed)))
+	if _, err := c.Conn.Write(c.wbuf[:2+len(sealed)]); err != nil {
+		return err
+	}
+	c.wseq++
+	return nil
+}
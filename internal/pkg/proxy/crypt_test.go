@@ -0,0 +1,104 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/chiredeqiudong-dev/go-tunnel-lite/internal/pkg/proto"
+)
+
+// writeEncrypted 以指定角色加密写入 data，返回线上的密文
+func writeEncrypted(t *testing.T, role CryptRole, data []byte, end bool) []byte {
+	t.Helper()
+	var wire bytes.Buffer
+	c := WrapEncryption(&bufConn{w: &wire}, "token", "stream", role)
+	if _, err := c.Write(data); err != nil {
+		t.Fatalf("写入失败: %v", err)
+	}
+	if end {
+		if err := c.(interface{ CloseWrite() error }).CloseWrite(); err != nil {
+			t.Fatalf("半关闭失败: %v", err)
+		}
+	}
+	return wire.Bytes()
+}
+
+// readEncrypted 以指定角色解密 wire，返回读到的明文和结束时的错误
+func readEncrypted(role CryptRole, wire []byte) ([]byte, error) {
+	c := WrapEncryption(&bufConn{r: bytes.NewReader(wire)}, "token", "stream", role)
+	return io.ReadAll(c)
+}
+
+// TestEncryptionRoundTrip 测试对端发送结束分块后读到正常的 EOF
+func TestEncryptionRoundTrip(t *testing.T) {
+	payload := bytes.Repeat([]byte("secret "), 5000)
+	wire := writeEncrypted(t, CryptClient, payload, true)
+	got, err := readEncrypted(CryptServer, wire)
+	if err != nil {
+		t.Fatalf("读取失败: %v", err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Errorf("读取 %d 字节，want %d", len(got), len(payload))
+	}
+}
+
+// TestEncryptionTruncated 测试数据流被截断时返回 io.ErrUnexpectedEOF
+func TestEncryptionTruncated(t *testing.T) {
+	full := writeEncrypted(t, CryptClient, []byte("hello"), true)
+	noEnd := writeEncrypted(t, CryptClient, []byte("hello"), false)
+
+	tests := []struct {
+		name string
+		wire []byte
+	}{
+		{"empty", nil},
+		{"salt only", full[:cryptSaltLen]},
+		{"mid chunk", full[:len(noEnd)-3]},
+		{"missing end chunk", noEnd},
+		{"mid end chunk", full[:len(full)-1]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := readEncrypted(CryptServer, tt.wire); !errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Errorf("err = %v, want io.ErrUnexpectedEOF", err)
+			}
+		})
+	}
+}
+
+// TestEncryptionTruncatedCompressed 测试压缩层不会把加密流的截断当作正常结束
+func TestEncryptionTruncatedCompressed(t *testing.T) {
+	var wire bytes.Buffer
+	w, _ := WrapCompression(WrapEncryption(&bufConn{w: &wire}, "token", "stream", CryptClient), proto.CompressionGzip)
+	w.Write([]byte("hello"))
+
+	r, _ := WrapCompression(WrapEncryption(&bufConn{r: &wire}, "token", "stream", CryptServer), proto.CompressionGzip)
+	got, err := io.ReadAll(r)
+	if string(got) != "hello" || !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("读取 = %q, %v, want hello, io.ErrUnexpectedEOF", got, err)
+	}
+}
+
+// TestEncryptionReflected 测试把一端发出的密文反射回该端时无法通过认证
+func TestEncryptionReflected(t *testing.T) {
+	for _, role := range []CryptRole{CryptClient, CryptServer} {
+		wire := writeEncrypted(t, role, []byte("hello"), true)
+		if _, err := readEncrypted(role, wire); !errors.Is(err, ErrDecrypt) {
+			t.Errorf("role %d: 反射的密文 err = %v, want ErrDecrypt", role, err)
+		}
+	}
+}
+
+// bufConn 读写内存缓冲区的连接
+type bufConn struct {
+	net.Conn
+	r io.Reader
+	w io.Writer
+}
+
+func (c *bufConn) Read(p []byte) (int, error)  { return c.r.Read(p) }
+func (c *bufConn) Write(p []byte) (int, error) { return c.w.Write(p) }
+func (c *bufConn) CloseWrite() error           { return nil }
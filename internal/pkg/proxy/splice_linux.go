@@ -0,0 +1,85 @@
+//go:build linux
+
+package proxy
+
+import (
+	"net"
+	"os"
+	"syscall"
+)
+
+// splice(2) 标志，syscall 包未导出
+const (
+	spliceFMove     = 0x1
+	spliceFNonblock = 0x2
+)
+
+// spliceChunk 单次 splice 的最大字节数，与默认管道容量一致
+const spliceChunk = 64 * 1024
+
+// spliceCopy 通过管道在两个 TCP 连接间使用 splice(2) 转发，数据不经过用户空间
+// 无法创建管道时返回 handled=false，由调用方回退到缓冲区拷贝
+func spliceCopy(dst, src *net.TCPConn, idle *idleTimer) (written int64, handled bool, err error) {
+	srcRaw, err := src.SyscallConn()
+	if err != nil {
+		return 0, false, nil
+	}
+	dstRaw, err := dst.SyscallConn()
+	if err != nil {
+		return 0, false, nil
+	}
+
+	var p [2]int
+	if err := syscall.Pipe2(p[:], syscall.O_CLOEXEC|syscall.O_NONBLOCK); err != nil {
+		return 0, false, nil
+	}
+	defer syscall.Close(p[0])
+	defer syscall.Close(p[1])
+
+	for {
+		// socket -> 管道，EAGAIN 时等待连接可读（遵循读超时）
+		var n int64
+		var serr error
+		if err := srcRaw.Read(func(fd uintptr) bool {
+			for {
+				n, serr = syscall.Splice(int(fd), nil, p[1], nil, spliceChunk, spliceFMove|spliceFNonblock)
+				if serr != syscall.EINTR {
+					return serr != syscall.EAGAIN
+				}
+			}
+		}); err != nil {
+			if idle.extend(src, err) {
+				continue
+			}
+			return written, true, err
+		}
+		if serr != nil {
+			return written, true, os.NewSyscallError("splice", serr)
+		}
+		if n == 0 {
+			return written, true, nil // EOF
+		}
+		idle.touch()
+		idle.beforeWrite(dst)
+
+		// 管道 -> socket，写完本次读入的全部数据（遵循写超时）
+		for n > 0 {
+			var m int64
+			if err := dstRaw.Write(func(fd uintptr) bool {
+				for {
+					m, serr = syscall.Splice(p[0], nil, int(fd), nil, int(n), spliceFMove|spliceFNonblock)
+					if serr != syscall.EINTR {
+						return serr != syscall.EAGAIN
+					}
+				}
+			}); err != nil {
+				return written, true, err
+			}
+			if serr != nil {
+				return written, true, os.NewSyscallError("splice", serr)
+			}
+			n -= m
+			written += m
+		}
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package proxy
+
+import "net"
+
+// spliceCopy 非 Linux 平台不支持 splice(2)，始终回退到缓冲区拷贝
+func spliceCopy(dst, src *net.TCPConn, idle *idleTimer) (written int64, handled bool, err error) {
+	return 0, false, nil
+}
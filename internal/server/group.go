@@ -0,0 +1,213 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/chiredeqiudong-dev/go-tunnel-lite/internal/pkg/config"
+	"github.com/chiredeqiudong-dev/go-tunnel-lite/internal/pkg/log"
+	"github.com/chiredeqiudong-dev/go-tunnel-lite/internal/pkg/proto"
+)
+
+/*
+负载均衡组
+1. 多个客户端注册同一 group 的隧道，共享一个公网端口，组内隧道名称可以相同
+2. 第一个成员注册时按正常流程分配并监听端口，之后的成员直接加入，策略、端口和监听地址必须与组一致
+3. 每条用户连接按策略选择一个成员，由该成员的客户端建立数据连接
+4. 成员注销、会话断开或排空时移出组，最后一个成员离开后关闭监听
+5. 客户端上报本地服务不健康的成员暂不分配连接，恢复后重新参与分配
+*/
+
+// Group 负载均衡组，持有共享的公网监听
+type Group struct {
+	name       string
+	strategy   string
+	remotePort int
+	bindAddr   string
+	ctx        context.Context // 日志上下文（group）
+	listener   net.Listener
+	mu         sync.Mutex
+	members    []*groupMember
+	next       int  // 轮询位置
+	closed     bool // 所有成员已离开，监听已关闭
+}
+
+// groupMember 组成员及其加权轮询状态
+type groupMember struct {
+	proxy   *Proxy
+	weight  int
+	current int // 平滑加权轮询的当前权重
+}
+
+func newGroup(name, strategy string) *Group {
+	if strategy == "" {
+		strategy = config.LBRoundRobin
+	}
+	return &Group{
+		name:     name,
+		strategy: strategy,
+		ctx:      log.WithAttrs(context.Background(), "group", name),
+	}
+}
+
+// joinGroup 将代理加入已有的负载均衡组，返回拒绝原因，空字符串表示成功
+// 调用方需持有 proxiesMu
+func (s *Server) joinGroup(g *Group, p *Proxy, tunnel *proto.TunnelConfig) string {
+	// 第一个成员在锁外启动监听，端口确定之前不接受其他成员
+	g.mu.Lock()
+	serving, remotePort, bindAddr := g.listener != nil, g.remotePort, g.bindAddr
+	g.mu.Unlock()
+	if !serving {
+		return fmt.Sprintf("负载均衡组 %s 正在启动，请稍后重试", g.name)
+	}
+
+	if tunnel.Strategy != "" && tunnel.Strategy != g.strategy {
+		return fmt.Sprintf("负载均衡组 %s 使用 %s 策略", g.name, g.strategy)
+	}
+	if tunnel.RemotePort != 0 && tunnel.RemotePort != remotePort {
+		return fmt.Sprintf("负载均衡组 %s 使用端口 %d", g.name, remotePort)
+	}
+	if tunnel.BindAddr != "" && !net.ParseIP(p.bindAddr).Equal(net.ParseIP(bindAddr)) {
+		return fmt.Sprintf("负载均衡组 %s 的监听地址为 %s", g.name, bindAddr)
+	}
+	// 保留给其他客户端的端口同样不能通过加入组使用
+	if reason := s.checkPort(p.session.clientID, remotePort, false); reason != "" {
+		return reason
+	}
+
+	p.remotePort, p.bindAddr, p.group = remotePort, bindAddr, g
+	if !g.add(p, tunnel.Weight) {
+		return fmt.Sprintf("负载均衡组 %s 正在关闭", g.name)
+	}
+	return ""
+}
+
+// serve 接管第一个成员的监听并开始接受用户连接
+func (g *Group) serve(listener net.Listener, remotePort int, bindAddr string) {
+	g.mu.Lock()
+	g.listener = listener
+	g.remotePort = remotePort
+	g.bindAddr = bindAddr
+	g.mu.Unlock()
+
+	log.InfoContext(g.ctx, "负载均衡组监听启动", "addr", listener.Addr(), "strategy", g.strategy)
+	go g.acceptLoop()
+}
+
+func (g *Group) acceptLoop() {
+	for {
+		conn, err := g.listener.Accept()
+		if err != nil {
+			if g.isClosed() {
+				return
+			}
+			log.ErrorContext(g.ctx, "接受连接失败", "error", err)
+			continue
+		}
+
+		member := g.pick()
+		if member == nil {
+			conn.Close()
+			continue
+		}
+		go member.handleConnection(conn)
+	}
+}
+
+// add 加入成员，组已关闭时返回 false
+func (g *Group) add(p *Proxy, weight int) bool {
+	if weight <= 0 {
+		weight = 1
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.closed {
+		return false
+	}
+	g.members = append(g.members, &groupMember{proxy: p, weight: weight})
+	log.InfoContext(g.ctx, "加入负载均衡组", "tunnelName", p.name, "clientID", p.session.clientID,
+		"weight", weight, "members", len(g.members))
+	return true
+}
+
+// remove 移出成员，不再分配新连接；最后一个成员离开时关闭监听，可重复调用
+func (g *Group) remove(p *Proxy) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for i, m := range g.members {
+		if m.proxy == p {
+			g.members = append(g.members[:i], g.members[i+1:]...)
+			log.InfoContext(g.ctx, "离开负载均衡组", "tunnelName", p.name, "clientID", p.session.clientID,
+				"members", len(g.members))
+			break
+		}
+	}
+	if len(g.members) == 0 && !g.closed {
+		g.closed = true
+		if g.listener != nil {
+			g.listener.Close()
+		}
+		log.InfoContext(g.ctx, "负载均衡组已关闭", "port", g.remotePort)
+	}
+}
+
+func (g *Group) isClosed() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.closed
+}
+
+// pick 按策略选择处理下一条用户连接的成员，跳过本地服务不健康的成员，没有可用成员时返回 nil
+func (g *Group) pick() *Proxy {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	members := make([]*groupMember, 0, len(g.members))
+	for _, m := range g.members {
+		if m.proxy.Healthy() {
+			members = append(members, m)
+		}
+	}
+	n := len(members)
+	if n == 0 {
+		return nil
+	}
+
+	switch g.strategy {
+	case config.LBLeastConn:
+		// 从轮询位置开始比较，活跃连接数相同时依次分配
+		var best *Proxy
+		bestConns := 0
+		for i := 0; i < n; i++ {
+			p := members[(g.next+i)%n].proxy
+			if conns := p.ActiveConns(); best == nil || conns < bestConns {
+				best, bestConns = p, conns
+			}
+		}
+		g.next++
+		return best
+
+	case config.LBWeighted:
+		// 平滑加权轮询：每轮所有成员加上各自权重，选中当前权重最大者并减去总权重
+		total := 0
+		var best *groupMember
+		for _, m := range members {
+			m.current += m.weight
+			total += m.weight
+			if best == nil || m.current > best.current {
+				best = m
+			}
+		}
+		best.current -= total
+		return best.proxy
+
+	default:
+		p := members[g.next%n].proxy
+		g.next++
+		return p
+	}
+}
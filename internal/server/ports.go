@@ -0,0 +1,190 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/chiredeqiudong-dev/go-tunnel-lite/internal/pkg/config"
+)
+
+/*
+端口策略
+1. denied_ports 中的端口任何客户端都不能使用
+2. reserved_ports 中的端口只允许对应 client_id 的客户端使用，且不受 public_ports 限制
+3. 其余端口需在 public_ports 中（为空则允许所有端口）
+4. 端口已被其他隧道使用时拒绝注册，不再等到监听失败；不同监听地址上的相同端口互不冲突
+5. 隧道默认监听 proxy_bind_addr，指定其他地址时必须在 allowed_bind_addrs 中
+
+端口自动分配
+1. remote_port 为 0 的隧道由服务端分配端口：配置了 auto_port_range 时从该范围分配，
+   否则从 public_ports 中分配，两者都未配置时由系统分配；同样遵循禁止和保留规则
+2. 客户端重连时携带上次分配到的端口，未被占用时优先分配，保证重连后公网端口不变
+3. 跳过已被其他隧道使用的端口，监听失败（被其他进程占用）时尝试下一个
+*/
+
+// errNoFreePort 可分配的端口均已被占用
+var errNoFreePort = errors.New("no free port to assign")
+
+// checkPort 检查客户端能否使用指定端口，返回拒绝原因，空字符串表示允许
+// whitelist 为 false 时不检查 public_ports（用于 auto_port_range 中的端口）
+func (s *Server) checkPort(clientID string, port int, whitelist bool) string {
+	if port < 1 || port > 65535 {
+		return fmt.Sprintf("端口 %d 无效", port)
+	}
+	if config.ContainsPort(s.cfg.Server.DeniedPorts, port) {
+		return fmt.Sprintf("端口 %d 禁止使用", port)
+	}
+	for _, r := range s.cfg.Server.ReservedPorts {
+		if r.Ports.Contains(port) {
+			if r.ClientID != clientID {
+				return fmt.Sprintf("端口 %d 已保留给其他客户端", port)
+			}
+			return ""
+		}
+	}
+	if whitelist && len(s.cfg.Server.PublicPorts) > 0 && !config.ContainsPort(s.cfg.Server.PublicPorts, port) {
+		return fmt.Sprintf("端口 %d 不在允许使用的端口范围内", port)
+	}
+	return ""
+}
+
+// portInUse 判断端口在监听地址上是否已被其他隧道使用，调用方需持有 proxiesMu
+// 不同地址上的相同端口互不冲突，通配地址与任何地址冲突
+func (s *Server) portInUse(bindAddr string, port int) bool {
+	for _, p := range s.proxies {
+		if p.remotePort == port && bindConflict(p.bindAddr, bindAddr) {
+			return true
+		}
+	}
+	return false
+}
+
+// bindConflict 判断两个监听地址是否重叠
+func bindConflict(a, b string) bool {
+	ipA, ipB := net.ParseIP(a), net.ParseIP(b)
+	if ipA == nil || ipB == nil || ipA.IsUnspecified() || ipB.IsUnspecified() {
+		return true
+	}
+	return ipA.Equal(ipB)
+}
+
+// resolveBindAddr 返回隧道的公网监听地址和拒绝原因
+// 隧道未指定时使用 proxy_bind_addr，指定的地址必须在 allowed_bind_addrs 中
+func (s *Server) resolveBindAddr(requested string) (string, string) {
+	if requested == "" {
+		return s.cfg.Server.ProxyBindAddr, ""
+	}
+	ip := net.ParseIP(requested)
+	for _, allowed := range s.cfg.Server.AllowedBindAddrs {
+		if ip != nil && ip.Equal(net.ParseIP(allowed)) {
+			return requested, ""
+		}
+	}
+	return "", fmt.Sprintf("监听地址 %s 不允许使用", requested)
+}
+
+// autoPortCount 返回可自动分配的端口数，0 表示由系统分配
+func (s *Server) autoPortCount() int {
+	if r := s.cfg.Server.AutoPortRange; !r.IsZero() {
+		return r.Size()
+	}
+	count := 0
+	for _, r := range s.cfg.Server.PublicPorts {
+		count += r.Size()
+	}
+	return count
+}
+
+// autoPortAt 返回第 i 个可自动分配的端口
+func (s *Server) autoPortAt(i int) int {
+	if r := s.cfg.Server.AutoPortRange; !r.IsZero() {
+		return r.Min + i
+	}
+	for _, r := range s.cfg.Server.PublicPorts {
+		if i < r.Size() {
+			return r.Min + i
+		}
+		i -= r.Size()
+	}
+	return 0
+}
+
+// checkAutoPort 检查端口能否自动分配给客户端
+func (s *Server) checkAutoPort(clientID string, port int) bool {
+	if r := s.cfg.Server.AutoPortRange; !r.IsZero() {
+		return r.Contains(port) && s.checkPort(clientID, port, false) == ""
+	}
+	return s.checkPort(clientID, port, true) == ""
+}
+
+// startAutoPort 为未指定端口的隧道分配端口并启动代理，优先使用 preferred
+// 代理已在 proxies 中登记，端口在 proxiesMu 下选定后登记给代理，释放锁后再监听
+func (s *Server) startAutoPort(p *Proxy, preferred int) error {
+	// 监听失败（被其他进程占用）时换下一个端口
+	tried := make(map[int]bool)
+	for {
+		port := s.reserveAutoPort(p, preferred, tried)
+		if port == 0 {
+			break
+		}
+		tried[port] = true
+		_, err := p.listen(port)
+		if err == nil {
+			p.serve()
+			return nil
+		}
+		if err == errProxyStopped {
+			return err
+		}
+	}
+	if s.autoPortCount() > 0 {
+		return errNoFreePort
+	}
+
+	// 由系统分配的端口同样不能落在禁止或保留的端口上，监听成功后再登记给代理
+	clientID := p.session.clientID
+	for attempt := 0; attempt < 8; attempt++ {
+		port, err := p.listen(0)
+		if err != nil {
+			return err
+		}
+		if s.checkPort(clientID, port, false) == "" {
+			s.proxiesMu.Lock()
+			p.setRemotePort(port)
+			s.proxiesMu.Unlock()
+			p.serve()
+			return nil
+		}
+		p.closeListener()
+	}
+	return errNoFreePort
+}
+
+// reserveAutoPort 选出一个可分配且未被其他隧道使用的端口登记给 p，没有可用端口时返回 0
+// 从上次分配的位置继续，避免刚释放的端口立即分配给其他隧道，给原客户端重连留出时间
+func (s *Server) reserveAutoPort(p *Proxy, preferred int, tried map[int]bool) int {
+	s.proxiesMu.Lock()
+	defer s.proxiesMu.Unlock()
+
+	// 先撤销上次登记的端口，避免 portInUse 把自己算作占用
+	p.setRemotePort(0)
+	usable := func(port int) bool {
+		return !tried[port] && s.checkAutoPort(p.session.clientID, port) && !s.portInUse(p.bindAddr, port)
+	}
+
+	if preferred != 0 && usable(preferred) {
+		p.setRemotePort(preferred)
+		return preferred
+	}
+	count := s.autoPortCount()
+	for i := 0; i < count; i++ {
+		idx := (s.nextAutoPort + i) % count
+		if port := s.autoPortAt(idx); usable(port) {
+			s.nextAutoPort = idx + 1
+			p.setRemotePort(port)
+			return port
+		}
+	}
+	return 0
+}
@@ -1,46 +1,143 @@
 package server
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"net"
+	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/chiredeqiudong-dev/go-tunnel-lite/internal/pkg/log"
+	"github.com/chiredeqiudong-dev/go-tunnel-lite/internal/pkg/proto"
 	"github.com/chiredeqiudong-dev/go-tunnel-lite/internal/pkg/proxy"
 )
 
+// CloseReasonServerShutdown 服务端停止或隧道注销导致的连接关闭
+const CloseReasonServerShutdown = "server_shutdown"
+
+// CloseReasonUnhealthy 客户端上报本地服务不健康，用户连接被直接关闭
+const CloseReasonUnhealthy = "unhealthy"
+
+// CloseReasonLocalFailed 客户端通知连接本地服务失败，用户连接被直接关闭
+const CloseReasonLocalFailed = "local_failed"
+
+// dataConnTimeout 等待客户端建立数据连接的超时时间
+const dataConnTimeout = 10 * time.Second
+
+// badGatewayTimeout 向 http 隧道的用户发送 502 页面并读完请求的最长时间
+const badGatewayTimeout = 2 * time.Second
+
+// badGatewayResponse http 隧道无法连接本地服务时返回给用户的响应
+var badGatewayResponse = func() string {
+	body := "<html><head><title>502 Bad Gateway</title></head>" +
+		"<body><h1>502 Bad Gateway</h1><p>go-tunnel-lite: the local service is unavailable.</p></body></html>\n"
+	return "HTTP/1.1 502 Bad Gateway\r\n" +
+		"Content-Type: text/html; charset=utf-8\r\n" +
+		fmt.Sprintf("Content-Length: %d\r\n", len(body)) +
+		"Connection: close\r\n\r\n" + body
+}()
+
 type Proxy struct {
-	name       string
-	remotePort int
-	listener   net.Listener
-	stopCh     chan struct{}
-	mu         sync.Mutex
-	closed     bool
+	name        string
+	remotePort  int
+	bindAddr    string // 公网监听地址，为空表示所有地址
+	group       *Group // 所属负载均衡组，nil 表示独占端口
+	server      *Server
+	session     *ClientSession  // 注册该隧道的客户端会话
+	tunnelType  string          // 隧道类型，为空按 tcp 处理
+	compression string          // 数据连接压缩算法，为空表示不压缩
+	encryption  bool            // 是否加密数据连接
+	ctx         context.Context // 日志上下文（clientID、隧道名）
+	listener    net.Listener
+	stopCh      chan struct{}
+	mu          sync.Mutex
+	closed      bool
+	draining    bool                  // 已停止接受新连接，等待已建立的连接结束
+	healthy     bool                  // 客户端上报的本地服务健康状态，未配置健康检查时始终为 true
+	failures    atomic.Int64          // 客户端连接本地服务失败的次数
+	conns       map[net.Conn]struct{} // 活跃的用户连接，代理停止时关闭
 }
 
-func NewProxy(name string, remotePort int) *Proxy {
+func NewProxy(server *Server, session *ClientSession, name string, remotePort int) *Proxy {
 	return &Proxy{
 		name:       name,
 		remotePort: remotePort,
+		server:     server,
+		session:    session,
+		ctx:        log.WithAttrs(session.ctx, "tunnelName", name),
 		stopCh:     make(chan struct{}),
+		healthy:    true,
+		conns:      make(map[net.Conn]struct{}),
 	}
 }
 
+// errProxyStopped 代理在启动过程中被停止或排空
+var errProxyStopped = errors.New("proxy stopped while starting")
+
+// Start 监听 remotePort 并开始接受用户连接
 func (p *Proxy) Start() error {
-	addr := net.JoinHostPort("0.0.0.0", fmt.Sprintf("%d", p.remotePort))
+	if _, err := p.listen(p.remotePort); err != nil {
+		return err
+	}
+	p.serve()
+	return nil
+}
+
+// listen 监听公网端口，port 为 0 时由系统分配，返回实际监听的端口
+// 监听期间代理已被停止或排空时关闭监听并返回 errProxyStopped
+func (p *Proxy) listen(port int) (int, error) {
+	addr := net.JoinHostPort(p.bindAddr, strconv.Itoa(port))
 	listener, err := net.Listen("tcp", addr)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed || p.draining {
+		listener.Close()
+		return 0, errProxyStopped
+	}
 	p.listener = listener
-	p.mu.Unlock()
+	return listener.Addr().(*net.TCPAddr).Port, nil
+}
+
+// closeListener 关闭尚未开始服务的监听
+func (p *Proxy) closeListener() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.listener != nil {
+		p.listener.Close()
+		p.listener = nil
+	}
+}
 
-	log.Info("代理监听启动", "name", p.name, "port", p.remotePort)
+// setRemotePort 更新公网端口，调用方需持有 server.proxiesMu（portInUse 据此判断端口占用）
+func (p *Proxy) setRemotePort(port int) {
+	p.mu.Lock()
+	p.remotePort = port
+	p.mu.Unlock()
+}
 
+// serve 开始接受用户连接，需在 listen 成功后调用
+// 属于负载均衡组时监听交给组，由组按策略分配连接
+func (p *Proxy) serve() {
+	if p.group != nil {
+		p.mu.Lock()
+		listener := p.listener
+		p.listener = nil
+		p.mu.Unlock()
+		p.group.serve(listener, p.remotePort, p.bindAddr)
+		return
+	}
+	log.InfoContext(p.ctx, "代理监听启动", "addr", p.listener.Addr())
 	go p.acceptLoop()
-	return nil
 }
 
 func (p *Proxy) acceptLoop() {
@@ -54,14 +151,14 @@ func (p *Proxy) acceptLoop() {
 		conn, err := p.listener.Accept()
 		if err != nil {
 			p.mu.Lock()
-			closed := p.closed
+			closed := p.closed || p.draining
 			p.mu.Unlock()
 
 			if closed {
 				return
 			}
 
-			log.Error("接受连接失败", "error", err)
+			log.ErrorContext(p.ctx, "接受连接失败", "error", err)
 			continue
 		}
 
@@ -71,25 +168,221 @@ func (p *Proxy) acceptLoop() {
 
 func (p *Proxy) handleConnection(userConn net.Conn) {
 	defer userConn.Close()
-	log.Debug("新用户连接", "proxy", p.name, "addr", userConn.RemoteAddr())
 
-	dataConn, err := net.Dial("tcp", "127.0.0.1:8080")
-	if err != nil {
-		log.Error("连接数据通道失败", "error", err)
+	proxyID := newProxyID()
+	ctx := log.WithAttrs(p.ctx, "proxyID", proxyID)
+	log.DebugContext(ctx, "新用户连接", "addr", userConn.RemoteAddr())
+
+	if !p.trackConn(userConn) {
+		return // 代理已停止
+	}
+	defer p.untrackConn(userConn)
+
+	record := log.AccessRecord{
+		Tunnel:     p.name,
+		ClientID:   p.session.clientID,
+		RemoteAddr: userConn.RemoteAddr().String(),
+		Start:      time.Now(),
+	}
+	defer func() {
+		record.Duration = time.Since(record.Start)
+		p.server.accessLog.Log(record)
+	}()
+
+	// 本地服务不健康时直接关闭，不必等待客户端拨号失败或数据连接超时
+	if !p.Healthy() {
+		record.CloseReason = CloseReasonUnhealthy
+		p.writeBadGateway(userConn)
+		return
+	}
+
+	// 通知客户端建立数据连接，并等待客户端带着 proxyID 连回来
+	dataConn, reason := p.requestDataConn(ctx, proxyID)
+	if dataConn == nil {
+		record.CloseReason = reason
+		if reason == CloseReasonLocalFailed {
+			p.writeBadGateway(userConn)
+		}
 		return
 	}
 	defer dataConn.Close()
 
+	// 先加密再压缩：写入时先压缩明文，再加密
+	if p.encryption {
+		dataConn = proxy.WrapEncryption(dataConn, p.server.cfg.Server.Token, proxyID, proxy.CryptServer)
+	}
+	dataConn, err := proxy.WrapCompression(dataConn, p.compression)
+	if err != nil {
+		log.ErrorContext(ctx, "包装数据连接失败", "error", err)
+		record.CloseReason = proxy.CloseReasonError
+		return
+	}
+
 	// 使用共享的代理连接
-	proxyConn := proxy.NewProxyConnection(userConn, dataConn, p.name)
+	proxyConn := proxy.NewProxyConnection(ctx, userConn, dataConn)
+	proxyConn.SetIdleTimeout(p.server.cfg.Server.IdleTimeout)
 	defer proxyConn.Close()
 
-	// 使用零拷贝进行双向转发
-	proxyConn.Forward()
-	log.Debug("用户连接关闭", "proxy", p.name, "addr", userConn.RemoteAddr())
+	// 未压缩、未加密时两端都是 TCP 连接，使用零拷贝进行双向转发
+	result := proxyConn.Forward()
+	record.BytesIn = result.LocalToRemote
+	record.BytesOut = result.RemoteToLocal
+	record.CloseReason = p.closeReason(result.Err)
+	record.ForwardMode = result.Mode
+	log.DebugContext(ctx, "用户连接关闭", "addr", userConn.RemoteAddr())
+}
+
+// writeBadGateway http 隧道向用户返回 502 页面，tcp 隧道直接关闭不写入任何数据
+func (p *Proxy) writeBadGateway(userConn net.Conn) {
+	if p.tunnelType != proto.TunnelHTTP {
+		return
+	}
+	userConn.SetDeadline(time.Now().Add(badGatewayTimeout))
+	if _, err := io.WriteString(userConn, badGatewayResponse); err != nil {
+		return
+	}
+	// 半关闭后读完用户已发送的请求，关闭时接收缓冲区有未读数据会发送 RST，用户可能收不到响应
+	if tcpConn, ok := userConn.(*net.TCPConn); ok {
+		tcpConn.CloseWrite()
+	}
+	io.Copy(io.Discard, io.LimitReader(userConn, 64*1024))
+}
+
+// negotiateCompression 返回服务端实际使用的压缩算法，不支持时回退为不压缩并返回 false
+func negotiateCompression(name string) (string, bool) {
+	if !proxy.CompressionSupported(name) {
+		return proto.CompressionNone, false
+	}
+	return name, true
+}
+
+// requestDataConn 发送 NewProxy 并等待客户端的数据连接
+// 失败时返回 nil 和关闭原因
+func (p *Proxy) requestDataConn(ctx context.Context, proxyID string) (net.Conn, string) {
+	ch := p.server.pending.add(proxyID, p.session)
+
+	req := &proto.NewProxyRequest{TunnelName: p.name, ProxyID: proxyID}
+	if err := p.session.conn.WritePayload(proto.TypeNewProxy, req); err != nil {
+		p.server.pending.remove(proxyID, ch)
+		log.ErrorContext(ctx, "发送 NewProxy 失败", "error", err)
+		return nil, proxy.CloseReasonError
+	}
+
+	timer := time.NewTimer(dataConnTimeout)
+	defer timer.Stop()
+
+	select {
+	case result := <-ch:
+		if result.conn == nil {
+			failures := p.failures.Add(1)
+			log.WarnContext(ctx, "客户端建立代理连接失败", "reason", result.reason, "failures", failures)
+			return nil, CloseReasonLocalFailed
+		}
+		log.DebugContext(ctx, "数据连接已建立", "addr", result.conn.RemoteAddr())
+		return result.conn, ""
+	case <-timer.C:
+		log.WarnContext(ctx, "等待数据连接超时")
+		p.server.pending.remove(proxyID, ch)
+		return nil, proxy.CloseReasonTimeout
+	case <-p.stopCh:
+		p.server.pending.remove(proxyID, ch)
+		return nil, CloseReasonServerShutdown
+	}
+}
+
+// closeReason 判断用户连接的关闭原因，代理停止导致的关闭记为 server_shutdown
+func (p *Proxy) closeReason(err error) string {
+	p.mu.Lock()
+	closed := p.closed
+	p.mu.Unlock()
+
+	if closed {
+		return CloseReasonServerShutdown
+	}
+	return proxy.CloseReason(err)
+}
+
+// trackConn 记录活跃连接，代理已停止时返回 false
+func (p *Proxy) trackConn(conn net.Conn) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return false
+	}
+	p.conns[conn] = struct{}{}
+	return true
+}
+
+// untrackConn 移除活跃连接
+func (p *Proxy) untrackConn(conn net.Conn) {
+	p.mu.Lock()
+	delete(p.conns, conn)
+	p.mu.Unlock()
+}
+
+// Drain 停止接受新的用户连接，已建立的连接继续转发直到结束或 Stop
+func (p *Proxy) Drain() {
+	if p.group != nil {
+		p.group.remove(p)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed || p.draining {
+		return
+	}
+	p.draining = true
+
+	if p.listener != nil {
+		p.listener.Close()
+	}
+
+	log.InfoContext(p.ctx, "代理停止接受新连接", "port", p.remotePort, "active", len(p.conns))
+}
+
+// setHealth 更新客户端上报的健康状态
+func (p *Proxy) setHealth(healthy bool, message string) {
+	p.mu.Lock()
+	changed := p.healthy != healthy
+	p.healthy = healthy
+	p.mu.Unlock()
+
+	if !changed {
+		return
+	}
+	if healthy {
+		log.InfoContext(p.ctx, "本地服务恢复健康，恢复接受连接")
+	} else {
+		log.WarnContext(p.ctx, "本地服务不健康，拒绝新连接", "reason", message)
+	}
+}
+
+// Healthy 返回客户端上报的本地服务是否健康
+func (p *Proxy) Healthy() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.healthy
+}
+
+// Failures 返回客户端连接本地服务失败的次数
+func (p *Proxy) Failures() int64 {
+	return p.failures.Load()
+}
+
+// ActiveConns 返回活跃的用户连接数
+func (p *Proxy) ActiveConns() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.conns)
 }
 
 func (p *Proxy) Stop() {
+	if p.group != nil {
+		p.group.remove(p)
+	}
+
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
@@ -104,5 +397,91 @@ func (p *Proxy) Stop() {
 		p.listener.Close()
 	}
 
-	log.Info("代理停止", "name", p.name, "port", p.remotePort)
+	// 关闭活跃的用户连接
+	for conn := range p.conns {
+		conn.Close()
+	}
+
+	log.InfoContext(p.ctx, "代理停止", "port", p.remotePort, "failures", p.failures.Load())
+}
+
+// newProxyID 生成随机的代理连接 ID
+// 数据连接不经过认证，仅凭 proxyID 匹配，因此必须不可预测
+func newProxyID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// pendingResult 客户端对 NewProxy 的答复：数据连接，或 conn 为 nil 时的失败原因
+type pendingResult struct {
+	conn   net.Conn
+	reason string
+}
+
+// pendingConns 等待客户端数据连接的代理请求
+type pendingConns struct {
+	mu    sync.Mutex
+	conns map[string]*pendingConn
+}
+
+// pendingConn 一个等待中的代理请求及发出 NewProxy 的客户端会话
+type pendingConn struct {
+	ch      chan pendingResult
+	session *ClientSession
+}
+
+func newPendingConns() *pendingConns {
+	return &pendingConns{conns: make(map[string]*pendingConn)}
+}
+
+// add 登记等待中的 proxyID，只有 session 可以通知该请求失败
+func (pc *pendingConns) add(proxyID string, session *ClientSession) chan pendingResult {
+	ch := make(chan pendingResult, 1)
+	pc.mu.Lock()
+	pc.conns[proxyID] = &pendingConn{ch: ch, session: session}
+	pc.mu.Unlock()
+	return ch
+}
+
+// remove 取消等待，已送达但未被取走的连接会被关闭
+func (pc *pendingConns) remove(proxyID string, ch chan pendingResult) {
+	pc.mu.Lock()
+	delete(pc.conns, proxyID)
+	pc.mu.Unlock()
+
+	// 删除后不会再有连接送达
+	select {
+	case result := <-ch:
+		if result.conn != nil {
+			result.conn.Close()
+		}
+	default:
+	}
+}
+
+// deliver 将数据连接交给等待中的代理，proxyID 不存在时返回 false
+// 数据连接不经过认证，凭不可预测的 proxyID 匹配
+func (pc *pendingConns) deliver(proxyID string, conn net.Conn) bool {
+	return pc.complete(proxyID, nil, pendingResult{conn: conn})
+}
+
+// fail 通知等待中的代理客户端无法建立连接
+// proxyID 不存在或不是 session 的请求时返回 false，避免其他客户端关闭不属于自己的用户连接
+func (pc *pendingConns) fail(proxyID string, session *ClientSession, reason string) bool {
+	return pc.complete(proxyID, session, pendingResult{reason: reason})
+}
+
+// complete 送达结果，session 不为 nil 时要求与登记的会话一致
+func (pc *pendingConns) complete(proxyID string, session *ClientSession, result pendingResult) bool {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	pending, ok := pc.conns[proxyID]
+	if !ok || (session != nil && pending.session != session) {
+		return false
+	}
+	delete(pc.conns, proxyID)
+	pending.ch <- result
+	return true
 }
@@ -1,8 +1,11 @@
 package server
 
 import (
+	"context"
+	"fmt"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/chiredeqiudong-dev/go-tunnel-lite/internal/pkg/config"
@@ -20,38 +23,44 @@ import (
 */
 
 type Server struct {
-	cfg        *config.ServerConfig      // 服务器配置
-	listener   net.Listener              // TCP 监听器
-	sessions   map[string]*ClientSession // 客户端会话映射
-	sessionsMu sync.RWMutex              // 会话映射的读写锁
-	stopCh     chan struct{}             // 停止信号通道
-	wg         sync.WaitGroup            // 等待所有协程退出
-	proxies    map[string]*Proxy         // 隧道代理映射
-	proxiesMu  sync.RWMutex              // 代理映射的读写锁
-	portSet    map[int]bool              // 端口白名单集合（O(1)查找）
+	cfg          *config.ServerConfig      // 服务器配置
+	listener     net.Listener              // TCP 监听器
+	sessions     map[string]*ClientSession // 客户端会话映射
+	sessionsMu   sync.RWMutex              // 会话映射的读写锁
+	stopCh       chan struct{}             // 停止信号通道
+	stopOnce     sync.Once                 // 保证 Stop 只执行一次
+	wg           sync.WaitGroup            // 等待所有协程退出
+	proxies      map[string]*Proxy         // 隧道代理映射，键见 proxyKey
+	groups       map[string]*Group         // 负载均衡组，由 proxiesMu 保护，已关闭的组在同名组再次注册时替换
+	proxiesMu    sync.RWMutex              // 代理映射的读写锁
+	nextAutoPort int                       // 下次自动分配端口的起始位置，由 proxiesMu 保护
+	accessLog    *log.AccessLogger         // 访问日志，nil 表示未启用
+	pending      *pendingConns             // 等待客户端数据连接的代理请求
+	draining     atomic.Bool               // 排空模式：拒绝新会话和新的公网连接
+	handshakes   *handshakeLimiter         // 未完成认证的连接计数
 }
 
 type ClientSession struct {
-	clientID   string
-	conn       *connect.Connect // 控制连接
-	lastActive time.Time
-	stopCh     chan struct{} // 会话停止信号
-	mu         sync.Mutex
+	clientID     string
+	ctx          context.Context  // 日志上下文（clientID）
+	conn         *connect.Connect // 控制连接
+	version      string           // 客户端协议版本
+	capabilities []string         // 协商后启用的能力
+	lastActive   time.Time
+	stopCh       chan struct{} // 会话停止信号
+	mu           sync.Mutex
 }
 
 // 创建服务端实例
 func NewServer(cfg *config.ServerConfig) *Server {
 	server := &Server{
-		cfg:      cfg,
-		sessions: make(map[string]*ClientSession),
-		stopCh:   make(chan struct{}),
-		proxies:  make(map[string]*Proxy),
-		portSet:  make(map[int]bool),
-	}
-
-	// 初始化端口白名单集合
-	for _, port := range cfg.Server.PublicPorts {
-		server.portSet[port] = true
+		cfg:        cfg,
+		sessions:   make(map[string]*ClientSession),
+		stopCh:     make(chan struct{}),
+		proxies:    make(map[string]*Proxy),
+		groups:     make(map[string]*Group),
+		pending:    newPendingConns(),
+		handshakes: newHandshakeLimiter(cfg.Server.MaxPendingConns, cfg.Server.MaxPendingPerIP),
 	}
 
 	return server
@@ -59,9 +68,19 @@ func NewServer(cfg *config.ServerConfig) *Server {
 
 // 启动服务端
 func (s *Server) Start() error {
+	// 初始化访问日志
+	if s.cfg.AccessLog.Enabled() {
+		accessLog, err := log.NewAccessLogger(s.cfg.AccessLog.Options())
+		if err != nil {
+			return err
+		}
+		s.accessLog = accessLog
+	}
+
 	// 监听控制端口
 	listener, err := net.Listen("tcp", s.cfg.Server.ControlAddr)
 	if err != nil {
+		s.accessLog.Close()
 		return err
 	}
 
@@ -75,8 +94,12 @@ func (s *Server) Start() error {
 	return nil
 }
 
-// Stop 服务端
+// Stop 服务端，立即关闭所有连接
 func (s *Server) Stop() {
+	s.stopOnce.Do(s.stop)
+}
+
+func (s *Server) stop() {
 	log.Info("正在停止服务端...")
 
 	// 发送停止信号
@@ -104,9 +127,104 @@ func (s *Server) Stop() {
 	// 等待所有协程退出
 	s.wg.Wait()
 
+	s.accessLog.Close()
 	log.Info("服务端已停止")
 }
 
+// drainNoticeTimeout 关闭时向每个客户端发送排空通知的最长时间
+const drainNoticeTimeout = 5 * time.Second
+
+// Shutdown 优雅关闭服务端
+// 1. 关闭所有公网监听，拒绝新的客户端会话（控制端口仍接受数据连接）
+// 2. 通知所有客户端服务端即将关闭，以便客户端重新连接（每个通知的写入有期限）
+// 3. 等待已建立的数据流结束，超过 ctx 期限后强制关闭
+func (s *Server) Shutdown(ctx context.Context) error {
+	if !s.draining.CompareAndSwap(false, true) {
+		return nil
+	}
+	log.Info("服务端进入排空模式，等待已建立的连接结束...")
+
+	s.proxiesMu.RLock()
+	for _, proxy := range s.proxies {
+		proxy.Drain()
+	}
+	s.proxiesMu.RUnlock()
+
+	s.notifyDrain(ctx)
+
+	err := s.waitStreams(ctx)
+	if err != nil {
+		log.Warn("等待连接结束超时，强制关闭", "active", s.activeStreams())
+	}
+	s.Stop()
+	return err
+}
+
+// notifyDrain 并发通知所有客户端服务端即将关闭
+// 写入期限取 ctx 期限和 drainNoticeTimeout 中较早者，不读取的客户端不会阻塞关闭流程，发送失败的会话直接关闭
+func (s *Server) notifyDrain(ctx context.Context) {
+	timeout := 0
+	deadline := time.Now().Add(drainNoticeTimeout)
+	if d, ok := ctx.Deadline(); ok {
+		timeout = int(time.Until(d).Seconds())
+		if d.Before(deadline) {
+			deadline = d
+		}
+	}
+
+	s.sessionsMu.RLock()
+	sessions := make([]*ClientSession, 0, len(s.sessions))
+	for _, session := range s.sessions {
+		sessions = append(sessions, session)
+	}
+	s.sessionsMu.RUnlock()
+
+	var wg sync.WaitGroup
+	for _, session := range sessions {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			session.conn.SetWriteDeadLine(deadline)
+			err := sendDrainNotice(session.conn, "服务端即将关闭", timeout)
+			if err != nil {
+				// 写入超时可能留下半个帧，控制连接不能继续使用
+				log.WarnContext(session.ctx, "发送排空通知失败，关闭会话", "error", err)
+				session.Close()
+				return
+			}
+			session.conn.SetWriteDeadLine(time.Time{})
+		}()
+	}
+	wg.Wait()
+}
+
+// waitStreams 等待所有代理的用户连接结束
+func (s *Server) waitStreams(ctx context.Context) error {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for s.activeStreams() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// activeStreams 统计所有代理的活跃用户连接数
+func (s *Server) activeStreams() int {
+	s.proxiesMu.RLock()
+	defer s.proxiesMu.RUnlock()
+
+	total := 0
+	for _, proxy := range s.proxies {
+		total += proxy.ActiveConns()
+	}
+	return total
+}
+
 // 接受客户端连接
 func (s *Server) acceptLoop() {
 	defer s.wg.Done()
@@ -123,32 +241,47 @@ func (s *Server) acceptLoop() {
 			}
 		}
 
+		// 限制未完成认证的连接数，防止慢速连接耗尽资源
+		ip := remoteIP(connect.RemoteAddr())
+		if !s.handshakes.acquire(ip) {
+			log.Debug("未认证连接过多，拒绝新连接", "remoteAddr", connect.RemoteAddr())
+			connect.Close()
+			continue
+		}
+
 		// 启动协程处理新连接
 		s.wg.Add(1)
-		go s.handleNewConnection(connect)
+		go s.handleNewConnection(connect, ip)
 
 	}
 }
 
 // 处理新的客户端连接
-func (s *Server) handleNewConnection(rawConn net.Conn) {
+func (s *Server) handleNewConnection(rawConn net.Conn, ip string) {
 	defer s.wg.Done()
 
 	connect := connect.WrapConnect(rawConn)
 	remoteAddr := connect.RemoteAddr().String()
-	log.Info("新连接", "remoteAddr", remoteAddr)
+	log.Debug("新连接", "remoteAddr", remoteAddr)
 
-	// 设置认证超时
-	connect.SetDeadline(time.Now().Add(10 * time.Second))
+	// 设置认证超时：整个握手必须在期限内完成
+	connect.SetDeadline(time.Now().Add(s.authTimeout()))
 
-	// 等待认证消息
-	msg, err := connect.ReadMessage()
+	// 等待认证消息，认证前只接受 Auth 和 ProxyReady，且消息体很小
+	msg, err := connect.ReadMessageLimit(proto.MaxPreAuthDataLen, proto.TypeAuth, proto.TypeProxyReady)
+	s.handshakes.release(ip) // 收到完整的首条消息后释放未认证连接名额
 	if err != nil {
 		log.Warn("读取认证消息失败", "remoteAddr", remoteAddr, "error", err)
 		connect.Close()
 		return
 	}
 
+	// 数据连接：首条消息为 ProxyReady
+	if msg.Type == proto.TypeProxyReady {
+		s.handleProxyReady(connect, msg)
+		return
+	}
+
 	// 验证消息类型
 	if msg.Type != proto.TypeAuth {
 		log.Warn("期望认证消息，收到", "type", msg.Type, "remoteAddr", remoteAddr)
@@ -160,7 +293,15 @@ func (s *Server) handleNewConnection(rawConn net.Conn) {
 	authReq, err := proto.Decode[proto.AuthRequest](msg.Data)
 	if err != nil {
 		log.Warn("解析认证消息失败", "remoteAddr", remoteAddr, "error", err)
-		s.sendAuthResponse(connect, false, "认证消息格式错误")
+		s.sendAuthResponse(connect, false, "认证消息格式错误", nil)
+		connect.Close()
+		return
+	}
+
+	// 排空模式下不再接受新会话
+	if s.draining.Load() {
+		log.Info("服务端正在关闭，拒绝新会话", "remoteAddr", remoteAddr, "clientID", authReq.ClientID)
+		s.sendAuthResponse(connect, false, "服务端正在关闭", nil)
 		connect.Close()
 		return
 	}
@@ -168,33 +309,57 @@ func (s *Server) handleNewConnection(rawConn net.Conn) {
 	// 验证 Token
 	if authReq.Token != s.cfg.Server.Token {
 		log.Warn("Token 验证失败", "remoteAddr", remoteAddr, "clientID", authReq.ClientID)
-		s.sendAuthResponse(connect, false, "Token 错误")
+		s.sendAuthResponse(connect, false, "Token 错误", nil)
+		connect.Close()
+		return
+	}
+
+	// 检查协议版本并协商能力
+	if err := proto.CheckVersion(authReq.Version); err != nil {
+		log.Warn("客户端协议版本不兼容", "remoteAddr", remoteAddr, "clientID", authReq.ClientID, "version", authReq.Version)
+		s.sendAuthResponse(connect, false, err.Error(), nil)
 		connect.Close()
 		return
 	}
+	capabilities := proto.NegotiateCapabilities(proto.Capabilities(), clientCapabilities(authReq))
 
 	// 检查是否已存在相同 clientID 的会话
 	s.sessionsMu.Lock()
-	if oldSession, exists := s.sessions[authReq.ClientID]; exists {
+	oldSession, exists := s.sessions[authReq.ClientID]
+	if exists {
 		log.Warn("客户端重复连接，关闭旧连接", "clientID", authReq.ClientID)
 		oldSession.Close()
 		delete(s.sessions, authReq.ClientID)
 	}
 	s.sessionsMu.Unlock()
 
+	// 同步清理旧会话的代理，使重连的客户端可以立即重新注册同名隧道
+	if oldSession != nil {
+		s.removeSessionProxies(oldSession)
+	}
+
 	// 清除超时设置
 	connect.SetDeadline(time.Time{})
 
 	// 发送认证成功响应
-	s.sendAuthResponse(connect, true, "认证成功")
-	log.Info("客户端认证成功", "clientID", authReq.ClientID, "remoteAddr", remoteAddr)
+	// 认证响应仍使用二进制编码，之后的消息使用协商的编码
+	s.sendAuthResponse(connect, true, "认证成功", capabilities)
+	connect.SetEncoding(proto.NegotiateEncoding(capabilities))
+	if proto.HasCapability(capabilities, proto.CapFrameV2) {
+		connect.SetFrameFlags(proto.FlagChecksum)
+	}
+	log.Info("客户端认证成功", "clientID", authReq.ClientID, "remoteAddr", remoteAddr,
+		"version", authReq.Version, "capabilities", capabilities)
 
 	// 创建会话
 	session := &ClientSession{
-		clientID:   authReq.ClientID,
-		conn:       connect,
-		lastActive: time.Now(),
-		stopCh:     make(chan struct{}),
+		clientID:     authReq.ClientID,
+		ctx:          log.WithAttrs(context.Background(), "clientID", authReq.ClientID),
+		conn:         connect,
+		version:      authReq.Version,
+		capabilities: capabilities,
+		lastActive:   time.Now(),
+		stopCh:       make(chan struct{}),
 	}
 
 	// 注册会话
@@ -211,9 +376,32 @@ func (s *Server) handleNewConnection(rawConn net.Conn) {
 		delete(s.sessions, authReq.ClientID)
 	}
 	s.sessionsMu.Unlock()
+
+	// 停止该会话注册的所有代理
+	s.removeSessionProxies(session)
 	log.Info("客户端断开", "clientID", authReq.ClientID)
 }
 
+// handleProxyReady 将客户端的数据连接交给等待中的代理
+func (s *Server) handleProxyReady(conn *connect.Connect, msg *proto.Message) {
+	req, err := proto.Decode[proto.ProxyReadyRequest](msg.Data)
+	if err != nil {
+		log.Warn("解析 ProxyReady 消息失败", "remoteAddr", conn.RemoteAddr(), "error", err)
+		conn.Close()
+		return
+	}
+
+	// 清除认证超时，之后由转发逻辑管理连接
+	conn.SetDeadline(time.Time{})
+
+	if !s.pending.deliver(req.ProxyID, conn.RawConn()) {
+		log.Warn("未知或已超时的 proxyID", "proxyID", req.ProxyID, "remoteAddr", conn.RemoteAddr())
+		conn.Close()
+		return
+	}
+	log.Debug("收到数据连接", "proxyID", req.ProxyID, "remoteAddr", conn.RemoteAddr())
+}
+
 // 处理客户端会话（消息循环）
 func (s *Server) handleSession(session *ClientSession) {
 	// 启动心跳检测
@@ -232,12 +420,12 @@ func (s *Server) handleSession(session *ClientSession) {
 		// 设置读取超时（比心跳超时稍长）
 		session.conn.SetReadDeadLine(time.Now().Add(s.cfg.Server.HeartbeatTimeout + 5*time.Second))
 
-		msg, err := session.conn.ReadMessage()
+		msg, err := session.conn.ReadFrame()
 		if err != nil {
 			if session.IsClosed() {
 				return
 			}
-			log.Warn("读取消息失败", "clientID", session.clientID, "error", err)
+			log.WarnContext(session.ctx, "读取消息失败", "error", err)
 			session.Close()
 			return
 		}
@@ -247,8 +435,9 @@ func (s *Server) handleSession(session *ClientSession) {
 		session.lastActive = time.Now()
 		session.mu.Unlock()
 
-		// 处理消息
+		// 处理消息，消息体在处理完成后归还内存池
 		s.handleMessage(session, msg)
+		connect.Release(msg)
 	}
 }
 
@@ -259,75 +448,304 @@ func (s *Server) handleMessage(session *ClientSession, msg *proto.Message) {
 		// 响应心跳
 		pong := &proto.Message{Type: proto.TypePong}
 		if err := session.conn.WriteMessage(pong); err != nil {
-			log.Warn("发送 Pong 失败", "clientID", session.clientID, "error", err)
+			log.WarnContext(session.ctx, "发送 Pong 失败", "error", err)
 		}
 
 	case proto.TypePong:
 		// 收到 Pong，更新活跃时间（已在上面更新）
-		log.Debug("收到 Pong", "clientID", session.clientID)
+		log.DebugContext(session.ctx, "收到 Pong")
 
 	case proto.TypeRegisterTunnel:
 		// 处理隧道注册请求
 		s.handleRegisterTunnel(session, msg)
 
+	case proto.TypeUnregisterTunnel:
+		// 处理隧道注销请求
+		s.handleUnregisterTunnel(session, msg)
+
+	case proto.TypeProxyFailed:
+		// 客户端无法建立代理连接，立即关闭等待中的用户连接
+		s.handleProxyFailed(session, msg)
+
+	case proto.TypeTunnelHealth:
+		// 客户端上报本地服务健康状态
+		s.handleTunnelHealth(session, msg)
+
+	case proto.TypeDrain:
+		// 客户端即将关闭：停止接受该客户端隧道的新连接，已建立的连接继续转发
+		log.InfoContext(session.ctx, "客户端进入排空模式")
+		s.proxiesMu.RLock()
+		for _, proxy := range s.proxies {
+			if proxy.session == session {
+				proxy.Drain()
+			}
+		}
+		s.proxiesMu.RUnlock()
+
 	default:
-		log.Warn("未知消息类型", "type", msg.Type, "clientID", session.clientID)
+		log.WarnContext(session.ctx, "未知消息类型", "type", msg.Type)
 	}
 }
 
 // handleRegisterTunnel 处理隧道注册请求
 func (s *Server) handleRegisterTunnel(session *ClientSession, msg *proto.Message) {
 	// 解码请求
-	req, err := proto.Decode[proto.RegisterTunnelRequest](msg.Data)
+	req, err := proto.DecodeAs[proto.RegisterTunnelRequest](session.conn.Encoding(), msg.Data)
 	if err != nil {
-		log.Error("解码隧道注册请求失败", "clientID", session.clientID, "error", err)
-		s.sendRegisterTunnelResponse(session, false, "请求格式错误", 0)
+		log.ErrorContext(session.ctx, "解码隧道注册请求失败", "error", err)
+		s.sendRegisterTunnelResponse(session, false, "请求格式错误", "", 0)
 		return
 	}
 
-	log.Info("收到隧道注册请求", "clientID", session.clientID, "tunnelName", req.Tunnel.Name, "remotePort", req.Tunnel.RemotePort)
+	log.InfoContext(session.ctx, "收到隧道注册请求", "tunnelName", req.Tunnel.Name, "remotePort", req.Tunnel.RemotePort)
+
+	// 验证端口策略，端口为 0 时由服务端分配
+	autoPort := req.Tunnel.RemotePort == 0
+	if !autoPort {
+		if reason := s.checkPort(session.clientID, req.Tunnel.RemotePort, true); reason != "" {
+			log.WarnContext(session.ctx, "端口不允许使用", "remotePort", req.Tunnel.RemotePort, "reason", reason)
+			s.sendRegisterTunnelResponse(session, false, reason, req.Tunnel.Name, 0)
+			return
+		}
+	}
+
+	if err := config.ValidateStrategy(req.Tunnel.Strategy); err != nil {
+		s.sendRegisterTunnelResponse(session, false, "不支持的负载均衡策略", req.Tunnel.Name, 0)
+		return
+	}
+	switch req.Tunnel.Type {
+	case "", proto.TunnelTCP, proto.TunnelHTTP:
+	default:
+		s.sendRegisterTunnelResponse(session, false, fmt.Sprintf("不支持的隧道类型 %q", req.Tunnel.Type), req.Tunnel.Name, 0)
+		return
+	}
 
-	// 验证端口是否在白名单中
-	if !s.isPortAllowed(req.Tunnel.RemotePort) {
-		log.Warn("端口不在白名单中", "clientID", session.clientID, "remotePort", req.Tunnel.RemotePort)
-		s.sendRegisterTunnelResponse(session, false, "端口不允许使用", 0)
+	bindAddr, reason := s.resolveBindAddr(req.Tunnel.BindAddr)
+	if reason != "" {
+		log.WarnContext(session.ctx, "监听地址不允许使用", "bindAddr", req.Tunnel.BindAddr)
+		s.sendRegisterTunnelResponse(session, false, reason, req.Tunnel.Name, 0)
 		return
 	}
 
-	// 创建并启动代理
-	proxy := NewProxy(req.Tunnel.Name, req.Tunnel.RemotePort)
-	if err := proxy.Start(); err != nil {
-		log.Error("启动代理失败", "tunnelName", req.Tunnel.Name, "error", err)
-		s.sendRegisterTunnelResponse(session, false, "启动代理失败", 0)
+	if s.draining.Load() {
+		s.sendRegisterTunnelResponse(session, false, "服务端正在关闭", req.Tunnel.Name, 0)
 		return
 	}
 
-	// 注册代理
+	// 服务端不支持的压缩算法回退为不压缩，由响应告知客户端
+	compression, ok := negotiateCompression(req.Tunnel.Compression)
+	if !ok {
+		log.WarnContext(session.ctx, "不支持的压缩算法，数据连接不压缩", "tunnelName", req.Tunnel.Name, "compression", req.Tunnel.Compression)
+	}
+
+	proxy := NewProxy(s, session, req.Tunnel.Name, req.Tunnel.RemotePort)
+	proxy.tunnelType = req.Tunnel.Type
+	proxy.compression = compression
+	proxy.encryption = req.Tunnel.Encryption
+	proxy.bindAddr = bindAddr
+
+	// 在锁内登记名称和端口，监听和发送响应在锁外进行，避免一个客户端阻塞其他会话
+	key := proxyKey(&req.Tunnel, session.clientID)
 	s.proxiesMu.Lock()
-	s.proxies[req.Tunnel.Name] = proxy
+	joined, reason := s.reserveTunnel(key, proxy, &req.Tunnel)
 	s.proxiesMu.Unlock()
+	if reason != "" {
+		log.WarnContext(session.ctx, "隧道登记失败", "tunnelName", req.Tunnel.Name, "reason", reason)
+		s.sendRegisterTunnelResponse(session, false, reason, req.Tunnel.Name, 0)
+		return
+	}
+
+	// 加入已有负载均衡组的成员共享组的监听，不需要启动
+	if !joined {
+		if autoPort {
+			err = s.startAutoPort(proxy, req.Tunnel.PreferredPort)
+		} else {
+			err = proxy.Start()
+		}
+		if err != nil {
+			s.releaseTunnel(key, proxy)
+			reason := fmt.Sprintf("端口 %d 监听失败（可能被其他程序占用）", req.Tunnel.RemotePort)
+			switch {
+			case err == errNoFreePort:
+				reason = "没有可分配的端口"
+			case err == errProxyStopped:
+				reason = "隧道在启动过程中被注销"
+			case autoPort:
+				reason = "分配端口失败"
+			}
+			log.WarnContext(session.ctx, "启动代理失败", "tunnelName", req.Tunnel.Name, "reason", reason, "error", err)
+			s.sendRegisterTunnelResponse(session, false, reason, req.Tunnel.Name, 0)
+			return
+		}
+	}
 
-	s.sendRegisterTunnelResponse(session, true, "注册成功", req.Tunnel.RemotePort)
-	log.Info("隧道注册成功", "clientID", session.clientID, "tunnelName", req.Tunnel.Name, "remotePort", req.Tunnel.RemotePort)
+	s.sendTunnelResponse(session, &proto.RegisterTunnelResponse{
+		Success:     true,
+		Message:     "注册成功",
+		TunnelName:  req.Tunnel.Name,
+		RemotePort:  proxy.remotePort,
+		Compression: compression,
+		Encryption:  req.Tunnel.Encryption,
+	})
+	log.InfoContext(session.ctx, "隧道注册成功", "tunnelName", req.Tunnel.Name, "remotePort", proxy.remotePort, "bindAddr", bindAddr,
+		"compression", compression, "encryption", req.Tunnel.Encryption)
 }
 
-// isPortAllowed 检查端口是否在白名单中
-// 如果 public_ports 为空，则允许所有端口
-func (s *Server) isPortAllowed(port int) bool {
-	if len(s.portSet) == 0 {
-		return true // 空白名单允许所有端口
+// reserveTunnel 登记隧道名称和端口，返回是否加入了已有的负载均衡组及拒绝原因
+// 登记后的代理尚未监听，其他注册请求已能看到名称和端口被占用；调用方需持有 proxiesMu
+func (s *Server) reserveTunnel(key string, p *Proxy, tunnel *proto.TunnelConfig) (joined bool, reason string) {
+	if _, exists := s.proxies[key]; exists {
+		return false, "隧道名称已被使用"
+	}
+
+	if g := s.groups[tunnel.Group]; g != nil && !g.isClosed() {
+		// 加入已有的负载均衡组，共享组的端口和监听
+		if reason := s.joinGroup(g, p, tunnel); reason != "" {
+			return false, reason
+		}
+		joined = true
+	} else {
+		if tunnel.RemotePort != 0 && s.portInUse(p.bindAddr, tunnel.RemotePort) {
+			return false, fmt.Sprintf("端口 %d 已被其他隧道使用", tunnel.RemotePort)
+		}
+		if tunnel.Group != "" {
+			// 第一个成员创建组，监听启动后交给组
+			p.group = newGroup(tunnel.Group, tunnel.Strategy)
+			p.group.add(p, tunnel.Weight)
+			s.groups[tunnel.Group] = p.group
+		}
+	}
+
+	s.proxies[key] = p
+	return joined, ""
+}
+
+// releaseTunnel 撤销启动失败的隧道登记并停止代理
+// 第一个成员启动失败时组随之关闭，同名组再次注册时替换
+func (s *Server) releaseTunnel(key string, p *Proxy) {
+	s.proxiesMu.Lock()
+	if s.proxies[key] == p {
+		delete(s.proxies, key)
+	}
+	s.proxiesMu.Unlock()
+
+	p.Stop()
+}
+
+// handleUnregisterTunnel 处理隧道注销请求
+// 只允许注销本会话注册的隧道
+func (s *Server) handleUnregisterTunnel(session *ClientSession, msg *proto.Message) {
+	req, err := proto.DecodeAs[proto.UnregisterTunnelRequest](session.conn.Encoding(), msg.Data)
+	if err != nil {
+		log.ErrorContext(session.ctx, "解码隧道注销请求失败", "error", err)
+		return
+	}
+
+	s.proxiesMu.Lock()
+	key, proxy := s.findSessionProxy(session, req.TunnelName)
+	if proxy == nil {
+		s.proxiesMu.Unlock()
+		log.WarnContext(session.ctx, "注销的隧道不存在或不属于该客户端", "tunnelName", req.TunnelName)
+		return
+	}
+	delete(s.proxies, key)
+	s.proxiesMu.Unlock()
+
+	proxy.Stop()
+	log.InfoContext(session.ctx, "隧道注销成功", "tunnelName", req.TunnelName)
+}
+
+// handleProxyFailed 将失败原因交给等待数据连接的代理，只接受本会话发出的 NewProxy 对应的通知
+func (s *Server) handleProxyFailed(session *ClientSession, msg *proto.Message) {
+	req, err := proto.DecodeAs[proto.ProxyFailed](session.conn.Encoding(), msg.Data)
+	if err != nil {
+		log.ErrorContext(session.ctx, "解码代理失败通知失败", "error", err)
+		return
+	}
+	if !s.pending.fail(req.ProxyID, session, req.Reason) {
+		log.DebugContext(session.ctx, "代理失败通知对应的连接已不在等待或不属于该客户端", "proxyID", req.ProxyID)
+	}
+}
+
+// handleTunnelHealth 更新隧道的健康状态，不健康的隧道直接拒绝新的用户连接
+func (s *Server) handleTunnelHealth(session *ClientSession, msg *proto.Message) {
+	req, err := proto.DecodeAs[proto.TunnelHealth](session.conn.Encoding(), msg.Data)
+	if err != nil {
+		log.ErrorContext(session.ctx, "解码健康状态失败", "error", err)
+		return
+	}
+
+	s.proxiesMu.RLock()
+	_, proxy := s.findSessionProxy(session, req.TunnelName)
+	s.proxiesMu.RUnlock()
+	if proxy == nil {
+		log.WarnContext(session.ctx, "上报健康状态的隧道不存在", "tunnelName", req.TunnelName)
+		return
+	}
+	proxy.setHealth(req.Healthy, req.Message)
+}
+
+// proxyKey 返回代理在 proxies 中的键
+// 普通隧道按名称全局唯一；负载均衡组成员按组和客户端区分，不同客户端可以使用相同的隧道名称
+func proxyKey(tunnel *proto.TunnelConfig, clientID string) string {
+	if tunnel.Group == "" {
+		return tunnel.Name
+	}
+	return tunnel.Group + "/" + clientID + "/" + tunnel.Name
+}
+
+// findSessionProxy 查找会话注册的指定名称的隧道，调用方需持有 proxiesMu
+func (s *Server) findSessionProxy(session *ClientSession, name string) (string, *Proxy) {
+	for key, proxy := range s.proxies {
+		if proxy.session == session && proxy.name == name {
+			return key, proxy
+		}
+	}
+	return "", nil
+}
+
+// removeSessionProxies 停止并移除会话注册的所有代理
+// 排空模式下代理只停止接受新连接，已建立的连接由 Shutdown 统一等待或强制关闭
+func (s *Server) removeSessionProxies(session *ClientSession) {
+	if s.draining.Load() {
+		s.proxiesMu.RLock()
+		for _, proxy := range s.proxies {
+			if proxy.session == session {
+				proxy.Drain()
+			}
+		}
+		s.proxiesMu.RUnlock()
+		return
+	}
+
+	s.proxiesMu.Lock()
+	var removed []*Proxy
+	for name, proxy := range s.proxies {
+		if proxy.session == session {
+			removed = append(removed, proxy)
+			delete(s.proxies, name)
+		}
+	}
+	s.proxiesMu.Unlock()
+
+	for _, proxy := range removed {
+		proxy.Stop()
 	}
-	return s.portSet[port] // O(1) 查找
 }
 
 // sendRegisterTunnelResponse 发送隧道注册响应
-func (s *Server) sendRegisterTunnelResponse(session *ClientSession, success bool, message string, remotePort int) {
-	resp := &proto.RegisterTunnelResponse{
+func (s *Server) sendRegisterTunnelResponse(session *ClientSession, success bool, message string, tunnelName string, remotePort int) {
+	s.sendTunnelResponse(session, &proto.RegisterTunnelResponse{
 		Success:    success,
 		Message:    message,
+		TunnelName: tunnelName,
 		RemotePort: remotePort,
-	}
-	data, err := proto.Encode(resp)
+	})
+}
+
+// sendTunnelResponse 发送完整的隧道注册响应
+func (s *Server) sendTunnelResponse(session *ClientSession, resp *proto.RegisterTunnelResponse) {
+	data, err := proto.EncodeAs(session.conn.Encoding(), resp)
 	if err != nil {
 		log.Error("编码隧道注册响应失败", "error", err)
 		return
@@ -357,7 +775,7 @@ func (s *Server) heartbeatLoop(session *ClientSession) {
 			session.mu.Unlock()
 
 			if time.Since(lastActive) > s.cfg.Server.HeartbeatTimeout {
-				log.Warn("客户端心跳超时", "clientID", session.clientID)
+				log.WarnContext(session.ctx, "客户端心跳超时")
 				session.Close()
 				return
 			}
@@ -365,7 +783,7 @@ func (s *Server) heartbeatLoop(session *ClientSession) {
 			// 发送 Ping
 			ping := &proto.Message{Type: proto.TypePing}
 			if err := session.conn.WriteMessage(ping); err != nil {
-				log.Warn("发送 Ping 失败", "clientID", session.clientID, "error", err)
+				log.WarnContext(session.ctx, "发送 Ping 失败", "error", err)
 				session.Close()
 				return
 			}
@@ -373,11 +791,30 @@ func (s *Server) heartbeatLoop(session *ClientSession) {
 	}
 }
 
+// sendDrainNotice 通知对端本端即将关闭
+func sendDrainNotice(conn *connect.Connect, message string, timeout int) error {
+	data, err := proto.EncodeAs(conn.Encoding(), &proto.DrainNotice{Message: message, Timeout: timeout})
+	if err != nil {
+		return err
+	}
+	return conn.WriteMessage(&proto.Message{Type: proto.TypeDrain, Data: data})
+}
+
+// clientCapabilities 返回客户端声明的能力，旧版本客户端不声明时仅支持二进制编码
+func clientCapabilities(req *proto.AuthRequest) []string {
+	if req.Capabilities == nil {
+		return []string{proto.CapBinary}
+	}
+	return req.Capabilities
+}
+
 // 发送认证响应
-func (s *Server) sendAuthResponse(conn *connect.Connect, success bool, message string) {
+func (s *Server) sendAuthResponse(conn *connect.Connect, success bool, message string, capabilities []string) {
 	resp := &proto.AuthResponse{
-		Success: success,
-		Message: message,
+		Success:      success,
+		Message:      message,
+		Version:      proto.ProtocolVersion,
+		Capabilities: capabilities,
 	}
 	data, err := proto.Encode(resp)
 	if err != nil {
@@ -406,6 +843,11 @@ func (cs *ClientSession) Close() {
 	cs.conn.Close()
 }
 
+// HasCapability 判断会话是否启用了指定能力
+func (cs *ClientSession) HasCapability(name string) bool {
+	return proto.HasCapability(cs.capabilities, name)
+}
+
 // 检查会话是否已关闭
 func (cs *ClientSession) IsClosed() bool {
 	select {
@@ -415,3 +857,68 @@ func (cs *ClientSession) IsClosed() bool {
 		return false
 	}
 }
+
+// authTimeout 返回新连接发送首条消息的最长时间
+func (s *Server) authTimeout() time.Duration {
+	if s.cfg.Server.AuthTimeout > 0 {
+		return s.cfg.Server.AuthTimeout
+	}
+	return 10 * time.Second
+}
+
+// remoteIP 返回连接的对端 IP，用于按 IP 限制未认证连接
+func remoteIP(addr net.Addr) string {
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		return tcpAddr.IP.String()
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// handshakeLimiter 限制未完成认证的连接数，上限为 0 表示不限制
+type handshakeLimiter struct {
+	mu       sync.Mutex
+	total    int
+	perIP    map[string]int
+	maxTotal int
+	maxPerIP int
+}
+
+func newHandshakeLimiter(maxTotal, maxPerIP int) *handshakeLimiter {
+	return &handshakeLimiter{
+		perIP:    make(map[string]int),
+		maxTotal: maxTotal,
+		maxPerIP: maxPerIP,
+	}
+}
+
+// acquire 占用一个名额，超过上限时返回 false
+func (l *handshakeLimiter) acquire(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.maxTotal > 0 && l.total >= l.maxTotal {
+		return false
+	}
+	if l.maxPerIP > 0 && l.perIP[ip] >= l.maxPerIP {
+		return false
+	}
+	l.total++
+	l.perIP[ip]++
+	return true
+}
+
+// release 释放一个名额
+func (l *handshakeLimiter) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.total--
+	l.perIP[ip]--
+	if l.perIP[ip] <= 0 {
+		delete(l.perIP, ip)
+	}
+}
@@ -1,14 +1,25 @@
 package server
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/chiredeqiudong-dev/go-tunnel-lite/internal/pkg/config"
 	"github.com/chiredeqiudong-dev/go-tunnel-lite/internal/pkg/connect"
+	"github.com/chiredeqiudong-dev/go-tunnel-lite/internal/pkg/log"
 	"github.com/chiredeqiudong-dev/go-tunnel-lite/internal/pkg/proto"
+	"github.com/chiredeqiudong-dev/go-tunnel-lite/internal/pkg/proxy"
 )
 
 // 创建测试配置
@@ -279,3 +290,1381 @@ func TestDuplicateClient(t *testing.T) {
 
 	t.Log("重复客户端处理正确：旧连接已关闭，新连接已建立")
 }
+
+// authTestClient 连接服务端并完成认证
+func authTestClient(t *testing.T, addr, clientID string) *connect.Connect {
+	t.Helper()
+
+	rawConn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("连接服务端失败: %v", err)
+	}
+	conn := connect.WrapConnect(rawConn)
+
+	data, _ := proto.Encode(&proto.AuthRequest{ClientID: clientID, Token: "test-token"})
+	if err := conn.WriteMessage(&proto.Message{Type: proto.TypeAuth, Data: data}); err != nil {
+		t.Fatalf("发送认证消息失败: %v", err)
+	}
+	if _, err := conn.ReadMessage(); err != nil {
+		t.Fatalf("读取认证响应失败: %v", err)
+	}
+	return conn
+}
+
+// registerTestTunnel 注册隧道并返回响应
+func registerTestTunnel(t *testing.T, conn *connect.Connect, name string, port int) *proto.RegisterTunnelResponse {
+	t.Helper()
+
+	data, _ := proto.Encode(&proto.RegisterTunnelRequest{
+		Tunnel: proto.TunnelConfig{Name: name, Type: "tcp", RemotePort: port},
+	})
+	if err := conn.WriteMessage(&proto.Message{Type: proto.TypeRegisterTunnel, Data: data}); err != nil {
+		t.Fatalf("发送注册请求失败: %v", err)
+	}
+	return readTestTunnelResponse(t, conn)
+}
+
+// readTestTunnelResponse 读取隧道注册响应，跳过心跳
+func readTestTunnelResponse(t *testing.T, conn *connect.Connect) *proto.RegisterTunnelResponse {
+	t.Helper()
+
+	for {
+		msg, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("读取注册响应失败: %v", err)
+		}
+		if msg.Type != proto.TypeRegisterTunnelResp {
+			continue // 跳过心跳
+		}
+		resp, err := proto.Decode[proto.RegisterTunnelResponse](msg.Data)
+		if err != nil {
+			t.Fatalf("解析注册响应失败: %v", err)
+		}
+		return resp
+	}
+}
+
+// TestUnregisterTunnel 测试隧道注销
+func TestUnregisterTunnel(t *testing.T) {
+	cfg := newTestServerConfig(17005)
+
+	s := NewServer(cfg)
+	if err := s.Start(); err != nil {
+		t.Fatalf("启动服务端失败: %v", err)
+	}
+	defer s.Stop()
+
+	owner := authTestClient(t, "127.0.0.1:17005", "owner-client")
+	defer owner.Close()
+	other := authTestClient(t, "127.0.0.1:17005", "other-client")
+	defer other.Close()
+
+	if resp := registerTestTunnel(t, owner, "web", 18005); !resp.Success {
+		t.Fatalf("注册隧道失败: %s", resp.Message)
+	}
+
+	// 同名隧道不能重复注册
+	if resp := registerTestTunnel(t, other, "web", 18006); resp.Success {
+		t.Fatal("同名隧道不应注册成功")
+	}
+
+	unregister := func(conn *connect.Connect) {
+		data, _ := proto.Encode(&proto.UnregisterTunnelRequest{TunnelName: "web"})
+		if err := conn.WriteMessage(&proto.Message{Type: proto.TypeUnregisterTunnel, Data: data}); err != nil {
+			t.Fatalf("发送注销请求失败: %v", err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	// 其他客户端不能注销不属于自己的隧道
+	unregister(other)
+	s.proxiesMu.RLock()
+	_, exists := s.proxies["web"]
+	s.proxiesMu.RUnlock()
+	if !exists {
+		t.Fatal("隧道不应被其他客户端注销")
+	}
+
+	unregister(owner)
+	s.proxiesMu.RLock()
+	_, exists = s.proxies["web"]
+	s.proxiesMu.RUnlock()
+	if exists {
+		t.Fatal("隧道应已注销")
+	}
+
+	if _, err := net.DialTimeout("tcp", "127.0.0.1:18005", time.Second); err == nil {
+		t.Fatal("隧道注销后公共端口仍可连接")
+	}
+}
+
+// TestSessionCloseRemovesProxies 测试会话断开后清理其注册的代理
+func TestSessionCloseRemovesProxies(t *testing.T) {
+	cfg := newTestServerConfig(17006)
+
+	s := NewServer(cfg)
+	if err := s.Start(); err != nil {
+		t.Fatalf("启动服务端失败: %v", err)
+	}
+	defer s.Stop()
+
+	conn := authTestClient(t, "127.0.0.1:17006", "closing-client")
+	if resp := registerTestTunnel(t, conn, "ssh", 18007); !resp.Success {
+		t.Fatalf("注册隧道失败: %s", resp.Message)
+	}
+
+	conn.Close()
+	time.Sleep(200 * time.Millisecond)
+
+	s.proxiesMu.RLock()
+	count := len(s.proxies)
+	s.proxiesMu.RUnlock()
+	if count != 0 {
+		t.Fatalf("会话断开后仍有 %d 个代理", count)
+	}
+}
+
+// serveTestDataConn 模拟客户端：收到 NewProxy 后建立数据连接，回显 n 字节后关闭
+func serveTestDataConn(t *testing.T, conn *connect.Connect, addr string, n int) <-chan string {
+	t.Helper()
+
+	proxyIDs := make(chan string, 1)
+	go func() {
+		for {
+			msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if msg.Type != proto.TypeNewProxy {
+				continue
+			}
+			req, err := proto.Decode[proto.NewProxyRequest](msg.Data)
+			if err != nil {
+				return
+			}
+			proxyIDs <- req.ProxyID
+
+			rawConn, err := net.Dial("tcp", addr)
+			if err != nil {
+				return
+			}
+			dataConn := connect.WrapConnect(rawConn)
+			data, _ := proto.Encode(&proto.ProxyReadyRequest{ProxyID: req.ProxyID})
+			dataConn.WriteMessage(&proto.Message{Type: proto.TypeProxyReady, Data: data})
+
+			buf := make([]byte, n)
+			if _, err := io.ReadFull(rawConn, buf); err == nil {
+				rawConn.Write(buf)
+			}
+			rawConn.Close()
+			return
+		}
+	}()
+	return proxyIDs
+}
+
+// TestProxyDataConn 测试公网连接经由客户端数据连接转发
+func TestProxyDataConn(t *testing.T) {
+	cfg := newTestServerConfig(17008)
+
+	s := NewServer(cfg)
+	if err := s.Start(); err != nil {
+		t.Fatalf("启动服务端失败: %v", err)
+	}
+	defer s.Stop()
+
+	conn := authTestClient(t, "127.0.0.1:17008", "data-client")
+	defer conn.Close()
+	if resp := registerTestTunnel(t, conn, "echo", 18009); !resp.Success {
+		t.Fatalf("注册隧道失败: %s", resp.Message)
+	}
+	proxyIDs := serveTestDataConn(t, conn, "127.0.0.1:17008", 5)
+
+	userConn, err := net.Dial("tcp", "127.0.0.1:18009")
+	if err != nil {
+		t.Fatalf("连接公共端口失败: %v", err)
+	}
+	defer userConn.Close()
+	userConn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	if _, err := userConn.Write([]byte("hello")); err != nil {
+		t.Fatalf("写入失败: %v", err)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(userConn, buf); err != nil {
+		t.Fatalf("读取回显失败: %v", err)
+	}
+	if string(buf) != "hello" {
+		t.Errorf("回显内容 = %q, want %q", buf, "hello")
+	}
+
+	if proxyID := <-proxyIDs; len(proxyID) != 32 {
+		t.Errorf("proxyID 格式不正确: %q", proxyID)
+	}
+}
+
+// TestAccessLog 测试公网连接关闭后写入访问日志
+func TestAccessLog(t *testing.T) {
+	cfg := newTestServerConfig(17007)
+	accessFile := filepath.Join(t.TempDir(), "access.log")
+	cfg.AccessLog = config.LogConfig{Output: "file", Format: "json", File: accessFile}
+
+	s := NewServer(cfg)
+	if err := s.Start(); err != nil {
+		t.Fatalf("启动服务端失败: %v", err)
+	}
+	defer s.Stop()
+
+	conn := authTestClient(t, "127.0.0.1:17007", "access-client")
+	defer conn.Close()
+	if resp := registerTestTunnel(t, conn, "web", 18008); !resp.Success {
+		t.Fatalf("注册隧道失败: %s", resp.Message)
+	}
+	serveTestDataConn(t, conn, "127.0.0.1:17007", 5)
+
+	userConn, err := net.Dial("tcp", "127.0.0.1:18008")
+	if err != nil {
+		t.Fatalf("连接公共端口失败: %v", err)
+	}
+	userAddr := userConn.LocalAddr().String()
+	userConn.Write([]byte("hello"))
+	io.ReadFull(userConn, make([]byte, 5))
+	userConn.Close()
+
+	// 等待访问记录写入
+	var record map[string]any
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		data, _ := os.ReadFile(accessFile)
+		if line := strings.TrimSpace(string(data)); line != "" {
+			if err := json.Unmarshal([]byte(line), &record); err != nil {
+				t.Fatalf("访问日志不是 JSON: %v\n%s", err, line)
+			}
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if record == nil {
+		t.Fatal("未写入访问日志")
+	}
+
+	if record["tunnel"] != "web" || record["client_id"] != "access-client" || record["remote_addr"] != userAddr {
+		t.Errorf("访问记录字段不正确: %v", record)
+	}
+	if record["bytes_in"] != float64(5) || record["bytes_out"] != float64(5) {
+		t.Errorf("访问记录流量不正确: %v", record)
+	}
+	if record["close_reason"] != "eof" {
+		t.Errorf("close_reason = %v, want eof", record["close_reason"])
+	}
+	// 未压缩、未加密的隧道两端都是 TCP 连接，Linux 上使用 splice
+	wantMode := proxy.ForwardCopy
+	if runtime.GOOS == "linux" {
+		wantMode = proxy.ForwardSplice
+	}
+	if record["forward_mode"] != wantMode {
+		t.Errorf("forward_mode = %v, want %s", record["forward_mode"], wantMode)
+	}
+	for _, key := range []string{"start", "duration_ms"} {
+		if _, ok := record[key]; !ok {
+			t.Errorf("访问记录缺少字段 %s: %v", key, record)
+		}
+	}
+}
+
+// TestShutdownDrain 测试优雅关闭：通知客户端、拒绝新连接并等待已建立的连接结束
+func TestShutdownDrain(t *testing.T) {
+	cfg := newTestServerConfig(17009)
+
+	s := NewServer(cfg)
+	if err := s.Start(); err != nil {
+		t.Fatalf("启动服务端失败: %v", err)
+	}
+	defer s.Stop()
+
+	conn := authTestClient(t, "127.0.0.1:17009", "drain-client")
+	defer conn.Close()
+	if resp := registerTestTunnel(t, conn, "web", 18010); !resp.Success {
+		t.Fatalf("注册隧道失败: %s", resp.Message)
+	}
+
+	// 模拟客户端：建立数据连接后保持打开，收到排空通知时记录
+	dataConns := make(chan net.Conn, 1)
+	drained := make(chan *proto.DrainNotice, 1)
+	go func() {
+		for {
+			msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			switch msg.Type {
+			case proto.TypeDrain:
+				notice, _ := proto.Decode[proto.DrainNotice](msg.Data)
+				drained <- notice
+			case proto.TypeNewProxy:
+				req, _ := proto.Decode[proto.NewProxyRequest](msg.Data)
+				rawConn, err := net.Dial("tcp", "127.0.0.1:17009")
+				if err != nil {
+					return
+				}
+				data, _ := proto.Encode(&proto.ProxyReadyRequest{ProxyID: req.ProxyID})
+				connect.WrapConnect(rawConn).WriteMessage(&proto.Message{Type: proto.TypeProxyReady, Data: data})
+				dataConns <- rawConn
+			}
+		}
+	}()
+
+	userConn, err := net.Dial("tcp", "127.0.0.1:18010")
+	if err != nil {
+		t.Fatalf("连接公共端口失败: %v", err)
+	}
+	defer userConn.Close()
+	userConn.Write([]byte("hi"))
+
+	var dataConn net.Conn
+	select {
+	case dataConn = <-dataConns:
+	case <-time.After(2 * time.Second):
+		t.Fatal("超时等待数据连接")
+	}
+	io.ReadFull(dataConn, make([]byte, 2))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- s.Shutdown(ctx) }()
+
+	select {
+	case notice := <-drained:
+		if notice.Timeout <= 0 {
+			t.Errorf("排空通知超时时间 = %d, want > 0", notice.Timeout)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("未收到排空通知")
+	}
+
+	// 公共端口不再接受新连接
+	if c, err := net.DialTimeout("tcp", "127.0.0.1:18010", time.Second); err == nil {
+		c.Close()
+		t.Error("排空期间公共端口仍接受新连接")
+	}
+
+	// 已建立的连接仍可继续转发
+	dataConn.Write([]byte("ok"))
+	userConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(userConn, buf); err != nil || string(buf) != "ok" {
+		t.Fatalf("排空期间转发失败: %q, %v", buf, err)
+	}
+
+	select {
+	case <-done:
+		t.Fatal("仍有活动连接时 Shutdown 已返回")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// 连接结束后 Shutdown 返回
+	dataConn.Close()
+	userConn.Close()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Shutdown 返回错误: %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("连接结束后 Shutdown 未返回")
+	}
+}
+
+// TestShutdownStalledClient 测试不读取控制连接的客户端不会阻塞关闭流程
+func TestShutdownStalledClient(t *testing.T) {
+	cfg := newTestServerConfig(17032)
+	cfg.Server.HeartbeatTimeout = time.Minute
+
+	s := NewServer(cfg)
+	if err := s.Start(); err != nil {
+		t.Fatalf("启动服务端失败: %v", err)
+	}
+	defer s.Stop()
+
+	// 客户端认证后不再读取，写满控制连接的缓冲区
+	conn := authTestClient(t, "127.0.0.1:17032", "stalled-client")
+	defer conn.Close()
+	conn.RawConn().(*net.TCPConn).SetReadBuffer(4096)
+
+	// 认证响应发出后会话才登记，稍等片刻
+	var session *ClientSession
+	for i := 0; i < 50 && session == nil; i++ {
+		s.sessionsMu.RLock()
+		session = s.sessions["stalled-client"]
+		s.sessionsMu.RUnlock()
+		time.Sleep(10 * time.Millisecond)
+	}
+	if session == nil {
+		t.Fatal("未找到客户端会话")
+	}
+	go func() {
+		filler := &proto.Message{Type: proto.TypePing, Data: make([]byte, 32<<10)}
+		for session.conn.WriteMessage(filler) == nil {
+		}
+	}()
+	time.Sleep(300 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- s.Shutdown(ctx) }()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("客户端不读取时 Shutdown 未在期限内返回")
+	}
+}
+
+// TestAuthVersionNegotiation 测试认证时的版本检查和能力协商
+func TestAuthVersionNegotiation(t *testing.T) {
+	cfg := newTestServerConfig(17010)
+
+	s := NewServer(cfg)
+	if err := s.Start(); err != nil {
+		t.Fatalf("启动服务端失败: %v", err)
+	}
+	defer s.Stop()
+
+	auth := func(req *proto.AuthRequest) *proto.AuthResponse {
+		rawConn, err := net.Dial("tcp", "127.0.0.1:17010")
+		if err != nil {
+			t.Fatalf("连接服务端失败: %v", err)
+		}
+		defer rawConn.Close()
+		conn := connect.WrapConnect(rawConn)
+
+		data, _ := proto.Encode(req)
+		conn.WriteMessage(&proto.Message{Type: proto.TypeAuth, Data: data})
+		msg, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("读取认证响应失败: %v", err)
+		}
+		resp, err := proto.Decode[proto.AuthResponse](msg.Data)
+		if err != nil {
+			t.Fatalf("解析认证响应失败: %v", err)
+		}
+		return resp
+	}
+
+	// 主版本不同的客户端被拒绝，并返回明确原因
+	resp := auth(&proto.AuthRequest{ClientID: "v2-client", Token: "test-token", Version: "2.0.0"})
+	if resp.Success || !strings.Contains(resp.Message, "协议版本不兼容") {
+		t.Errorf("不兼容版本应被拒绝: %+v", resp)
+	}
+	if resp.Version != proto.ProtocolVersion {
+		t.Errorf("响应版本 = %q, want %q", resp.Version, proto.ProtocolVersion)
+	}
+
+	// 兼容版本返回协商后的能力
+	resp = auth(&proto.AuthRequest{
+		ClientID:     "v1-client",
+		Token:        "test-token",
+		Version:      "1.0.0",
+		Capabilities: []string{proto.CapBinary, proto.CapUDP},
+	})
+	if !resp.Success {
+		t.Fatalf("兼容版本认证失败: %s", resp.Message)
+	}
+	if len(resp.Capabilities) != 1 || resp.Capabilities[0] != proto.CapBinary {
+		t.Errorf("协商能力 = %v, want [%s]", resp.Capabilities, proto.CapBinary)
+	}
+}
+
+// TestJSONEncodingSession 测试未协商二进制编码的客户端使用 JSON 编码通信
+func TestJSONEncodingSession(t *testing.T) {
+	cfg := newTestServerConfig(17011)
+
+	s := NewServer(cfg)
+	if err := s.Start(); err != nil {
+		t.Fatalf("启动服务端失败: %v", err)
+	}
+	defer s.Stop()
+
+	rawConn, err := net.Dial("tcp", "127.0.0.1:17011")
+	if err != nil {
+		t.Fatalf("连接服务端失败: %v", err)
+	}
+	defer rawConn.Close()
+	conn := connect.WrapConnect(rawConn)
+
+	// 认证消息固定使用二进制编码
+	data, _ := proto.Encode(&proto.AuthRequest{
+		ClientID:     "json-client",
+		Token:        "test-token",
+		Version:      proto.ProtocolVersion,
+		Capabilities: []string{},
+	})
+	conn.WriteMessage(&proto.Message{Type: proto.TypeAuth, Data: data})
+	msg, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("读取认证响应失败: %v", err)
+	}
+	authResp, err := proto.Decode[proto.AuthResponse](msg.Data)
+	if err != nil || !authResp.Success {
+		t.Fatalf("认证失败: %+v, %v", authResp, err)
+	}
+	if enc := proto.NegotiateEncoding(authResp.Capabilities); enc != proto.EncodingJSON {
+		t.Fatalf("协商编码 = %s, want json", enc)
+	}
+	conn.SetEncoding(proto.EncodingJSON)
+
+	req := &proto.RegisterTunnelRequest{Tunnel: proto.TunnelConfig{Name: "web", Type: "tcp", RemotePort: 18011}}
+	if err := conn.WritePayload(proto.TypeRegisterTunnel, req); err != nil {
+		t.Fatalf("发送注册请求失败: %v", err)
+	}
+	for {
+		msg, err = conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("读取注册响应失败: %v", err)
+		}
+		if msg.Type == proto.TypeRegisterTunnelResp {
+			break
+		}
+	}
+	var resp proto.RegisterTunnelResponse
+	if err := msg.Unmarshal(proto.EncodingJSON, &resp); err != nil {
+		t.Fatalf("按 JSON 解析注册响应失败: %v", err)
+	}
+	if !resp.Success || resp.TunnelName != "web" {
+		t.Errorf("注册响应不正确: %+v", resp)
+	}
+}
+
+// TestProxyCompression 测试隧道注册时协商压缩算法，数据连接按协商结果压缩
+func TestProxyCompression(t *testing.T) {
+	cfg := newTestServerConfig(17012)
+
+	s := NewServer(cfg)
+	if err := s.Start(); err != nil {
+		t.Fatalf("启动服务端失败: %v", err)
+	}
+	defer s.Stop()
+
+	conn := authTestClient(t, "127.0.0.1:17012", "gzip-client")
+	defer conn.Close()
+
+	// 服务端不支持的算法回退为不压缩
+	data, _ := proto.Encode(&proto.RegisterTunnelRequest{
+		Tunnel: proto.TunnelConfig{Name: "plain", Type: "tcp", RemotePort: 18013, Compression: "lz4"},
+	})
+	conn.WriteMessage(&proto.Message{Type: proto.TypeRegisterTunnel, Data: data})
+	resp := readTestTunnelResponse(t, conn)
+	if !resp.Success || resp.Compression != "" {
+		t.Errorf("不支持的压缩算法应回退为不压缩: %+v", resp)
+	}
+
+	data, _ = proto.Encode(&proto.RegisterTunnelRequest{
+		Tunnel: proto.TunnelConfig{Name: "gzip", Type: "tcp", RemotePort: 18012, Compression: "gzip"},
+	})
+	conn.WriteMessage(&proto.Message{Type: proto.TypeRegisterTunnel, Data: data})
+	if resp := readTestTunnelResponse(t, conn); !resp.Success || resp.Compression != "gzip" {
+		t.Fatalf("注册压缩隧道失败: %+v", resp)
+	}
+
+	// 模拟客户端：数据连接使用 gzip 压缩，回显收到的数据
+	go func() {
+		for {
+			msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if msg.Type != proto.TypeNewProxy {
+				continue
+			}
+			req, _ := proto.Decode[proto.NewProxyRequest](msg.Data)
+			rawConn, err := net.Dial("tcp", "127.0.0.1:17012")
+			if err != nil {
+				return
+			}
+			data, _ := proto.Encode(&proto.ProxyReadyRequest{ProxyID: req.ProxyID})
+			connect.WrapConnect(rawConn).WriteMessage(&proto.Message{Type: proto.TypeProxyReady, Data: data})
+
+			zconn, _ := proxy.WrapCompression(rawConn, proto.CompressionGzip)
+			buf := make([]byte, 5)
+			if _, err := io.ReadFull(zconn, buf); err == nil {
+				zconn.Write(buf)
+			}
+			zconn.Close()
+			return
+		}
+	}()
+
+	userConn, err := net.Dial("tcp", "127.0.0.1:18012")
+	if err != nil {
+		t.Fatalf("连接公共端口失败: %v", err)
+	}
+	defer userConn.Close()
+	userConn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	userConn.Write([]byte("hello"))
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(userConn, buf); err != nil {
+		t.Fatalf("读取回显失败: %v", err)
+	}
+	if string(buf) != "hello" {
+		t.Errorf("回显内容 = %q, want %q", buf, "hello")
+	}
+}
+
+// TestProxyEncryption 测试加密隧道：数据连接使用 token 派生的密钥加密，且可与压缩叠加
+func TestProxyEncryption(t *testing.T) {
+	cfg := newTestServerConfig(17013)
+
+	s := NewServer(cfg)
+	if err := s.Start(); err != nil {
+		t.Fatalf("启动服务端失败: %v", err)
+	}
+	defer s.Stop()
+
+	conn := authTestClient(t, "127.0.0.1:17013", "crypt-client")
+	defer conn.Close()
+
+	data, _ := proto.Encode(&proto.RegisterTunnelRequest{
+		Tunnel: proto.TunnelConfig{Name: "secret", Type: "tcp", RemotePort: 18014, Compression: "gzip", Encryption: true},
+	})
+	conn.WriteMessage(&proto.Message{Type: proto.TypeRegisterTunnel, Data: data})
+	if resp := readTestTunnelResponse(t, conn); !resp.Success || !resp.Encryption || resp.Compression != "gzip" {
+		t.Fatalf("注册加密隧道失败: %+v", resp)
+	}
+
+	// 模拟客户端：与服务端相同的顺序包装数据连接，并记录收到的密文
+	wire := make(chan []byte, 1)
+	go func() {
+		for {
+			msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if msg.Type != proto.TypeNewProxy {
+				continue
+			}
+			req, _ := proto.Decode[proto.NewProxyRequest](msg.Data)
+			rawConn, err := net.Dial("tcp", "127.0.0.1:17013")
+			if err != nil {
+				return
+			}
+			data, _ := proto.Encode(&proto.ProxyReadyRequest{ProxyID: req.ProxyID})
+			connect.WrapConnect(rawConn).WriteMessage(&proto.Message{Type: proto.TypeProxyReady, Data: data})
+
+			recorder := &recordConn{Conn: rawConn}
+			zconn, _ := proxy.WrapCompression(proxy.WrapEncryption(recorder, "test-token", req.ProxyID, proxy.CryptClient), proto.CompressionGzip)
+			buf := make([]byte, 11)
+			if _, err := io.ReadFull(zconn, buf); err == nil {
+				zconn.Write(buf)
+			}
+			wire <- recorder.read
+			zconn.Close()
+			return
+		}
+	}()
+
+	userConn, err := net.Dial("tcp", "127.0.0.1:18014")
+	if err != nil {
+		t.Fatalf("连接公共端口失败: %v", err)
+	}
+	defer userConn.Close()
+	userConn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	userConn.Write([]byte("hello crypt"))
+	buf := make([]byte, 11)
+	if _, err := io.ReadFull(userConn, buf); err != nil {
+		t.Fatalf("读取回显失败: %v", err)
+	}
+	if string(buf) != "hello crypt" {
+		t.Errorf("回显内容 = %q, want %q", buf, "hello crypt")
+	}
+	if raw := <-wire; bytes.Contains(raw, []byte("hello")) || len(raw) == 0 {
+		t.Errorf("数据连接上出现明文: %q", raw)
+	}
+}
+
+// recordConn 记录从连接读到的原始字节
+type recordConn struct {
+	net.Conn
+	read []byte
+}
+
+func (c *recordConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.read = append(c.read, p[:n]...)
+	return n, err
+}
+
+// TestPreAuthLimits 测试未认证连接的数量限制、超时和首条消息类型检查
+func TestPreAuthLimits(t *testing.T) {
+	cfg := newTestServerConfig(17014)
+	cfg.Server.AuthTimeout = 500 * time.Millisecond
+	cfg.Server.MaxPendingPerIP = 2
+
+	s := NewServer(cfg)
+	if err := s.Start(); err != nil {
+		t.Fatalf("启动服务端失败: %v", err)
+	}
+	defer s.Stop()
+
+	// 两个不发送任何数据的慢速连接占满单 IP 名额
+	var idle []net.Conn
+	for i := 0; i < 2; i++ {
+		c, err := net.Dial("tcp", "127.0.0.1:17014")
+		if err != nil {
+			t.Fatalf("连接服务端失败: %v", err)
+		}
+		defer c.Close()
+		idle = append(idle, c)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	// 第三个连接被立即关闭
+	extra, err := net.Dial("tcp", "127.0.0.1:17014")
+	if err != nil {
+		t.Fatalf("连接服务端失败: %v", err)
+	}
+	defer extra.Close()
+	extra.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+	if _, err := extra.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("超过单 IP 上限的连接应被关闭, got %v", err)
+	}
+
+	// 慢速连接在认证超时后被关闭，名额释放
+	for _, c := range idle {
+		c.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if _, err := c.Read(make([]byte, 1)); err != io.EOF {
+			t.Errorf("认证超时的连接应被关闭, got %v", err)
+		}
+	}
+
+	// 认证前发送其他类型的消息会被直接断开
+	rawConn, err := net.Dial("tcp", "127.0.0.1:17014")
+	if err != nil {
+		t.Fatalf("连接服务端失败: %v", err)
+	}
+	defer rawConn.Close()
+	connect.WrapConnect(rawConn).WriteMessage(&proto.Message{Type: proto.TypeRegisterTunnel, Data: []byte("x")})
+	rawConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	// 服务端未读完数据就关闭时可能返回 RST，EOF 和 reset 都视为断开
+	_, err = rawConn.Read(make([]byte, 1))
+	if ne, ok := err.(net.Error); err == nil || (ok && ne.Timeout()) {
+		t.Errorf("认证前的非法消息应导致断开, got %v", err)
+	}
+
+	// 名额释放后正常客户端可以认证
+	conn := authTestClient(t, "127.0.0.1:17014", "limit-client")
+	conn.Close()
+}
+
+// TestAutoPort 测试 remote_port 为 0 时从配置的范围分配端口，并优先分配客户端上次的端口
+func TestAutoPort(t *testing.T) {
+	cfg := newTestServerConfig(17015)
+	cfg.Server.AutoPortRange = config.PortRange{Min: 18015, Max: 18017}
+
+	s := NewServer(cfg)
+	if err := s.Start(); err != nil {
+		t.Fatalf("启动服务端失败: %v", err)
+	}
+	defer s.Stop()
+
+	conn := authTestClient(t, "127.0.0.1:17015", "auto-client")
+	defer conn.Close()
+
+	register := func(name string, preferred int) *proto.RegisterTunnelResponse {
+		data, _ := proto.Encode(&proto.RegisterTunnelRequest{
+			Tunnel: proto.TunnelConfig{Name: name, Type: "tcp", PreferredPort: preferred},
+		})
+		if err := conn.WriteMessage(&proto.Message{Type: proto.TypeRegisterTunnel, Data: data}); err != nil {
+			t.Fatalf("发送注册请求失败: %v", err)
+		}
+		return readTestTunnelResponse(t, conn)
+	}
+
+	first := register("a", 0)
+	if !first.Success || !cfg.Server.AutoPortRange.Contains(first.RemotePort) {
+		t.Fatalf("应从范围内分配端口: %+v", first)
+	}
+	userConn, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", first.RemotePort), time.Second)
+	if err != nil {
+		t.Fatalf("分配的端口未监听: %v", err)
+	}
+	userConn.Close()
+
+	second := register("b", 0)
+	if !second.Success || second.RemotePort == first.RemotePort {
+		t.Fatalf("第二个隧道应分配不同端口: %+v", second)
+	}
+
+	// 注销后刚释放的端口不立即分配给其他隧道
+	data, _ := proto.Encode(&proto.UnregisterTunnelRequest{TunnelName: "a"})
+	conn.WriteMessage(&proto.Message{Type: proto.TypeUnregisterTunnel, Data: data})
+	third := register("c", 0)
+	if !third.Success || third.RemotePort == first.RemotePort || third.RemotePort == second.RemotePort {
+		t.Fatalf("第三个隧道应分配剩余的端口: %+v", third)
+	}
+
+	// 重连后携带上次的端口，空闲时分配同一端口
+	if resp := register("a", first.RemotePort); !resp.Success || resp.RemotePort != first.RemotePort {
+		t.Fatalf("应分配客户端上次的端口 %d: %+v", first.RemotePort, resp)
+	}
+
+	// 范围已用完，上次的端口被占用时也不会分配范围外的端口
+	if resp := register("d", second.RemotePort); resp.Success || resp.Message != "没有可分配的端口" {
+		t.Fatalf("范围用完后注册应失败: %+v", resp)
+	}
+}
+
+// TestAutoPortSystem 测试未配置范围和白名单时由系统分配端口
+func TestAutoPortSystem(t *testing.T) {
+	cfg := newTestServerConfig(17016)
+
+	s := NewServer(cfg)
+	if err := s.Start(); err != nil {
+		t.Fatalf("启动服务端失败: %v", err)
+	}
+	defer s.Stop()
+
+	conn := authTestClient(t, "127.0.0.1:17016", "auto-client")
+	defer conn.Close()
+
+	resp := registerTestTunnel(t, conn, "web", 0)
+	if !resp.Success || resp.RemotePort == 0 {
+		t.Fatalf("应由系统分配端口: %+v", resp)
+	}
+	userConn, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", resp.RemotePort), time.Second)
+	if err != nil {
+		t.Fatalf("分配的端口未监听: %v", err)
+	}
+	userConn.Close()
+
+	// 重连后携带上次的端口，未配置范围时同样优先分配
+	data, _ := proto.Encode(&proto.UnregisterTunnelRequest{TunnelName: "web"})
+	conn.WriteMessage(&proto.Message{Type: proto.TypeUnregisterTunnel, Data: data})
+	data, _ = proto.Encode(&proto.RegisterTunnelRequest{
+		Tunnel: proto.TunnelConfig{Name: "web", Type: "tcp", PreferredPort: resp.RemotePort},
+	})
+	if err := conn.WriteMessage(&proto.Message{Type: proto.TypeRegisterTunnel, Data: data}); err != nil {
+		t.Fatalf("发送注册请求失败: %v", err)
+	}
+	if again := readTestTunnelResponse(t, conn); !again.Success || again.RemotePort != resp.RemotePort {
+		t.Fatalf("应分配客户端上次的端口 %d: %+v", resp.RemotePort, again)
+	}
+}
+
+// TestPortPolicy 测试端口范围、禁止端口、保留端口和端口占用检查，拒绝原因返回给客户端
+func TestPortPolicy(t *testing.T) {
+	cfg := newTestServerConfig(17017)
+	cfg.Server.PublicPorts = []config.PortRange{{Min: 18018, Max: 18020}}
+	cfg.Server.DeniedPorts = []config.PortRange{{Min: 18019, Max: 18019}}
+	cfg.Server.ReservedPorts = []config.Reservation{{Ports: config.PortRange{Min: 18021, Max: 18021}, ClientID: "office"}}
+
+	s := NewServer(cfg)
+	if err := s.Start(); err != nil {
+		t.Fatalf("启动服务端失败: %v", err)
+	}
+	defer s.Stop()
+
+	office := authTestClient(t, "127.0.0.1:17017", "office")
+	defer office.Close()
+	guest := authTestClient(t, "127.0.0.1:17017", "guest")
+	defer guest.Close()
+
+	// 18020 被其他程序占用
+	busy, err := net.Listen("tcp", "0.0.0.0:18020")
+	if err != nil {
+		t.Fatalf("占用端口失败: %v", err)
+	}
+	defer busy.Close()
+
+	tests := []struct {
+		name    string
+		conn    *connect.Connect
+		port    int
+		wantMsg string // 为空表示应注册成功
+	}{
+		{name: "guest-web", conn: guest, port: 18018},
+		{name: "office-web", conn: office, port: 18018, wantMsg: "端口 18018 已被其他隧道使用"},
+		{name: "guest-denied", conn: guest, port: 18019, wantMsg: "端口 18019 禁止使用"},
+		{name: "guest-reserved", conn: guest, port: 18021, wantMsg: "端口 18021 已保留给其他客户端"},
+		{name: "office-reserved", conn: office, port: 18021},
+		{name: "guest-outside", conn: guest, port: 18022, wantMsg: "端口 18022 不在允许使用的端口范围内"},
+		{name: "guest-busy", conn: guest, port: 18020, wantMsg: "端口 18020 监听失败（可能被其他程序占用）"},
+		// 白名单内剩余端口均被禁止、使用或占用
+		{name: "guest-auto", conn: guest, port: 0, wantMsg: "没有可分配的端口"},
+	}
+
+	for _, tt := range tests {
+		resp := registerTestTunnel(t, tt.conn, tt.name, tt.port)
+		if tt.wantMsg == "" {
+			if !resp.Success || resp.RemotePort != tt.port {
+				t.Errorf("%s: 注册应成功: %+v", tt.name, resp)
+			}
+			continue
+		}
+		if resp.Success || resp.Message != tt.wantMsg {
+			t.Errorf("%s: Message = %q, want %q (success=%v)", tt.name, resp.Message, tt.wantMsg, resp.Success)
+		}
+	}
+}
+
+// TestBindAddr 测试默认监听地址、隧道指定的监听地址及其策略检查
+func TestBindAddr(t *testing.T) {
+	cfg := newTestServerConfig(17018)
+	cfg.Server.ProxyBindAddr = "127.0.0.1"
+	cfg.Server.AllowedBindAddrs = []string{"127.0.0.2"}
+
+	s := NewServer(cfg)
+	if err := s.Start(); err != nil {
+		t.Fatalf("启动服务端失败: %v", err)
+	}
+	defer s.Stop()
+
+	conn := authTestClient(t, "127.0.0.1:17018", "bind-client")
+	defer conn.Close()
+
+	register := func(name, bindAddr string) *proto.RegisterTunnelResponse {
+		data, _ := proto.Encode(&proto.RegisterTunnelRequest{
+			Tunnel: proto.TunnelConfig{Name: name, Type: "tcp", RemotePort: 18023, BindAddr: bindAddr},
+		})
+		if err := conn.WriteMessage(&proto.Message{Type: proto.TypeRegisterTunnel, Data: data}); err != nil {
+			t.Fatalf("发送注册请求失败: %v", err)
+		}
+		return readTestTunnelResponse(t, conn)
+	}
+
+	if resp := register("default", ""); !resp.Success {
+		t.Fatalf("使用默认监听地址注册失败: %+v", resp)
+	}
+	s.proxiesMu.RLock()
+	addr := s.proxies["default"].listener.Addr().String()
+	s.proxiesMu.RUnlock()
+	if addr != "127.0.0.1:18023" {
+		t.Errorf("默认监听地址 = %s, want 127.0.0.1:18023", addr)
+	}
+
+	// 不同地址上的相同端口互不冲突
+	if resp := register("internal", "127.0.0.2"); !resp.Success {
+		t.Fatalf("指定允许的监听地址注册失败: %+v", resp)
+	}
+	userConn, err := net.DialTimeout("tcp", "127.0.0.2:18023", time.Second)
+	if err != nil {
+		t.Fatalf("连接指定的监听地址失败: %v", err)
+	}
+	userConn.Close()
+
+	if resp := register("other", "127.0.0.3"); resp.Success || resp.Message != "监听地址 127.0.0.3 不允许使用" {
+		t.Errorf("未允许的监听地址应被拒绝: %+v", resp)
+	}
+	if resp := register("dup", "127.0.0.2"); resp.Success || resp.Message != "端口 18023 已被其他隧道使用" {
+		t.Errorf("同一地址上的相同端口应被拒绝: %+v", resp)
+	}
+
+	// 通配地址与任何地址冲突
+	conflicts := []struct {
+		a, b string
+		want bool
+	}{
+		{"0.0.0.0", "127.0.0.1", true},
+		{"::", "10.0.0.1", true},
+		{"", "::1", true},
+		{"127.0.0.1", "127.0.0.2", false},
+		{"::1", "0:0:0:0:0:0:0:1", true},
+		{"::1", "127.0.0.1", false},
+	}
+	for _, c := range conflicts {
+		if got := bindConflict(c.a, c.b); got != c.want {
+			t.Errorf("bindConflict(%q, %q) = %v, want %v", c.a, c.b, got, c.want)
+		}
+	}
+}
+
+// registerGroupTunnel 注册负载均衡组隧道并返回响应
+func registerGroupTunnel(t *testing.T, conn *connect.Connect, tunnel proto.TunnelConfig) *proto.RegisterTunnelResponse {
+	t.Helper()
+
+	tunnel.Type = "tcp"
+	data, _ := proto.Encode(&proto.RegisterTunnelRequest{Tunnel: tunnel})
+	if err := conn.WriteMessage(&proto.Message{Type: proto.TypeRegisterTunnel, Data: data}); err != nil {
+		t.Fatalf("发送注册请求失败: %v", err)
+	}
+	return readTestTunnelResponse(t, conn)
+}
+
+// serveGroupMember 模拟组成员客户端：每收到一个 NewProxy 建立数据连接，写入成员标识后关闭
+func serveGroupMember(conn *connect.Connect, addr, id string) {
+	go func() {
+		for {
+			msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if msg.Type != proto.TypeNewProxy {
+				continue
+			}
+			req, err := proto.Decode[proto.NewProxyRequest](msg.Data)
+			if err != nil {
+				return
+			}
+
+			rawConn, err := net.Dial("tcp", addr)
+			if err != nil {
+				return
+			}
+			data, _ := proto.Encode(&proto.ProxyReadyRequest{ProxyID: req.ProxyID})
+			connect.WrapConnect(rawConn).WriteMessage(&proto.Message{Type: proto.TypeProxyReady, Data: data})
+			rawConn.Write([]byte(id))
+			rawConn.Close()
+		}
+	}()
+}
+
+// readGroupMember 连接组端口，返回处理该连接的成员标识
+func readGroupMember(t *testing.T, addr string) string {
+	t.Helper()
+
+	userConn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("连接组端口失败: %v", err)
+	}
+	defer userConn.Close()
+	userConn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	id, err := io.ReadAll(userConn)
+	if err != nil {
+		t.Fatalf("读取成员标识失败: %v", err)
+	}
+	return string(id)
+}
+
+// TestGroup 测试多个客户端加入负载均衡组共享端口，会话断开后移出组
+func TestGroup(t *testing.T) {
+	cfg := newTestServerConfig(17019)
+
+	s := NewServer(cfg)
+	if err := s.Start(); err != nil {
+		t.Fatalf("启动服务端失败: %v", err)
+	}
+	defer s.Stop()
+
+	a := authTestClient(t, "127.0.0.1:17019", "member-a")
+	defer a.Close()
+	b := authTestClient(t, "127.0.0.1:17019", "member-b")
+	defer b.Close()
+	other := authTestClient(t, "127.0.0.1:17019", "other")
+	defer other.Close()
+
+	// 不同客户端使用相同的隧道名称，后加入的成员不指定端口时使用组的端口
+	if resp := registerGroupTunnel(t, a, proto.TunnelConfig{Name: "web", RemotePort: 18026, Group: "web"}); !resp.Success {
+		t.Fatalf("创建负载均衡组失败: %+v", resp)
+	}
+	if resp := registerGroupTunnel(t, b, proto.TunnelConfig{Name: "web", Group: "web"}); !resp.Success || resp.RemotePort != 18026 {
+		t.Fatalf("加入负载均衡组失败: %+v", resp)
+	}
+
+	rejects := []struct {
+		tunnel proto.TunnelConfig
+		want   string
+	}{
+		{proto.TunnelConfig{Name: "web2", Group: "web", Strategy: config.LBLeastConn}, "负载均衡组 web 使用 round_robin 策略"},
+		{proto.TunnelConfig{Name: "web2", Group: "web", RemotePort: 18027}, "负载均衡组 web 使用端口 18026"},
+		{proto.TunnelConfig{Name: "web2", Group: "web", Strategy: "random"}, "不支持的负载均衡策略"},
+		{proto.TunnelConfig{Name: "web2", RemotePort: 18026}, "端口 18026 已被其他隧道使用"},
+	}
+	for _, r := range rejects {
+		if resp := registerGroupTunnel(t, other, r.tunnel); resp.Success || resp.Message != r.want {
+			t.Errorf("注册 %+v 应被拒绝: %+v, want %q", r.tunnel, resp, r.want)
+		}
+	}
+
+	serveGroupMember(a, "127.0.0.1:17019", "a")
+	serveGroupMember(b, "127.0.0.1:17019", "b")
+
+	// 轮询依次分配给两个成员
+	var got []string
+	for i := 0; i < 4; i++ {
+		got = append(got, readGroupMember(t, "127.0.0.1:18026"))
+	}
+	if strings.Join(got, ",") != "a,b,a,b" {
+		t.Errorf("轮询分配顺序 = %v, want [a b a b]", got)
+	}
+
+	// 成员会话断开后不再分配连接
+	b.Close()
+	s.proxiesMu.RLock()
+	g := s.groups["web"]
+	s.proxiesMu.RUnlock()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		g.mu.Lock()
+		n := len(g.members)
+		g.mu.Unlock()
+		if n == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("会话断开后组内仍有 %d 个成员", n)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	for i := 0; i < 2; i++ {
+		if id := readGroupMember(t, "127.0.0.1:18026"); id != "a" {
+			t.Errorf("连接分配给了已断开的成员: %q", id)
+		}
+	}
+
+	// 最后一个成员注销后关闭监听
+	data, _ := proto.Encode(&proto.UnregisterTunnelRequest{TunnelName: "web"})
+	a.WriteMessage(&proto.Message{Type: proto.TypeUnregisterTunnel, Data: data})
+	deadline = time.Now().Add(2 * time.Second)
+	for !g.isClosed() {
+		if time.Now().After(deadline) {
+			t.Fatal("最后一个成员注销后组未关闭")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if resp := registerGroupTunnel(t, other, proto.TunnelConfig{Name: "web", RemotePort: 18026}); !resp.Success {
+		t.Errorf("组关闭后端口应可重新使用: %+v", resp)
+	}
+}
+
+// TestGroupPick 测试加权轮询和最少连接的选择顺序，以及跳过不健康的成员
+func TestGroupPick(t *testing.T) {
+	session := &ClientSession{clientID: "pick", ctx: context.Background()}
+	a := NewProxy(nil, session, "a", 0)
+	b := NewProxy(nil, session, "b", 0)
+
+	weighted := newGroup("weighted", config.LBWeighted)
+	weighted.add(a, 3)
+	weighted.add(b, 1)
+	var got []string
+	for i := 0; i < 8; i++ {
+		got = append(got, weighted.pick().name)
+	}
+	if strings.Join(got, ",") != "a,a,b,a,a,a,b,a" {
+		t.Errorf("加权轮询顺序 = %v", got)
+	}
+
+	least := newGroup("least", config.LBLeastConn)
+	least.add(a, 0)
+	least.add(b, 0)
+	a.conns[&net.TCPConn{}] = struct{}{}
+	for i := 0; i < 3; i++ {
+		if p := least.pick(); p != b {
+			t.Fatalf("最少连接应选择 b, got %s", p.name)
+		}
+	}
+	b.conns[&net.TCPConn{}] = struct{}{}
+	b.conns[&net.TCPConn{}] = struct{}{}
+	if p := least.pick(); p != a {
+		t.Errorf("最少连接应选择 a, got %s", p.name)
+	}
+
+	// 不健康的成员不参与分配
+	b.setHealth(false, "connection refused")
+	for i := 0; i < 2; i++ {
+		if p := least.pick(); p != a {
+			t.Errorf("应跳过不健康的成员, got %s", p.name)
+		}
+	}
+	a.setHealth(false, "connection refused")
+	if p := least.pick(); p != nil {
+		t.Errorf("所有成员都不健康时不应选择成员, got %s", p.name)
+	}
+
+	least.remove(a)
+	least.remove(b)
+	if p := least.pick(); p != nil || !least.isClosed() {
+		t.Errorf("所有成员离开后应关闭且不再选择成员, got %v", p)
+	}
+}
+
+// TestProxyFailed 测试客户端通知连接本地服务失败后立即关闭用户连接并计数
+func TestProxyFailed(t *testing.T) {
+	cfg := newTestServerConfig(17022)
+
+	// 失败次数记录在日志中，供运维查看
+	logPath := filepath.Join(t.TempDir(), "server.log")
+	if err := log.Setup(log.Options{Level: log.LevelInfo, Format: log.FormatJSON, Output: log.OutputFile, File: logPath}); err != nil {
+		t.Fatalf("配置日志失败: %v", err)
+	}
+	defer log.SetLevel(log.LevelDebug)
+
+	s := NewServer(cfg)
+	if err := s.Start(); err != nil {
+		t.Fatalf("启动服务端失败: %v", err)
+	}
+	defer s.Stop()
+
+	conn := authTestClient(t, "127.0.0.1:17022", "failed-client")
+	defer conn.Close()
+	if resp := registerTestTunnel(t, conn, "down", 18028); !resp.Success {
+		t.Fatalf("注册隧道失败: %s", resp.Message)
+	}
+
+	// 模拟客户端：收到 NewProxy 后回复 ProxyFailed
+	go func() {
+		for {
+			msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if msg.Type != proto.TypeNewProxy {
+				continue
+			}
+			req, _ := proto.Decode[proto.NewProxyRequest](msg.Data)
+			data, _ := proto.Encode(&proto.ProxyFailed{ProxyID: req.ProxyID, Reason: "connection refused"})
+			conn.WriteMessage(&proto.Message{Type: proto.TypeProxyFailed, Data: data})
+		}
+	}()
+
+	for i := 1; i <= 2; i++ {
+		userConn, err := net.Dial("tcp", "127.0.0.1:18028")
+		if err != nil {
+			t.Fatalf("连接公共端口失败: %v", err)
+		}
+		start := time.Now()
+		userConn.SetDeadline(time.Now().Add(dataConnTimeout))
+		if _, err := userConn.Read(make([]byte, 1)); err != io.EOF {
+			t.Errorf("用户连接应被关闭, got %v", err)
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("应立即关闭而不是等待超时: %v", elapsed)
+		}
+		userConn.Close()
+
+		s.proxiesMu.RLock()
+		failures := s.proxies["down"].Failures()
+		s.proxiesMu.RUnlock()
+		if failures != int64(i) {
+			t.Errorf("Failures = %d, want %d", failures, i)
+		}
+	}
+
+	// 注销隧道时报告累计的失败次数
+	data, _ := proto.Encode(&proto.UnregisterTunnelRequest{TunnelName: "down"})
+	conn.WriteMessage(&proto.Message{Type: proto.TypeUnregisterTunnel, Data: data})
+	var stopped map[string]any
+	for deadline := time.Now().Add(2 * time.Second); stopped == nil && time.Now().Before(deadline); {
+		time.Sleep(20 * time.Millisecond)
+		logData, _ := os.ReadFile(logPath)
+		for _, line := range strings.Split(strings.TrimSpace(string(logData)), "\n") {
+			var record map[string]any
+			if json.Unmarshal([]byte(line), &record) == nil && record["msg"] == "代理停止" && record["tunnelName"] == "down" {
+				stopped = record
+			}
+		}
+	}
+	if stopped == nil || stopped["failures"] != float64(2) {
+		t.Errorf("代理停止时应记录失败次数 2: %v", stopped)
+	}
+
+	// 未在等待的 proxyID 被忽略
+	if s.pending.fail("unknown", nil, "x") {
+		t.Error("不存在的 proxyID 不应送达")
+	}
+
+	// 只有发出 NewProxy 的会话可以通知失败
+	owner, other := &ClientSession{}, &ClientSession{}
+	ch := s.pending.add("owned", owner)
+	defer s.pending.remove("owned", ch)
+	if s.pending.fail("owned", other, "x") {
+		t.Error("其他会话的失败通知不应送达")
+	}
+	if !s.pending.fail("owned", owner, "x") {
+		t.Error("所属会话的失败通知应送达")
+	}
+}
+
+// TestRegisterTunnelRollback 测试监听失败时撤销名称和端口的登记
+func TestRegisterTunnelRollback(t *testing.T) {
+	cfg := newTestServerConfig(17031)
+
+	s := NewServer(cfg)
+	if err := s.Start(); err != nil {
+		t.Fatalf("启动服务端失败: %v", err)
+	}
+	defer s.Stop()
+
+	// 端口被其他程序占用
+	busy, err := net.Listen("tcp", ":18042")
+	if err != nil {
+		t.Fatalf("占用端口失败: %v", err)
+	}
+	defer busy.Close()
+
+	conn := authTestClient(t, "127.0.0.1:17031", "rollback-client")
+	defer conn.Close()
+	if resp := registerTestTunnel(t, conn, "web", 18042); resp.Success {
+		t.Fatal("端口被占用时注册应失败")
+	}
+
+	s.proxiesMu.RLock()
+	_, reserved := s.proxies["web"]
+	s.proxiesMu.RUnlock()
+	if reserved {
+		t.Error("启动失败的隧道不应保留登记")
+	}
+	if resp := registerTestTunnel(t, conn, "web", 18043); !resp.Success {
+		t.Errorf("撤销登记后应能以相同名称注册: %s", resp.Message)
+	}
+}
+
+// TestHTTPBadGateway 测试 http 隧道在客户端无法连接本地服务时向用户返回 502 页面
+func TestHTTPBadGateway(t *testing.T) {
+	cfg := newTestServerConfig(17030)
+
+	s := NewServer(cfg)
+	if err := s.Start(); err != nil {
+		t.Fatalf("启动服务端失败: %v", err)
+	}
+	defer s.Stop()
+
+	conn := authTestClient(t, "127.0.0.1:17030", "http-client")
+	defer conn.Close()
+
+	data, _ := proto.Encode(&proto.RegisterTunnelRequest{
+		Tunnel: proto.TunnelConfig{Name: "web", Type: proto.TunnelHTTP, RemotePort: 18040},
+	})
+	conn.WriteMessage(&proto.Message{Type: proto.TypeRegisterTunnel, Data: data})
+	if resp := readTestTunnelResponse(t, conn); !resp.Success {
+		t.Fatalf("注册 http 隧道失败: %s", resp.Message)
+	}
+
+	// 未知的隧道类型被拒绝
+	data, _ = proto.Encode(&proto.RegisterTunnelRequest{
+		Tunnel: proto.TunnelConfig{Name: "udp", Type: "udp", RemotePort: 18041},
+	})
+	conn.WriteMessage(&proto.Message{Type: proto.TypeRegisterTunnel, Data: data})
+	if resp := readTestTunnelResponse(t, conn); resp.Success {
+		t.Error("未知的隧道类型应被拒绝")
+	}
+
+	// 模拟客户端：收到 NewProxy 后回复 ProxyFailed
+	go func() {
+		for {
+			msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if msg.Type != proto.TypeNewProxy {
+				continue
+			}
+			req, _ := proto.Decode[proto.NewProxyRequest](msg.Data)
+			data, _ := proto.Encode(&proto.ProxyFailed{ProxyID: req.ProxyID, Reason: "connection refused"})
+			conn.WriteMessage(&proto.Message{Type: proto.TypeProxyFailed, Data: data})
+		}
+	}()
+
+	resp, err := http.Get("http://127.0.0.1:18040/")
+	if err != nil {
+		t.Fatalf("请求失败: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusBadGateway || !strings.Contains(string(body), "502 Bad Gateway") {
+		t.Errorf("status = %d, body = %q, want 502", resp.StatusCode, body)
+	}
+}
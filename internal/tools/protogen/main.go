@@ -0,0 +1,480 @@
+// protogen 根据 proto 包中的结构体定义生成二进制编解码代码
+//
+// 用法（在 internal/pkg/proto 目录下由 go generate 调用）：
+//
+//	go run ../../tools/protogen -in types.go -out codec_gen.go -test codec_gen_test.go
+//
+// 生成规则：
+//   - 带有 //proto:binary 指令的结构体生成 EncodeBinary/DecodeBinary
+//   - 字段按声明顺序编码：string 为 2 字节长度前缀，bool 为 1 字节，int 为 4 字节大端，
+//     []string 为 2 字节数量前缀，嵌套的结构体按其自身格式内联编码
+//   - 带有 bin:"optional" 标签的字段开始一个可选字段组，组内字段均为零值且后续组也不存在时不写入，
+//     用于兼容不携带新字段的旧版本；解码时组存在但均为零值视为非规范编码
+//   - 以 Type 开头的 uint8 常量生成 GetTypeName
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// directive 标记需要生成二进制编解码的结构体
+const directive = "//proto:binary"
+
+// recv 生成代码统一使用的接收者名称，避免与局部变量（n、err、buf 等）冲突
+const recv = "m"
+
+// 支持的字段类型
+const (
+	kindString  = "string"
+	kindBool    = "bool"
+	kindInt     = "int"
+	kindStrings = "[]string"
+	kindStruct  = "struct"
+)
+
+type field struct {
+	name string
+	kind string
+	typ  string // kindStruct 时为结构体类型名
+}
+
+type message struct {
+	name     string
+	required []field
+	groups   [][]field // 可选字段组，按顺序出现
+}
+
+// hasOptional 结构体是否包含可选字段组（此时只能作为最后一个字段嵌套）
+func (m *message) hasOptional() bool {
+	return len(m.groups) > 0
+}
+
+type typeConst struct {
+	name string
+}
+
+func main() {
+	in := flag.String("in", "types.go", "结构体定义文件")
+	out := flag.String("out", "codec_gen.go", "生成的编解码文件")
+	testOut := flag.String("test", "codec_gen_test.go", "生成的测试文件，为空则不生成")
+	flag.Parse()
+
+	if err := run(*in, *out, *testOut); err != nil {
+		fmt.Fprintf(os.Stderr, "protogen: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(in, out, testOut string) error {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, in, nil, parser.ParseComments)
+	if err != nil {
+		return err
+	}
+
+	msgs, err := parseMessages(file)
+	if err != nil {
+		return err
+	}
+	consts, err := parseTypeConsts(file)
+	if err != nil {
+		return err
+	}
+
+	if err := writeSource(out, genCodec(file.Name.Name, in, msgs, consts)); err != nil {
+		return err
+	}
+	if testOut != "" {
+		return writeSource(testOut, genTests(file.Name.Name, in, msgs))
+	}
+	return nil
+}
+
+// parseMessages 解析带有生成指令的结构体
+func parseMessages(file *ast.File) ([]*message, error) {
+	var msgs []*message
+	byName := make(map[string]*message)
+
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			ts := spec.(*ast.TypeSpec)
+			st, ok := ts.Type.(*ast.StructType)
+			if !ok || !hasDirective(gen.Doc, ts.Doc) {
+				continue
+			}
+
+			m := &message{name: ts.Name.Name}
+			for _, f := range st.Fields.List {
+				if len(f.Names) != 1 {
+					return nil, fmt.Errorf("%s: 不支持匿名或多名字段", m.name)
+				}
+				fd, err := parseField(f, byName)
+				if err != nil {
+					return nil, fmt.Errorf("%s.%s: %w", m.name, f.Names[0].Name, err)
+				}
+
+				switch {
+				case isOptional(f):
+					if fd.kind == kindStruct {
+						return nil, fmt.Errorf("%s.%s: 可选字段不支持结构体类型", m.name, fd.name)
+					}
+					m.groups = append(m.groups, []field{fd})
+				case m.hasOptional():
+					if fd.kind == kindStruct {
+						return nil, fmt.Errorf("%s.%s: 可选字段不支持结构体类型", m.name, fd.name)
+					}
+					m.groups[len(m.groups)-1] = append(m.groups[len(m.groups)-1], fd)
+				default:
+					m.required = append(m.required, fd)
+				}
+			}
+
+			// 带可选字段的结构体解码时会消耗剩余的全部数据，只能作为最后一个字段嵌套
+			for i, fd := range m.required {
+				if fd.kind == kindStruct && byName[fd.typ].hasOptional() && (i != len(m.required)-1 || m.hasOptional()) {
+					return nil, fmt.Errorf("%s.%s: 含可选字段的结构体只能作为最后一个字段", m.name, fd.name)
+				}
+			}
+
+			msgs = append(msgs, m)
+			byName[m.name] = m
+		}
+	}
+	return msgs, nil
+}
+
+// hasDirective 检查类型的文档注释中是否有生成指令
+func hasDirective(groups ...*ast.CommentGroup) bool {
+	for _, g := range groups {
+		if g == nil {
+			continue
+		}
+		for _, c := range g.List {
+			if strings.TrimSpace(c.Text) == directive {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func isOptional(f *ast.Field) bool {
+	if f.Tag == nil {
+		return false
+	}
+	tag, err := strconv.Unquote(f.Tag.Value)
+	if err != nil {
+		return false
+	}
+	return reflect.StructTag(tag).Get("bin") == "optional"
+}
+
+func parseField(f *ast.Field, known map[string]*message) (field, error) {
+	fd := field{name: f.Names[0].Name}
+	switch t := f.Type.(type) {
+	case *ast.Ident:
+		switch t.Name {
+		case kindString, kindBool, kindInt:
+			fd.kind = t.Name
+		default:
+			if _, ok := known[t.Name]; !ok {
+				return fd, fmt.Errorf("结构体 %s 需要先声明并带有 %s 指令", t.Name, directive)
+			}
+			fd.kind, fd.typ = kindStruct, t.Name
+		}
+	case *ast.ArrayType:
+		if ident, ok := t.Elt.(*ast.Ident); ok && t.Len == nil && ident.Name == kindString {
+			fd.kind = kindStrings
+		}
+	}
+	if fd.kind == "" {
+		return fd, fmt.Errorf("不支持的字段类型")
+	}
+	return fd, nil
+}
+
+// parseTypeConsts 解析以 Type 开头的 uint8 消息类型常量
+func parseTypeConsts(file *ast.File) ([]typeConst, error) {
+	var consts []typeConst
+	seen := make(map[string]string)
+
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.CONST {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			vs := spec.(*ast.ValueSpec)
+			ident, ok := vs.Type.(*ast.Ident)
+			if !ok || ident.Name != "uint8" || len(vs.Names) != 1 || len(vs.Values) != 1 {
+				continue
+			}
+			name := vs.Names[0].Name
+			if !strings.HasPrefix(name, "Type") {
+				continue
+			}
+			lit, ok := vs.Values[0].(*ast.BasicLit)
+			if !ok || lit.Kind != token.INT {
+				return nil, fmt.Errorf("%s: 消息类型必须是整数字面量", name)
+			}
+			v, err := strconv.ParseUint(lit.Value, 0, 8)
+			if err != nil || v >= 0x80 {
+				return nil, fmt.Errorf("%s: 消息类型取值必须小于 0x80", name)
+			}
+			key := strconv.FormatUint(v, 10)
+			if prev, ok := seen[key]; ok {
+				return nil, fmt.Errorf("%s: 与 %s 取值重复", name, prev)
+			}
+			seen[key] = name
+			consts = append(consts, typeConst{name: name})
+		}
+	}
+	return consts, nil
+}
+
+func writeSource(path string, src []byte) error {
+	formatted, err := format.Source(src)
+	if err != nil {
+		return fmt.Errorf("%s: %w\n%s", path, err, src)
+	}
+	return os.WriteFile(path, formatted, 0644)
+}
+
+func header(b *bytes.Buffer, pkg, in string) {
+	fmt.Fprintf(b, "// Code generated by protogen from %s; DO NOT EDIT.\n\n", in)
+	fmt.Fprintf(b, "package %s\n\n", pkg)
+}
+
+// genCodec 生成编解码实现和 GetTypeName
+func genCodec(pkg, in string, msgs []*message, consts []typeConst) []byte {
+	var b bytes.Buffer
+	header(&b, pkg, in)
+
+	b.WriteString("// GetTypeName 返回消息类型的可读名称\n")
+	b.WriteString("func GetTypeName(t uint8) string {\n\tswitch t {\n")
+	for _, c := range consts {
+		fmt.Fprintf(&b, "\tcase %s:\n\t\treturn %q\n", c.name, strings.TrimPrefix(c.name, "Type"))
+	}
+	b.WriteString("\tdefault:\n\t\treturn \"Unknown\"\n\t}\n}\n")
+
+	for _, m := range msgs {
+		genEncode(&b, m)
+		genDecode(&b, m)
+	}
+	return b.Bytes()
+}
+
+func genEncode(b *bytes.Buffer, m *message) {
+	r := recv
+
+	fmt.Fprintf(b, "\n// EncodeBinary %s 二进制编码实现\n", m.name)
+	fmt.Fprintf(b, "func (%s *%s) EncodeBinary() ([]byte, error) {\n", r, m.name)
+	fmt.Fprintf(b, "\treturn %s.appendBinary(nil), nil\n}\n", r)
+
+	fmt.Fprintf(b, "\n// appendBinary 将 %s 的二进制编码追加到 buf\n", m.name)
+	fmt.Fprintf(b, "func (%s *%s) appendBinary(buf []byte) []byte {\n", r, m.name)
+	for _, f := range m.required {
+		writeAppend(b, r, f, "\t")
+	}
+
+	// 可选字段组嵌套写入：后面的组存在时前面的组也必须写入
+	indent := "\t"
+	for i := range m.groups {
+		var conds []string
+		for _, g := range m.groups[i:] {
+			for _, f := range g {
+				conds = append(conds, nonZero(r, f))
+			}
+		}
+		if i == 0 {
+			fmt.Fprintf(b, "%s// 可选字段均为零值时不写入，保持旧版本格式\n", indent)
+		}
+		fmt.Fprintf(b, "%sif %s {\n", indent, strings.Join(conds, " || "))
+		indent += "\t"
+		for _, f := range m.groups[i] {
+			writeAppend(b, r, f, indent)
+		}
+	}
+	for range m.groups {
+		indent = indent[1:]
+		fmt.Fprintf(b, "%s}\n", indent)
+	}
+	b.WriteString("\treturn buf\n}\n")
+}
+
+func writeAppend(b *bytes.Buffer, r string, f field, indent string) {
+	switch f.kind {
+	case kindString:
+		fmt.Fprintf(b, "%sbuf = appendString(buf, %s.%s)\n", indent, r, f.name)
+	case kindBool:
+		fmt.Fprintf(b, "%sbuf = appendBool(buf, %s.%s)\n", indent, r, f.name)
+	case kindInt:
+		fmt.Fprintf(b, "%sbuf = appendInt(buf, %s.%s)\n", indent, r, f.name)
+	case kindStrings:
+		fmt.Fprintf(b, "%sbuf = appendStrings(buf, %s.%s)\n", indent, r, f.name)
+	case kindStruct:
+		fmt.Fprintf(b, "%sbuf = %s.%s.appendBinary(buf)\n", indent, r, f.name)
+	}
+}
+
+func nonZero(r string, f field) string {
+	switch f.kind {
+	case kindString:
+		return fmt.Sprintf("%s.%s != \"\"", r, f.name)
+	case kindBool:
+		return fmt.Sprintf("%s.%s", r, f.name)
+	case kindInt:
+		return fmt.Sprintf("%s.%s != 0", r, f.name)
+	default:
+		return fmt.Sprintf("%s.%s != nil", r, f.name)
+	}
+}
+
+func isZero(r string, f field) string {
+	switch f.kind {
+	case kindString:
+		return fmt.Sprintf("%s.%s == \"\"", r, f.name)
+	case kindBool:
+		return fmt.Sprintf("!%s.%s", r, f.name)
+	case kindInt:
+		return fmt.Sprintf("%s.%s == 0", r, f.name)
+	default:
+		return fmt.Sprintf("%s.%s == nil", r, f.name)
+	}
+}
+
+func genDecode(b *bytes.Buffer, m *message) {
+	r := recv
+
+	fmt.Fprintf(b, "\n// DecodeBinary %s 二进制解码实现\n", m.name)
+	fmt.Fprintf(b, "func (%s *%s) DecodeBinary(data []byte) error {\n", r, m.name)
+	fmt.Fprintf(b, "\toffset, err := %s.decodeFrom(data)\n", r)
+	b.WriteString("\tif err != nil {\n\t\treturn err\n\t}\n")
+	b.WriteString("\treturn checkEnd(data, offset)\n}\n")
+
+	fmt.Fprintf(b, "\n// decodeFrom 从 data 开头解码 %s，返回消耗的字节数\n", m.name)
+	fmt.Fprintf(b, "func (%s *%s) decodeFrom(data []byte) (int, error) {\n", r, m.name)
+	fmt.Fprintf(b, "\t*%s = %s{}\n", r, m.name)
+	if len(m.required) == 0 && len(m.groups) == 0 {
+		b.WriteString("\treturn 0, nil\n}\n")
+		return
+	}
+	b.WriteString("\tvar offset, n int\n\tvar err error\n")
+	for _, f := range m.required {
+		writeDecode(b, r, f)
+	}
+	for i, g := range m.groups {
+		if i == 0 {
+			b.WriteString("\n\t// 可选字段（旧版本不携带）\n")
+		}
+		b.WriteString("\tif offset == len(data) {\n\t\treturn offset, nil\n\t}\n")
+		var conds []string
+		for _, f := range g {
+			writeDecode(b, r, f)
+			conds = append(conds, isZero(r, f))
+		}
+		b.WriteString("\t// 均为零值的可选字段组应省略，保证编码唯一\n")
+		fmt.Fprintf(b, "\tif %s && offset == len(data) {\n\t\treturn 0, ErrInvalidMsg\n\t}\n", strings.Join(conds, " && "))
+	}
+	b.WriteString("\treturn offset, nil\n}\n")
+}
+
+func writeDecode(b *bytes.Buffer, r string, f field) {
+	switch f.kind {
+	case kindString:
+		fmt.Fprintf(b, "\tif %s.%s, n, err = decodeString(data[offset:]); err != nil {\n", r, f.name)
+	case kindBool:
+		fmt.Fprintf(b, "\tif %s.%s, n, err = decodeBool(data[offset:]); err != nil {\n", r, f.name)
+	case kindInt:
+		fmt.Fprintf(b, "\tif %s.%s, n, err = decodeInt(data[offset:]); err != nil {\n", r, f.name)
+	case kindStrings:
+		fmt.Fprintf(b, "\tif %s.%s, n, err = decodeStrings(data[offset:]); err != nil {\n", r, f.name)
+	case kindStruct:
+		fmt.Fprintf(b, "\tif n, err = %s.%s.decodeFrom(data[offset:]); err != nil {\n", r, f.name)
+	}
+	b.WriteString("\t\treturn 0, err\n\t}\n\toffset += n\n")
+}
+
+// genTests 生成消息列表和往返测试
+func genTests(pkg, in string, msgs []*message) []byte {
+	var b bytes.Buffer
+	header(&b, pkg, in)
+	b.WriteString("import (\n\t\"reflect\"\n\t\"testing\"\n)\n\n")
+
+	b.WriteString("// binaryMessages 返回所有二进制消息类型的新实例\n")
+	b.WriteString("func binaryMessages() []BinaryMessage {\n\treturn []BinaryMessage{\n")
+	for _, m := range msgs {
+		fmt.Fprintf(&b, "\t\t&%s{},\n", m.name)
+	}
+	b.WriteString("\t}\n}\n\n")
+
+	byName := make(map[string]*message)
+	for _, m := range msgs {
+		byName[m.name] = m
+	}
+
+	b.WriteString("// TestGeneratedRoundTrip 测试生成的编解码对零值和全字段赋值的消息往返一致\n")
+	b.WriteString("func TestGeneratedRoundTrip(t *testing.T) {\n\ttests := []BinaryMessage{\n")
+	for _, m := range msgs {
+		fmt.Fprintf(&b, "\t\t&%s{},\n", m.name)
+		fmt.Fprintf(&b, "\t\t&%s,\n", sampleLiteral(m, byName))
+	}
+	b.WriteString(`	}
+
+	for _, want := range tests {
+		data, err := want.EncodeBinary()
+		if err != nil {
+			t.Fatalf("%T EncodeBinary failed: %v", want, err)
+		}
+		got := reflect.New(reflect.TypeOf(want).Elem()).Interface().(BinaryMessage)
+		if err := got.DecodeBinary(data); err != nil {
+			t.Fatalf("%T DecodeBinary failed: %v", want, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("往返结果不一致:\n got %+v\nwant %+v", got, want)
+		}
+	}
+}
+`)
+	return b.Bytes()
+}
+
+// sampleLiteral 生成所有字段均为非零值的结构体字面量
+func sampleLiteral(m *message, byName map[string]*message) string {
+	var parts []string
+	fields := append([]field(nil), m.required...)
+	for _, g := range m.groups {
+		fields = append(fields, g...)
+	}
+	for i, f := range fields {
+		var v string
+		switch f.kind {
+		case kindString:
+			v = strconv.Quote(strings.ToLower(f.name))
+		case kindBool:
+			v = "true"
+		case kindInt:
+			v = strconv.Itoa(i + 1)
+		case kindStrings:
+			v = `[]string{"a", "b"}`
+		case kindStruct:
+			v = sampleLiteral(byName[f.typ], byName)
+		}
+		parts = append(parts, f.name+": "+v)
+	}
+	return m.name + "{" + strings.Join(parts, ", ") + "}"
+}
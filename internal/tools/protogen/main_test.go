@@ -0,0 +1,37 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestGeneratedUpToDate 测试仓库中的生成代码与结构体定义一致，修改 types.go 后需执行 go generate
+func TestGeneratedUpToDate(t *testing.T) {
+	dir := filepath.Join("..", "..", "pkg", "proto")
+	tmp := t.TempDir()
+	out := filepath.Join(tmp, "codec_gen.go")
+	testOut := filepath.Join(tmp, "codec_gen_test.go")
+
+	// 生成文件头记录的是相对路径，需在 proto 目录下执行
+	t.Chdir(dir)
+
+	if err := run("types.go", out, testOut); err != nil {
+		t.Fatalf("生成失败: %v", err)
+	}
+
+	for _, name := range []string{"codec_gen.go", "codec_gen_test.go"} {
+		want, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := os.ReadFile(filepath.Join(tmp, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s 已过期，请在 internal/pkg/proto 目录执行 go generate", name)
+		}
+	}
+}